@@ -0,0 +1,400 @@
+package gitobjects
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sort"
+)
+
+// How many objects of a type there are, and their total decompressed size
+type ObjectTypeStats struct {
+	Count     int64
+	SizeBytes int64
+}
+
+// One entry in a ranked list of an AnalysisReport. What Value measures
+// depends on the list.
+type AnalysisItem struct {
+	// The blob, tree or commit
+	Sha1 string
+
+	// For path lists, the path, in the tree of the commit named by Sha1
+	Path string
+
+	// For checkouts, the number of files
+	Files int64
+
+	Value int64
+}
+
+// The size and shape of a repository, in the style of git-sizer. Each list
+// is sorted with the largest Value first, and holds at most topN items.
+type AnalysisReport struct {
+	// Keyed by "commit", "tree", "blob" and "tag"
+	ObjectTypes map[string]ObjectTypeStats
+
+	// Value is the decompressed size of the blob
+	LargestBlobs []*AnalysisItem
+
+	// Value is the number of entries in the tree
+	LargestTrees []*AnalysisItem
+
+	// Value is the number of path components; Sha1 is a commit
+	// whose tree has the path
+	DeepestPaths []*AnalysisItem
+
+	// Value is the length of the path in bytes; Sha1 is a commit
+	// whose tree has the path
+	LongestPaths []*AnalysisItem
+
+	// Value is the number of parents of the commit
+	MostParents []*AnalysisItem
+
+	// Value is the total size of the files that checking out the
+	// commit would create
+	LargestCheckouts []*AnalysisItem
+
+	// Blobs which are Git LFS pointers, and those whose large files are
+	// not in the local LFS storage. SizeBytes is the total size of the
+	// large files.
+	LFSPointers       ObjectTypeStats
+	MissingLFSObjects ObjectTypeStats
+
+	// Value is the size of the large file; Sha1 is the pointer blob
+	LargestLFSObjects        []*AnalysisItem
+	LargestMissingLFSObjects []*AnalysisItem
+}
+
+// The number of items in each list of the report, if topN is not positive
+const defaultAnalysisTopN = 10
+
+// Measure every object in the object database, to find what makes a repository
+// large or slow: huge blobs, wide trees, deep or long paths, octopus merges and
+// huge checkouts. Paths and checkouts are measured for every commit, but each
+// distinct tree is only measured once.
+func (self *Repo) Analyze(ctx context.Context, topN int) (*AnalysisReport, error) {
+	if topN <= 0 {
+		topN = defaultAnalysisTopN
+	}
+	report := &AnalysisReport{
+		ObjectTypes: make(map[string]ObjectTypeStats),
+	}
+	for _, objectType := range streamableObjectTypes {
+		report.ObjectTypes[objectType] = ObjectTypeStats{}
+	}
+
+	// Blobs come with their sizes; the rest are looked at afterwards
+	options := DefaultStreamOptions()
+	options.InstantiateObjects = false
+	blobSizes := make(map[string]int64)
+	sha1sByType := make(map[string][]string)
+	objectChan, errorChan := self.StreamObjectsOfTypes(ctx, nil, options)
+	for obj := range objectChan {
+		if blob, ok := obj.(*Blob); ok {
+			// Already known, so this does not run git
+			size, err := blob.DecompressedSizeBytes(self)
+			if err != nil {
+				// Let the stream finish before returning
+				for range objectChan {
+				}
+				<-errorChan
+				return nil, err
+			}
+			blobSizes[blob.Sha1()] = int64(size)
+		} else {
+			sha1sByType[obj.Type()] = append(sha1sByType[obj.Type()], obj.Sha1())
+		}
+	}
+	err := <-errorChan
+	if err != nil {
+		return nil, err
+	}
+	// A canceled stream ends early, without an error
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	largestBlobs := newAnalysisTopN(topN)
+	for sha1, size := range blobSizes {
+		report._count("blob", size)
+		largestBlobs.Add("", &AnalysisItem{Sha1: sha1, Value: size})
+	}
+	report.LargestBlobs = largestBlobs.Items()
+
+	err = self._analyzeLFSPointers(ctx, report, blobSizes, topN)
+	if err != nil {
+		return nil, err
+	}
+
+	batchCheck, err := newCatFileBatchCheck(self)
+	if err != nil {
+		return nil, err
+	}
+	defer batchCheck.Close()
+	for _, objectType := range []string{"commit", "tree", "tag"} {
+		for _, sha1 := range sha1sByType[objectType] {
+			_, size, err := batchCheck.Check(sha1)
+			if err != nil {
+				return nil, err
+			}
+			report._count(objectType, size)
+		}
+	}
+
+	analyzer := &treeAnalyzer{
+		repo:      self,
+		blobSizes: blobSizes,
+		summaries: make(map[string]*treeSummary),
+	}
+	largestTrees := newAnalysisTopN(topN)
+	for _, sha1 := range sha1sByType["tree"] {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		entries, err := analyzer.Entries(sha1)
+		if err != nil {
+			return nil, err
+		}
+		largestTrees.Add("", &AnalysisItem{Sha1: sha1, Value: int64(len(entries))})
+	}
+	report.LargestTrees = largestTrees.Items()
+
+	// Commits which share a tree share a checkout, so only the first
+	// commit seen with each tree, or each path, is kept
+	deepestPaths := newAnalysisTopN(topN)
+	longestPaths := newAnalysisTopN(topN)
+	mostParents := newAnalysisTopN(topN)
+	largestCheckouts := newAnalysisTopN(topN)
+	for _, sha1 := range sha1sByType["commit"] {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		commit, err := self.GetCommit(sha1)
+		if err != nil {
+			return nil, err
+		}
+		mostParents.Add("", &AnalysisItem{Sha1: sha1, Value: int64(len(commit.ParentSha1s()))})
+
+		summary, err := analyzer.Summarize(commit.TreeSha1())
+		if err != nil {
+			return nil, errors.Wrapf(err, "Analyzing tree of commit %s", sha1)
+		}
+		largestCheckouts.Add(commit.TreeSha1(), &AnalysisItem{
+			Sha1:  sha1,
+			Files: summary.files,
+			Value: summary.sizeBytes,
+		})
+		if summary.depth == 0 {
+			// An empty tree has no paths
+			continue
+		}
+		deepestPaths.Add(summary.deepestPath, &AnalysisItem{
+			Sha1:  sha1,
+			Path:  summary.deepestPath,
+			Value: summary.depth,
+		})
+		longestPaths.Add(summary.longestPath, &AnalysisItem{
+			Sha1:  sha1,
+			Path:  summary.longestPath,
+			Value: int64(len(summary.longestPath)),
+		})
+	}
+	report.DeepestPaths = deepestPaths.Items()
+	report.LongestPaths = longestPaths.Items()
+	report.MostParents = mostParents.Items()
+	report.LargestCheckouts = largestCheckouts.Items()
+	return report, nil
+}
+
+// Read the blobs small enough to be Git LFS pointers, and report on the
+// large files they point to
+func (self *Repo) _analyzeLFSPointers(ctx context.Context, report *AnalysisReport,
+	blobSizes map[string]int64, topN int) error {
+
+	batch, err := newCatFileBatch(self)
+	if err != nil {
+		return err
+	}
+	defer batch.Close()
+
+	largest := newAnalysisTopN(topN)
+	largestMissing := newAnalysisTopN(topN)
+	for sha1, size := range blobSizes {
+		if size < lfsPointerMinSize || size > lfsPointerMaxSize {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		_, contents, err := batch.Read(sha1)
+		if err != nil {
+			return err
+		}
+		pointer := ParseLFSPointer(contents)
+		if pointer == nil {
+			continue
+		}
+		report.LFSPointers.Count++
+		report.LFSPointers.SizeBytes += pointer.SizeBytes
+		largest.Add("", &AnalysisItem{Sha1: sha1, Value: pointer.SizeBytes})
+
+		has, err := self.HasLFSObject(pointer)
+		if err != nil {
+			return err
+		}
+		if !has {
+			report.MissingLFSObjects.Count++
+			report.MissingLFSObjects.SizeBytes += pointer.SizeBytes
+			largestMissing.Add("", &AnalysisItem{Sha1: sha1, Value: pointer.SizeBytes})
+		}
+	}
+	report.LargestLFSObjects = largest.Items()
+	report.LargestMissingLFSObjects = largestMissing.Items()
+	return nil
+}
+
+func (self *AnalysisReport) _count(objectType string, size int64) {
+	stats := self.ObjectTypes[objectType]
+	stats.Count++
+	stats.SizeBytes += size
+	self.ObjectTypes[objectType] = stats
+}
+
+// What a checkout of a tree would look like
+type treeSummary struct {
+	// The path with the most components, and how many it has
+	deepestPath string
+	depth       int64
+
+	// The path with the most bytes
+	longestPath string
+
+	// The files in the checkout, and their total size
+	files     int64
+	sizeBytes int64
+}
+
+// Summarizes trees, remembering the summary of each one, as most trees
+// are shared by many commits
+type treeAnalyzer struct {
+	repo *Repo
+
+	// Key = sha1, Value = decompressed size
+	blobSizes map[string]int64
+
+	// Key = sha1
+	summaries map[string]*treeSummary
+}
+
+// Return the entries of a tree. Subtrees are not instantiated, as
+// each is visited on its own.
+func (self *treeAnalyzer) Entries(sha1 string) ([]*Entry, error) {
+	tree, err := self.repo._cachedTree(sha1)
+	if err != nil {
+		return nil, err
+	}
+	err = tree._instantiate(self.repo, false)
+	if err != nil {
+		return nil, err
+	}
+	return tree.Entries(self.repo)
+}
+
+func (self *treeAnalyzer) Summarize(sha1 string) (*treeSummary, error) {
+	if summary, has := self.summaries[sha1]; has {
+		return summary, nil
+	}
+	entries, err := self.Entries(sha1)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &treeSummary{}
+	for _, entry := range entries {
+		deepestPath, depth := entry.name, int64(1)
+		longestPath := entry.name
+		switch entry.Type() {
+		case "tree":
+			subtreeSummary, err := self.Summarize(entry.sha1)
+			if err != nil {
+				return nil, err
+			}
+			if subtreeSummary.depth == 0 {
+				continue
+			}
+			deepestPath = entry.name + "/" + subtreeSummary.deepestPath
+			depth += subtreeSummary.depth
+			longestPath = entry.name + "/" + subtreeSummary.longestPath
+			summary.files += subtreeSummary.files
+			summary.sizeBytes += subtreeSummary.sizeBytes
+		case "blob":
+			summary.files++
+			summary.sizeBytes += self.blobSizes[entry.sha1]
+		case "commit":
+			// A submodule is a path, but its files are in another repository
+		}
+
+		if depth > summary.depth {
+			summary.deepestPath, summary.depth = deepestPath, depth
+		}
+		if len(longestPath) > len(summary.longestPath) {
+			summary.longestPath = longestPath
+		}
+	}
+	self.summaries[sha1] = summary
+	return summary, nil
+}
+
+// Keeps the n items with the largest Values, without holding on to
+// every item that was added. If n is not positive, every item is kept.
+type analysisTopN struct {
+	n     int
+	items []*AnalysisItem
+
+	// Keys which have already been added
+	keys map[string]bool
+}
+
+func newAnalysisTopN(n int) *analysisTopN {
+	return &analysisTopN{
+		n:    n,
+		keys: make(map[string]bool),
+	}
+}
+
+// Add an item. If key is not "", only the first item with that key is kept.
+func (self *analysisTopN) Add(key string, item *AnalysisItem) {
+	if key != "" {
+		if self.keys[key] {
+			return
+		}
+		self.keys[key] = true
+	}
+	self.items = append(self.items, item)
+	if self.n > 0 && len(self.items) > 2*self.n {
+		self._trim()
+	}
+}
+
+// The largest items, largest first
+func (self *analysisTopN) Items() []*AnalysisItem {
+	self._trim()
+	return self.items
+}
+
+// Ties are broken by sha1 and path, so that reports are repeatable
+func (self *analysisTopN) _trim() {
+	sort.Slice(self.items, func(i, j int) bool {
+		a, b := self.items[i], self.items[j]
+		if a.Value != b.Value {
+			return a.Value > b.Value
+		}
+		if a.Sha1 != b.Sha1 {
+			return a.Sha1 < b.Sha1
+		}
+		return a.Path < b.Path
+	})
+	if self.n > 0 && len(self.items) > self.n {
+		self.items = self.items[:self.n]
+	}
+}
@@ -0,0 +1,90 @@
+package gitobjects
+
+import (
+	"context"
+	. "gopkg.in/check.v1"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (s *MySuite) TestAnalyze(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+
+	revParse := func(revision string) string {
+		sha1, err := repo.ResolveRevision(revision)
+		c.Assert(err, IsNil)
+		return sha1
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	defer cancelFunc()
+
+	report, err := repo.Analyze(ctx, 2)
+	c.Assert(err, IsNil)
+
+	// README, src/main.go and docs/api/index.md
+	c.Check(report.ObjectTypes["blob"], Equals, ObjectTypeStats{Count: 3, SizeBytes: 5 + 12 + 18})
+	c.Check(report.ObjectTypes["commit"].Count, Equals, int64(2))
+	c.Check(report.ObjectTypes["tree"].Count, Equals, int64(5))
+	c.Check(report.ObjectTypes["tag"], Equals, ObjectTypeStats{})
+	commitBytes := int64(0)
+	for _, revision := range []string{"HEAD", "HEAD~1"} {
+		output, err := repo.CmdOutput([]string{"cat-file", "-s", revision})
+		c.Assert(err, IsNil)
+		size, err := strconv.ParseInt(strings.TrimRight(string(output), "\n"), 10, 64)
+		c.Assert(err, IsNil)
+		commitBytes += size
+	}
+	c.Check(report.ObjectTypes["commit"].SizeBytes, Equals, commitBytes)
+
+	c.Assert(len(report.LargestBlobs), Equals, 2)
+	c.Check(*report.LargestBlobs[0], Equals, AnalysisItem{Sha1: revParse("HEAD:docs/api/index.md"), Value: 18})
+	c.Check(*report.LargestBlobs[1], Equals, AnalysisItem{Sha1: revParse("HEAD:src/main.go"), Value: 12})
+
+	c.Assert(len(report.LargestTrees), Equals, 2)
+	c.Check(*report.LargestTrees[0], Equals, AnalysisItem{Sha1: revParse("HEAD^{tree}"), Value: 3})
+
+	// The same path is only reported once
+	c.Assert(len(report.DeepestPaths), Equals, 2)
+	c.Check(*report.DeepestPaths[0], Equals, AnalysisItem{
+		Sha1:  revParse("HEAD"),
+		Path:  "docs/api/index.md",
+		Value: 3,
+	})
+	c.Check(*report.DeepestPaths[1], Equals, AnalysisItem{Sha1: revParse("HEAD~1"), Path: "README", Value: 1})
+	c.Check(report.LongestPaths[0].Path, Equals, "docs/api/index.md")
+	c.Check(report.LongestPaths[0].Value, Equals, int64(17))
+
+	c.Check(*report.MostParents[0], Equals, AnalysisItem{Sha1: revParse("HEAD"), Value: 1})
+
+	c.Assert(len(report.LargestCheckouts), Equals, 2)
+	c.Check(*report.LargestCheckouts[0], Equals, AnalysisItem{Sha1: revParse("HEAD"), Files: 3, Value: 35})
+	c.Check(*report.LargestCheckouts[1], Equals, AnalysisItem{Sha1: revParse("HEAD~1"), Files: 1, Value: 5})
+}
+
+func (s *MySuite) TestAnalyzeDuplicateObjects(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	duplicateObjects(c, repo, repoDir)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	defer cancelFunc()
+
+	report, err := repo.Analyze(ctx, 10)
+	c.Assert(err, IsNil)
+
+	// Objects in both packs are only counted once, as the stream sends each once
+	for objectType, count := range map[string]int64{"commit": 3, "tree": 2, "blob": 2} {
+		c.Check(report.ObjectTypes[objectType].Count, Equals, count, Commentf(objectType))
+	}
+	for _, items := range [][]*AnalysisItem{report.LargestTrees, report.MostParents, report.LargestCheckouts} {
+		seen := make(map[string]bool)
+		for _, item := range items {
+			c.Check(seen[item.Sha1], Equals, false, Commentf(item.Sha1))
+			seen[item.Sha1] = true
+		}
+	}
+	c.Check(report.MostParents, HasLen, 3)
+	c.Check(report.LargestTrees, HasLen, 2)
+}
@@ -0,0 +1,410 @@
+package gitobjects
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// The state of one attribute for a path
+type AttributeState int
+
+const (
+	// No line gives the attribute, or a "!attr" line resets it
+	AttributeUnspecified AttributeState = iota
+
+	// "attr"
+	AttributeSet
+
+	// "-attr"
+	AttributeUnset
+
+	// "attr=value"
+	AttributeValue
+)
+
+// One attribute for a path. The zero value is unspecified.
+type Attribute struct {
+	State AttributeState
+
+	// Only for AttributeValue
+	Value string
+}
+
+// "set", "unset", "unspecified" or the value, as "git check-attr" shows it
+func (self Attribute) String() string {
+	switch self.State {
+	case AttributeSet:
+		return "set"
+	case AttributeUnset:
+		return "unset"
+	case AttributeValue:
+		return self.Value
+	default:
+		return "unspecified"
+	}
+}
+
+// git's only built-in macro
+const builtinAttributes = "[attr]binary -diff -merge -text\n"
+
+// One attribute on a .gitattributes line
+type attributeAssignment struct {
+	name      string
+	attribute Attribute
+}
+
+// A line of a .gitattributes file, which either gives attributes to the
+// paths matching a pattern or defines a macro
+type attributeLine struct {
+	pattern *pathPattern
+
+	// The name of the macro, for "[attr]name ..." lines
+	macro string
+
+	assignments []attributeAssignment
+}
+
+// Evaluates .gitattributes files the way git does. They are read from a
+// Tree, in each directory as needed, along with info/attributes,
+// core.attributesFile and the built-in "binary" macro. info/attributes
+// wins over everything, then a deeper .gitattributes wins over a
+// shallower one, and a later line over an earlier one. Macros can only be
+// defined at the top of the tree or outside it. It is safe for concurrent
+// use.
+type AttributeMatcher struct {
+	sync.Mutex
+
+	// Returns the contents of the .gitattributes in a directory, or nil
+	readAttributesFile func(dir string) ([]byte, error)
+
+	// info/attributes
+	infoLines []*attributeLine
+
+	// core.attributesFile, then the built-in macro
+	globalLines [][]*attributeLine
+
+	// core.ignoreCase
+	caseFold bool
+
+	// Parsed .gitattributes files, keyed by directory
+	dirLines map[string][]*attributeLine
+
+	// Macro definitions by name; nil until the top .gitattributes is read
+	macros map[string]*attributeLine
+}
+
+// Return the attributes of a slash-separated path in a Tree, such as the
+// root tree of a commit. A path ending in "/" is a directory. Attributes
+// which are not in the map are unspecified. For many paths, use an
+// AttributeMatcher, which only reads each .gitattributes once.
+func Attributes(repo *Repo, tree *Tree, path string) (map[string]Attribute, error) {
+	matcher, err := repo.NewAttributeMatcher(tree)
+	if err != nil {
+		return nil, err
+	}
+	return matcher.Attributes(path)
+}
+
+// Return an AttributeMatcher which reads the .gitattributes blobs in a Tree
+func (self *Repo) NewAttributeMatcher(tree *Tree) (*AttributeMatcher, error) {
+	matcher := &AttributeMatcher{
+		readAttributesFile: self._treeFileReader(tree, ".gitattributes"),
+		dirLines:           make(map[string][]*attributeLine),
+	}
+
+	config, err := self.Config()
+	if err != nil {
+		return nil, err
+	}
+	matcher.caseFold, err = config.GetBool("core.ignoreCase", false)
+	if err != nil {
+		return nil, err
+	}
+
+	// info/ is shared by every work tree, as git shares it
+	commonDir, err := self._commonDir()
+	if err != nil {
+		return nil, err
+	}
+	contents, err := _readOptionalFile(filepath.Join(commonDir, "info", "attributes"))
+	if err != nil {
+		return nil, err
+	}
+	matcher.infoLines = _parseAttributeLines(contents, "", true)
+
+	attributesFile, err := config.GetPath("core.attributesFile", _xdgConfigPath("attributes"))
+	if err != nil {
+		return nil, err
+	}
+	if attributesFile != "" {
+		contents, err := _readOptionalFile(attributesFile)
+		if err != nil {
+			return nil, err
+		}
+		matcher.globalLines = append(matcher.globalLines, _parseAttributeLines(contents, "", true))
+	}
+	matcher.globalLines = append(matcher.globalLines, _parseAttributeLines([]byte(builtinAttributes), "", true))
+	return matcher, nil
+}
+
+// Read a file, returning nil if it does not exist
+func _readOptionalFile(filePath string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Reading %s", filePath)
+	}
+	return contents, nil
+}
+
+// Return the attributes of a slash-separated path relative to the top of
+// the Tree. A path ending in "/" is a directory. Attributes which are not
+// in the map are unspecified.
+func (self *AttributeMatcher) Attributes(path string) (map[string]Attribute, error) {
+	isDir := strings.HasSuffix(path, "/")
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil, errors.New("Empty path given to Attributes")
+	}
+
+	self.Lock()
+	defer self.Unlock()
+
+	// From the highest priority to the lowest
+	stack := [][]*attributeLine{self.infoLines}
+	dir := path
+	for dir != "" {
+		if i := strings.LastIndexByte(dir, '/'); i >= 0 {
+			dir = dir[:i]
+		} else {
+			dir = ""
+		}
+		lines, err := self._linesForDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, lines)
+	}
+	stack = append(stack, self.globalLines...)
+
+	if self.macros == nil {
+		self.macros = make(map[string]*attributeLine)
+		for _, lines := range stack {
+			for i := len(lines) - 1; i >= 0; i-- {
+				line := lines[i]
+				if _, ok := self.macros[line.macro]; line.macro != "" && !ok {
+					self.macros[line.macro] = line
+				}
+			}
+		}
+	}
+
+	// The first line to give an attribute decides it, so lines are
+	// visited from the highest priority down
+	attributes := make(map[string]Attribute)
+	for _, lines := range stack {
+		for i := len(lines) - 1; i >= 0; i-- {
+			line := lines[i]
+			if line.pattern != nil && line.pattern.Matches(path, isDir, self.caseFold) {
+				self._fill(attributes, line)
+			}
+		}
+	}
+
+	for name, attribute := range attributes {
+		if attribute.State == AttributeUnspecified {
+			delete(attributes, name)
+		}
+	}
+	return attributes, nil
+}
+
+// Give the attributes on a line, or in a macro, which are not known yet.
+// A macro which is set gives its own attributes in turn.
+func (self *AttributeMatcher) _fill(attributes map[string]Attribute, line *attributeLine) {
+	for i := len(line.assignments) - 1; i >= 0; i-- {
+		assignment := line.assignments[i]
+		if _, ok := attributes[assignment.name]; ok {
+			continue
+		}
+		attributes[assignment.name] = assignment.attribute
+		if macro, ok := self.macros[assignment.name]; ok && assignment.attribute.State == AttributeSet {
+			self._fill(attributes, macro)
+		}
+	}
+}
+
+func (self *AttributeMatcher) _linesForDir(dir string) ([]*attributeLine, error) {
+	if lines, ok := self.dirLines[dir]; ok {
+		return lines, nil
+	}
+	contents, err := self.readAttributesFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	base := ""
+	if dir != "" {
+		base = dir + "/"
+	}
+	lines := _parseAttributeLines(contents, base, dir == "")
+	self.dirLines[dir] = lines
+	return lines, nil
+}
+
+// Parse the lines of an attributes file whose patterns are relative to
+// base. Lines which git would warn about and skip, such as negative
+// patterns, invalid attribute names and macros where they are not
+// allowed, are skipped.
+func _parseAttributeLines(contents []byte, base string, macrosAllowed bool) []*attributeLine {
+	const blank = " \t\r\n"
+	contents = bytes.TrimPrefix(contents, []byte("\xef\xbb\xbf"))
+	lines := make([]*attributeLine, 0)
+	for _, text := range strings.Split(string(contents), "\n") {
+		text = strings.TrimLeft(text, blank)
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		var name, rest string
+		if unquoted, length, ok := _unquoteCStyle(text); ok {
+			name, rest = unquoted, text[length:]
+		} else {
+			end := strings.IndexAny(text, blank)
+			if end < 0 {
+				end = len(text)
+			}
+			name, rest = text[:end], text[end:]
+		}
+
+		line := &attributeLine{}
+		if len(name) > len("[attr]") && strings.HasPrefix(name, "[attr]") {
+			if !macrosAllowed {
+				continue
+			}
+			line.macro = name[len("[attr]"):]
+			if !_isValidAttributeName(line.macro) {
+				continue
+			}
+		} else {
+			line.pattern = _parsePathPattern(name, base)
+			if line.pattern == nil || line.pattern.negative {
+				continue
+			}
+		}
+
+		valid := true
+		for _, field := range strings.FieldsFunc(rest, func(r rune) bool { return strings.ContainsRune(blank, r) }) {
+			assignment, ok := _parseAttributeAssignment(field)
+			if !ok {
+				valid = false
+				break
+			}
+			line.assignments = append(line.assignments, assignment)
+		}
+		if valid {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Parse "attr", "-attr", "!attr" or "attr=value"
+func _parseAttributeAssignment(field string) (attributeAssignment, bool) {
+	name, value := field, ""
+	hasValue := false
+	if i := strings.IndexByte(field, '='); i >= 0 {
+		name, value, hasValue = field[:i], field[i+1:], true
+	}
+	var attribute Attribute
+	switch {
+	case strings.HasPrefix(name, "-"):
+		name = name[1:]
+		attribute.State = AttributeUnset
+	case strings.HasPrefix(name, "!"):
+		name = name[1:]
+		attribute.State = AttributeUnspecified
+	case hasValue:
+		attribute.State = AttributeValue
+		attribute.Value = value
+	default:
+		attribute.State = AttributeSet
+	}
+	if !_isValidAttributeName(name) {
+		return attributeAssignment{}, false
+	}
+	return attributeAssignment{name: name, attribute: attribute}, true
+}
+
+// Attribute names are made of letters, digits, '-', '.' and '_', do not
+// start with '-', and do not use git's "builtin_" prefix
+func _isValidAttributeName(name string) bool {
+	if name == "" || name[0] == '-' || strings.HasPrefix(name, "builtin_") {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(_isAlpha(c) || _isDigit(c) || c == '-' || c == '.' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Unquote a C-style quoted string at the start of text, as git quotes
+// unusual paths. Returns the string, how much of text it took, and false
+// if text does not start with a well-formed quoted string.
+func _unquoteCStyle(text string) (string, int, bool) {
+	if !strings.HasPrefix(text, "\"") {
+		return "", 0, false
+	}
+	var result strings.Builder
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+		switch c {
+		case '"':
+			return result.String(), i + 1, true
+		case '\\':
+			i++
+			if i == len(text) {
+				return "", 0, false
+			}
+			switch text[i] {
+			case 'a':
+				result.WriteByte('\a')
+			case 'b':
+				result.WriteByte('\b')
+			case 'f':
+				result.WriteByte('\f')
+			case 'n':
+				result.WriteByte('\n')
+			case 'r':
+				result.WriteByte('\r')
+			case 't':
+				result.WriteByte('\t')
+			case 'v':
+				result.WriteByte('\v')
+			case '\\', '"':
+				result.WriteByte(text[i])
+			case '0', '1', '2', '3':
+				// Three octal digits
+				if i+2 >= len(text) || text[i+1] < '0' || text[i+1] > '7' || text[i+2] < '0' || text[i+2] > '7' {
+					return "", 0, false
+				}
+				result.WriteByte((text[i]-'0')<<6 | (text[i+1]-'0')<<3 | (text[i+2] - '0'))
+				i += 2
+			default:
+				return "", 0, false
+			}
+		default:
+			result.WriteByte(c)
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,114 @@
+package gitobjects
+
+import (
+	"fmt"
+	. "gopkg.in/check.v1"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Check that the AttributeMatcher agrees with "git check-attr --all
+// --cached" about each path, when the index matches the tree
+func checkAttributesMatchGit(c *C, repo *Repo, repoDir string, matcher *AttributeMatcher, paths []string) {
+	cmd := repo.Command(append([]string{"check-attr", "--all", "--cached", "--"}, paths...))
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	c.Assert(err, IsNil)
+
+	// "<path>: <attribute>: <state>"
+	expected := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		if line != "" {
+			path := line[:strings.Index(line, ": ")]
+			expected[path] = append(expected[path], line)
+		}
+	}
+	for _, path := range paths {
+		attributes, err := matcher.Attributes(path)
+		c.Assert(err, IsNil)
+		actual := make([]string, 0)
+		for name, attribute := range attributes {
+			actual = append(actual, fmt.Sprintf("%s: %s: %s", path, name, attribute))
+		}
+		sort.Strings(actual)
+		sort.Strings(expected[path])
+		c.Check(strings.Join(actual, "\n"), Equals, strings.Join(expected[path], "\n"))
+	}
+}
+
+func (s *MySuite) TestAttributes(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	writeFiles(c, repoDir, map[string]string{
+		".gitattributes": strings.Join([]string{
+			"# comment",
+			"*.png binary",
+			"*.bin filter=lfs diff=lfs merge=lfs -text",
+			"[attr]generated linguist-generated -diff",
+			"gen/** generated",
+			"*.txt text eol=lf",
+			"docs/*.txt !eol",
+			"\"quoted name\" quoted",
+			"!negative neg",
+			"bad-attr -=x",
+			"*.c -text text=auto",
+			"  indented\tindent",
+			"",
+		}, "\n"),
+		"docs/.gitattributes":     "[attr]notallowed foo\n*.png diff\n*.md generated\nsub/ dironly\n",
+		"docs/sub/.gitattributes": "*.png -binary\r\nimage.png !diff\n",
+		"docs/sub/x":              "",
+	})
+	writeFiles(c, repo.GitDir(), map[string]string{"info/attributes": "*.bin -filter\n"})
+	runGitIn(c, repo, repoDir, "add", ".")
+	runGitIn(c, repo, repoDir, "commit", "-q", "-m", "Add attributes")
+
+	sha1, err := repo.ResolveRevision("HEAD^{tree}")
+	c.Assert(err, IsNil)
+	tree, err := repo.GetTree(sha1)
+	c.Assert(err, IsNil)
+	matcher, err := repo.NewAttributeMatcher(tree)
+	c.Assert(err, IsNil)
+	checkAttributesMatchGit(c, repo, repoDir, matcher, []string{
+		"a.png", "docs/a.png", "docs/sub/a.png", "docs/sub/image.png",
+		"big.bin", "gen/x/y.go", "notes.txt", "docs/notes.txt", "docs/sub/notes.txt",
+		"quoted name", "negative", "bad-attr", "main.c", "indented", "README",
+		"docs/readme.md", "docs/sub/", "docs/sub",
+	})
+
+	attributes, err := Attributes(repo, tree, "big.bin")
+	c.Assert(err, IsNil)
+	c.Check(attributes["diff"], Equals, Attribute{State: AttributeValue, Value: "lfs"})
+	c.Check(attributes["filter"].State, Equals, AttributeUnset)
+	c.Check(attributes["text"].State, Equals, AttributeUnset)
+	c.Check(attributes["binary"].State, Equals, AttributeUnspecified)
+
+	// core.attributesFile has the lowest priority of the files
+	globalFile := filepath.Join(filepath.Dir(repoDir), "global-attributes")
+	writeFiles(c, filepath.Dir(repoDir), map[string]string{"global-attributes": "*.png global\n*.txt eol=crlf\n"})
+	runGitIn(c, repo, repoDir, "config", "core.attributesFile", globalFile)
+	matcher, err = repo.NewAttributeMatcher(tree)
+	c.Assert(err, IsNil)
+	checkAttributesMatchGit(c, repo, repoDir, matcher, []string{"a.png", "notes.txt", "docs/notes.txt"})
+}
+
+func (s *MySuite) TestWorktreeAttributes(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	writeFiles(c, repo.GitDir(), map[string]string{"info/attributes": "README shared\n"})
+	worktreeDir := filepath.Join(c.MkDir(), "worktree")
+	runGitIn(c, repo, repoDir, "worktree", "add", "-q", "-b", "in-worktree", worktreeDir)
+	worktree, err := NewRepo(worktreeDir)
+	c.Assert(err, IsNil)
+
+	// info/attributes is shared by every work tree
+	sha1, err := worktree.ResolveRevision("HEAD^{tree}")
+	c.Assert(err, IsNil)
+	tree, err := worktree.GetTree(sha1)
+	c.Assert(err, IsNil)
+	matcher, err := worktree.NewAttributeMatcher(tree)
+	c.Assert(err, IsNil)
+	checkAttributesMatchGit(c, worktree, worktreeDir, matcher, []string{"README"})
+	attributes, err := matcher.Attributes("README")
+	c.Assert(err, IsNil)
+	c.Check(attributes["shared"].State, Equals, AttributeSet)
+}
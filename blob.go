@@ -4,10 +4,16 @@ import (
 	"github.com/pkg/errors"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type Blob struct {
+	sync.Mutex
 	sha1 string
+
+	// The decompressed size, once it has been looked up
+	size      int
+	sizeKnown bool
 }
 
 func (self *Blob) Type() string {
@@ -22,7 +28,31 @@ func (self *Blob) Instantiate(repo *Repo) error {
 	return nil
 }
 
+// Read the entire contents of the blob
+func (self *Blob) Contents(repo *Repo) ([]byte, error) {
+	output, err := repo.CmdOutput([]string{"cat-file", "blob", self.sha1})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Reading contents of blob %s", self.sha1)
+	}
+	self._setSize(len(output))
+	return output, nil
+}
+
 func (self *Blob) DecompressedSizeBytes(repo *Repo) (int, error) {
+	if size, known := self._cachedSize(); known {
+		return size, nil
+	}
+
+	// Has another Blob object for the same sha1 already looked it up?
+	if obj, has := repo.objectCache.Get(self.sha1); has {
+		if cached, ok := obj.(*Blob); ok && cached != self {
+			if size, known := cached._cachedSize(); known {
+				self._setSize(size)
+				return size, nil
+			}
+		}
+	}
+
 	output, err := repo.CmdOutput([]string{"cat-file", "-s", self.sha1})
 	if err != nil {
 		return 0, errors.Wrapf(err, "Getting decompressed size for blob %s", self.sha1)
@@ -32,5 +62,34 @@ func (self *Blob) DecompressedSizeBytes(repo *Repo) (int, error) {
 	if err != nil {
 		return 0, errors.Wrapf(err, "Converting decompressed size '%s' for blob %s", sizeString, self.sha1)
 	}
+	self._setSize(size)
+
+	obj := repo.objectCache.GetOrSet(self.sha1, self)
+	if obj == self {
+		repo.objectCache.Resize(self.sha1, self._estimatedSizeBytes())
+	} else if cached, ok := obj.(*Blob); ok {
+		cached._setSize(size)
+	}
 	return size, nil
 }
+
+func (self *Blob) _cachedSize() (int, bool) {
+	self.Lock()
+	defer self.Unlock()
+	return self.size, self.sizeKnown
+}
+
+func (self *Blob) _setSize(size int) {
+	self.Lock()
+	defer self.Unlock()
+	self.size = size
+	self.sizeKnown = true
+}
+
+// A rough estimate of how much memory this Blob uses, for the benefit of the
+// object cache's byte budget. Only the sha1 and size are kept, not the
+// contents.
+func (self *Blob) _estimatedSizeBytes() int64 {
+	const blobOverhead = 48
+	return int64(blobOverhead + len(self.sha1))
+}
@@ -0,0 +1,153 @@
+package gitobjects
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// A long-running "git cat-file --batch-check" process, which looks up the
+// type and size of one object after another without starting a new
+// process for each. It is not safe for concurrent use.
+type catFileBatchCheck struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func newCatFileBatchCheck(repo *Repo) (*catFileBatchCheck, error) {
+	cmd := repo.Command([]string{"cat-file", "--batch-check"})
+	cmd.Stdout = nil
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "Getting stdin pipe for git cat-file --batch-check")
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "Getting stdout pipe for git cat-file --batch-check")
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, errors.Wrap(err, "Starting git cat-file --batch-check")
+	}
+	return &catFileBatchCheck{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}, nil
+}
+
+// Return the type and decompressed size of an object. The type
+// is "missing" if the object does not exist.
+func (self *catFileBatchCheck) Check(sha1 string) (string, int64, error) {
+	_, err := io.WriteString(self.stdin, sha1+"\n")
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "Asking git cat-file --batch-check about %s", sha1)
+	}
+	line, err := self.stdout.ReadString('\n')
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "Reading git cat-file --batch-check answer for %s", sha1)
+	}
+	// "<sha1> <type> <size>" or "<sha1> missing"
+	fields := strings.Fields(line)
+	if len(fields) == 2 && fields[1] == "missing" {
+		return "missing", 0, nil
+	}
+	if len(fields) != 3 {
+		return "", 0, errors.Errorf("Got unexpected line from git cat-file --batch-check for %s: %s",
+			sha1, line)
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "Parsing size from git cat-file --batch-check for %s: %s",
+			sha1, line)
+	}
+	return fields[1], size, nil
+}
+
+// Stop the process and wait for it to exit
+func (self *catFileBatchCheck) Close() error {
+	err := self.stdin.Close()
+	waitErr := self.cmd.Wait()
+	if err != nil {
+		return errors.Wrap(err, "Closing stdin of git cat-file --batch-check")
+	}
+	return errors.Wrap(waitErr, "Waiting for git cat-file --batch-check")
+}
+
+// A long-running "git cat-file --batch" process, which reads the contents of
+// one object after another without starting a new process for each. It is
+// not safe for concurrent use.
+type catFileBatch struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func newCatFileBatch(repo *Repo) (*catFileBatch, error) {
+	cmd := repo.Command([]string{"cat-file", "--batch"})
+	cmd.Stdout = nil
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "Getting stdin pipe for git cat-file --batch")
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "Getting stdout pipe for git cat-file --batch")
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, errors.Wrap(err, "Starting git cat-file --batch")
+	}
+	return &catFileBatch{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}, nil
+}
+
+// Return the type and raw contents of an object. The type is
+// "missing" if the object does not exist.
+func (self *catFileBatch) Read(sha1 string) (string, []byte, error) {
+	_, err := io.WriteString(self.stdin, sha1+"\n")
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "Asking git cat-file --batch for %s", sha1)
+	}
+	line, err := self.stdout.ReadString('\n')
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "Reading git cat-file --batch header for %s", sha1)
+	}
+	// "<sha1> <type> <size>\n<contents>\n" or "<sha1> missing\n"
+	fields := strings.Fields(line)
+	if len(fields) == 2 && fields[1] == "missing" {
+		return "missing", nil, nil
+	}
+	if len(fields) != 3 {
+		return "", nil, errors.Errorf("Got unexpected line from git cat-file --batch for %s: %s",
+			sha1, line)
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "Parsing size from git cat-file --batch for %s: %s",
+			sha1, line)
+	}
+	contents := make([]byte, size+1)
+	_, err = io.ReadFull(self.stdout, contents)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "Reading contents of %s from git cat-file --batch", sha1)
+	}
+	return fields[1], contents[:size], nil
+}
+
+// Stop the process and wait for it to exit
+func (self *catFileBatch) Close() error {
+	err := self.stdin.Close()
+	waitErr := self.cmd.Wait()
+	if err != nil {
+		return errors.Wrap(err, "Closing stdin of git cat-file --batch")
+	}
+	return errors.Wrap(waitErr, "Waiting for git cat-file --batch")
+}
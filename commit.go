@@ -81,6 +81,30 @@ func (self *Commit) Message() string {
 	return self.msg
 }
 
+func (self *Commit) Author() (*Signature, error) {
+	signature, err := ParseSignature(self.authorLine)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Parsing author of commit %s", self.sha1)
+	}
+	return signature, nil
+}
+
+func (self *Commit) Committer() (*Signature, error) {
+	signature, err := ParseSignature(self.committerLine)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Parsing committer of commit %s", self.sha1)
+	}
+	return signature, nil
+}
+
+func (self *Commit) ParentSha1s() []string {
+	return self.parentSha1s
+}
+
+func (self *Commit) TreeSha1() string {
+	return self.treeSha1
+}
+
 func (self *Commit) Tree() *Tree {
 	if self.tree != nil {
 		return self.tree
@@ -98,17 +122,38 @@ func (self *Commit) InstantiateTree(repo *Repo) (*Tree, error) {
 		panic(fmt.Sprintf("Commit %s has no tree sha1", self.sha1))
 	}
 
-	tree, has := repo.treeCache.Get(self.treeSha1)
-	if has {
-		self.tree = tree
-		return tree, nil
-	}
-
-	self.tree = repo.treeCache.CreateIfNotPresent(self.treeSha1)
 	//	log.Printf("Commit %s is instantiating root tree", self.sha1)
-	err := self.tree.Instantiate(repo)
+	tree, err := repo.GetTree(self.treeSha1)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Instantiating Commit %s Tree=%s", self.sha1, self.treeSha1)
 	}
+	self.tree = tree
 	return self.tree, nil
 }
+
+// Find the Entry at a slash-separated path in this Commit's tree, instantiating
+// the tree if needed. If nothing exists at the path, the error is a
+// *PathNotFoundError.
+func (self *Commit) FileAt(repo *Repo, path string) (*Entry, error) {
+	tree := self.tree
+	if tree == nil {
+		var err error
+		tree, err = self.InstantiateTree(repo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tree.Lookup(repo, path)
+}
+
+// A rough estimate of how much memory this Commit uses, for the
+// benefit of the object cache's byte budget.
+func (self *Commit) _estimatedSizeBytes() int64 {
+	const commitOverhead = 160
+	size := int64(commitOverhead + len(self.sha1) + len(self.treeSha1) +
+		len(self.authorLine) + len(self.committer) + len(self.committerLine) + len(self.msg))
+	for _, parentSha1 := range self.parentSha1s {
+		size += int64(len(parentSha1) + 16)
+	}
+	return size
+}
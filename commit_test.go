@@ -3,6 +3,7 @@ package gitobjects
 import (
 	"context"
 	. "gopkg.in/check.v1"
+	"strings"
 	"time"
 )
 
@@ -48,3 +49,30 @@ func (s *MySuite) TestCommitInstantiate(c *C) {
 
 	c.Check(commitObj.Message(), Equals, "Add README")
 }
+
+func (s *MySuite) TestCommitFileAt(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+	repo.SetLazyTrees(true)
+
+	output, err := repo.CmdOutput([]string{"rev-parse", "HEAD"})
+	c.Assert(err, IsNil)
+	commit, err := repo.GetCommit(strings.TrimRight(string(output), "\n"))
+	c.Assert(err, IsNil)
+
+	entry, err := commit.FileAt(repo, "docs/api/index.md")
+	c.Assert(err, IsNil)
+	c.Check(entry.Name(), Equals, "index.md")
+	c.Check(entry.Type(), Equals, "blob")
+
+	// Only the trees along the path were read
+	srcEntry, err := commit.FileAt(repo, "src")
+	c.Assert(err, IsNil)
+	c.Check(srcEntry.Type(), Equals, "tree")
+	c.Check(srcEntry.tree.IsInstantiated(), Equals, false)
+
+	for _, path := range []string{"docs/missing", "README/foo", "nothing"} {
+		_, err = commit.FileAt(repo, path)
+		c.Check(IsPathNotFound(err), Equals, true, Commentf("path %s", path))
+	}
+}
@@ -0,0 +1,767 @@
+package gitobjects
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"math"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Which config file an entry came from, from the lowest precedence to the
+// highest
+type ConfigScope int
+
+const (
+	ConfigSystem ConfigScope = iota
+	ConfigGlobal
+	ConfigLocal
+	ConfigWorktree
+)
+
+// "system", "global", "local" or "worktree", as "git config --show-scope"
+// shows it
+func (self ConfigScope) String() string {
+	switch self {
+	case ConfigSystem:
+		return "system"
+	case ConfigGlobal:
+		return "global"
+	case ConfigLocal:
+		return "local"
+	case ConfigWorktree:
+		return "worktree"
+	default:
+		return "unknown"
+	}
+}
+
+// One "name = value" line of a config file
+type ConfigEntry struct {
+	// The section and name are lowercased, but not the subsection, as in
+	// "remote.Origin.url"
+	Key string
+
+	Value string
+
+	// The line had no "=" at all, which means true to GetBool
+	NoValue bool
+
+	// Where the line is. An entry from an included file has the scope of
+	// the file that included it.
+	Scope ConfigScope
+	File  string
+	Line  int
+}
+
+// The entries of one or more config files, in the order git reads them,
+// so that later entries win over earlier ones
+type Config struct {
+	Entries []*ConfigEntry
+}
+
+// git gives up on include loops after this many levels
+const maxConfigIncludeDepth = 10
+
+// Read the system, global, local and worktree config files, as git does,
+// following include.path and includeIf.<condition>.path. The files are read
+// on every call, so that changes made since are seen.
+func (self *Repo) Config() (*Config, error) {
+	reader := &configReader{
+		gitDir: self.gitDir,
+		config: &Config{Entries: make([]*ConfigEntry, 0)},
+	}
+
+	noSystem, err := _parseConfigBool(os.Getenv("GIT_CONFIG_NOSYSTEM"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Parsing GIT_CONFIG_NOSYSTEM")
+	}
+	if !noSystem {
+		systemPath := os.Getenv("GIT_CONFIG_SYSTEM")
+		if systemPath == "" {
+			systemPath = "/etc/gitconfig"
+		}
+		err = reader.ReadFile(systemPath, ConfigSystem, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Both global files are read, the XDG one first
+	globalPaths := []string{_xdgConfigPath("config")}
+	if home := os.Getenv("HOME"); home != "" {
+		globalPaths = append(globalPaths, filepath.Join(home, ".gitconfig"))
+	}
+	if globalPath, ok := os.LookupEnv("GIT_CONFIG_GLOBAL"); ok {
+		globalPaths = []string{globalPath}
+	}
+	for _, globalPath := range globalPaths {
+		if globalPath != "" {
+			err = reader.ReadFile(globalPath, ConfigGlobal, 0)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	commonDir, err := self._commonDir()
+	if err != nil {
+		return nil, err
+	}
+	err = reader.ReadFile(filepath.Join(commonDir, "config"), ConfigLocal, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	worktreeConfig, err := reader.config.GetBool("extensions.worktreeConfig", false)
+	if err != nil {
+		return nil, err
+	}
+	if worktreeConfig {
+		err = reader.ReadFile(filepath.Join(self.gitDir, "config.worktree"), ConfigWorktree, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return reader.config, nil
+}
+
+// The git dir shared by all work trees, which has the objects, refs and
+// config; the same as the git dir, except in a linked work tree
+func (self *Repo) _commonDir() (string, error) {
+	contents, err := _readOptionalFile(filepath.Join(self.gitDir, "commondir"))
+	if err != nil || contents == nil {
+		return self.gitDir, err
+	}
+	commonDir := strings.TrimRight(string(contents), "\r\n")
+	if !filepath.IsAbs(commonDir) {
+		commonDir = filepath.Join(self.gitDir, commonDir)
+	}
+	return commonDir, nil
+}
+
+// Read one config file, and the files it includes. Relative includes are
+// relative to the file; includeIf conditions are never true, as there is
+// no repository. The entries have the local scope.
+func ReadConfigFile(path string) (*Config, error) {
+	reader := &configReader{
+		config: &Config{Entries: make([]*ConfigEntry, 0)},
+	}
+	err := reader.ReadFile(path, ConfigLocal, 0)
+	if err != nil {
+		return nil, err
+	}
+	return reader.config, nil
+}
+
+// Return the last value of a key, and whether it is set at all
+func (self *Config) Get(key string) (string, bool) {
+	entry := self._last(key)
+	if entry == nil {
+		return "", false
+	}
+	return entry.Value, true
+}
+
+// Return every value of a multi-valued key, such as remote.origin.fetch,
+// in order
+func (self *Config) GetAll(key string) []string {
+	key = _canonicalConfigKey(key)
+	values := make([]string, 0)
+	for _, entry := range self.Entries {
+		if entry.Key == key {
+			values = append(values, entry.Value)
+		}
+	}
+	return values
+}
+
+// Return the last value of a key as a boolean, as git does: a key with no
+// value, "true", "yes", "on" or a non-zero number is true; "", "false",
+// "no", "off" or 0 is false. If the key is not set, defaultValue is returned.
+func (self *Config) GetBool(key string, defaultValue bool) (bool, error) {
+	entry := self._last(key)
+	if entry == nil {
+		return defaultValue, nil
+	}
+	if entry.NoValue {
+		return true, nil
+	}
+	value, err := _parseConfigBool(entry.Value)
+	if err != nil {
+		return false, errors.Errorf("Bad boolean config value '%s' for '%s' in %s", entry.Value, key, entry.File)
+	}
+	return value, nil
+}
+
+// Return the last value of a key as an integer, which may have a k, m or g
+// suffix for 1024, 1024^2 or 1024^3. If the key is not set, defaultValue
+// is returned.
+func (self *Config) GetInt(key string, defaultValue int64) (int64, error) {
+	entry := self._last(key)
+	if entry == nil {
+		return defaultValue, nil
+	}
+	value, err := _parseConfigInt(entry.Value)
+	if entry.NoValue || err != nil {
+		return 0, errors.Errorf("Bad numeric config value '%s' for '%s' in %s", entry.Value, key, entry.File)
+	}
+	return value, nil
+}
+
+// Return the last value of a key as a path, with a leading "~/" or
+// "~user/" expanded. If the key is not set, defaultValue is returned.
+func (self *Config) GetPath(key string, defaultValue string) (string, error) {
+	entry := self._last(key)
+	if entry == nil {
+		return defaultValue, nil
+	}
+	if entry.NoValue {
+		return "", errors.Errorf("Missing value for '%s' in %s", key, entry.File)
+	}
+	return _expandConfigPath(entry.Value)
+}
+
+// Return the subsections of a section, such as the names of the remotes
+// for "remote", in the order they first appear
+func (self *Config) Subsections(section string) []string {
+	prefix := strings.ToLower(section) + "."
+	seen := make(map[string]bool)
+	subsections := make([]string, 0)
+	for _, entry := range self.Entries {
+		if !strings.HasPrefix(entry.Key, prefix) {
+			continue
+		}
+		rest := entry.Key[len(prefix):]
+		dot := strings.LastIndexByte(rest, '.')
+		if dot < 0 {
+			continue
+		}
+		if subsection := rest[:dot]; !seen[subsection] {
+			seen[subsection] = true
+			subsections = append(subsections, subsection)
+		}
+	}
+	return subsections
+}
+
+func (self *Config) _last(key string) *ConfigEntry {
+	key = _canonicalConfigKey(key)
+	for i := len(self.Entries) - 1; i >= 0; i-- {
+		if self.Entries[i].Key == key {
+			return self.Entries[i]
+		}
+	}
+	return nil
+}
+
+// Lowercase the section and name of a key, but not the subsection
+func _canonicalConfigKey(key string) string {
+	first := strings.IndexByte(key, '.')
+	last := strings.LastIndexByte(key, '.')
+	if first < 0 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
+}
+
+func _parseConfigBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+	number, err := _parseConfigInt(value)
+	if err != nil {
+		return false, errors.Errorf("'%s' is not a boolean", value)
+	}
+	return number != 0, nil
+}
+
+// Parse a decimal, hex ("0x") or octal ("0") integer, as strtoimax does,
+// with an optional k, m or g suffix
+func _parseConfigInt(value string) (int64, error) {
+	var factor int64 = 1
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			factor = 1 << 10
+		case 'm', 'M':
+			factor = 1 << 20
+		case 'g', 'G':
+			factor = 1 << 30
+		}
+		if factor != 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	lower := strings.ToLower(value)
+	if strings.Contains(value, "_") || strings.HasPrefix(lower, "0b") || strings.HasPrefix(lower, "0o") {
+		return 0, errors.Errorf("'%s' is not a number", value)
+	}
+	number, err := strconv.ParseInt(value, 0, 64)
+	if err != nil {
+		return 0, errors.Errorf("'%s' is not a number", value)
+	}
+	if number > math.MaxInt64/factor || number < math.MinInt64/factor {
+		return 0, errors.Errorf("'%s' is out of range", value)
+	}
+	return number * factor, nil
+}
+
+// Expand a leading "~/" to $HOME, and "~user/" to that user's home
+func _expandConfigPath(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+	name, rest := path[1:], ""
+	if slash := strings.IndexByte(name, '/'); slash >= 0 {
+		name, rest = name[:slash], name[slash:]
+	}
+	var home string
+	if name == "" {
+		home = os.Getenv("HOME")
+		if home == "" {
+			return "", errors.Errorf("Cannot expand '%s' without $HOME", path)
+		}
+	} else {
+		account, err := user.Lookup(name)
+		if err != nil {
+			return "", errors.Wrapf(err, "Expanding '%s'", path)
+		}
+		home = account.HomeDir
+	}
+	return home + rest, nil
+}
+
+// Reads config files into one Config, following includes
+type configReader struct {
+	// For includeIf "gitdir:" and "onbranch:"; "" if there is no repository
+	gitDir string
+
+	config *Config
+}
+
+// Read a config file, if it exists, and append its entries
+func (self *configReader) ReadFile(path string, scope ConfigScope, depth int) error {
+	if depth > maxConfigIncludeDepth {
+		return errors.Errorf("Exceeded maximum include depth (%d) while including %s", maxConfigIncludeDepth, path)
+	}
+	contents, err := _readOptionalFile(path)
+	if err != nil || contents == nil {
+		return err
+	}
+	entries, err := _parseConfig(contents, path)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entry.Scope = scope
+		self.config.Entries = append(self.config.Entries, entry)
+
+		// The included file's entries go where the include is
+		include, err := self._isInclude(entry)
+		if err != nil {
+			return err
+		}
+		if !include {
+			continue
+		}
+		if entry.NoValue {
+			return errors.Errorf("Missing value for '%s' at line %d in %s", entry.Key, entry.Line, path)
+		}
+		includePath, err := _expandConfigPath(entry.Value)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(path), includePath)
+		}
+		err = self.ReadFile(includePath, scope, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Is the entry an include.path, or an includeIf.<condition>.path whose
+// condition is true?
+func (self *configReader) _isInclude(entry *ConfigEntry) (bool, error) {
+	if entry.Key == "include.path" {
+		return true, nil
+	}
+	if !strings.HasPrefix(entry.Key, "includeif.") || !strings.HasSuffix(entry.Key, ".path") {
+		return false, nil
+	}
+	condition := entry.Key[len("includeif.") : len(entry.Key)-len(".path")]
+	if self.gitDir == "" {
+		return false, nil
+	}
+	switch {
+	case strings.HasPrefix(condition, "gitdir:"):
+		return self._includeByGitDir(condition[len("gitdir:"):], entry.File, false)
+	case strings.HasPrefix(condition, "gitdir/i:"):
+		return self._includeByGitDir(condition[len("gitdir/i:"):], entry.File, true)
+	case strings.HasPrefix(condition, "onbranch:"):
+		return self._includeByBranch(condition[len("onbranch:"):])
+	}
+	// Conditions this version of git does not know are false
+	return false, nil
+}
+
+// Does the git dir match the pattern of an includeIf "gitdir:" condition?
+func (self *configReader) _includeByGitDir(pattern string, configFile string, caseFold bool) (bool, error) {
+	pattern, err := _expandConfigPath(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	// "./" is the directory of the config file, which is matched
+	// literally; other relative patterns can match anywhere
+	prefix := 0
+	if strings.HasPrefix(pattern, "./") {
+		realConfigFile, err := filepath.Abs(configFile)
+		if err == nil {
+			realConfigFile, err = filepath.EvalSymlinks(realConfigFile)
+		}
+		if err != nil {
+			return false, errors.Wrapf(err, "Resolving %s", configFile)
+		}
+		configDir := filepath.ToSlash(filepath.Dir(realConfigFile))
+		pattern = configDir + pattern[1:]
+		prefix = len(configDir) + 1
+	} else if !strings.HasPrefix(pattern, "/") && !filepath.IsAbs(pattern) {
+		pattern = "**/" + pattern
+	}
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	flags := wildmatchPathname
+	if caseFold {
+		flags |= wildmatchCaseFold
+	}
+
+	// The real path is tried first, then the path as given
+	gitDirs := []string{}
+	if realGitDir, err := filepath.EvalSymlinks(self.gitDir); err == nil {
+		gitDirs = append(gitDirs, realGitDir)
+	}
+	gitDirs = append(gitDirs, self.gitDir)
+	for _, gitDir := range gitDirs {
+		text := filepath.ToSlash(gitDir)
+		if len(text) < prefix {
+			continue
+		}
+		if prefix > 0 {
+			if caseFold && !strings.EqualFold(text[:prefix], pattern[:prefix]) {
+				continue
+			} else if !caseFold && text[:prefix] != pattern[:prefix] {
+				continue
+			}
+		}
+		if wildmatch(pattern[prefix:], text[prefix:], flags) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Is HEAD on a branch which matches the pattern of an includeIf
+// "onbranch:" condition?
+func (self *configReader) _includeByBranch(pattern string) (bool, error) {
+	contents, err := _readOptionalFile(filepath.Join(self.gitDir, "HEAD"))
+	if err != nil {
+		return false, err
+	}
+	head := strings.TrimRight(string(contents), "\r\n")
+	if !strings.HasPrefix(head, "ref: refs/heads/") {
+		return false, nil
+	}
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	return wildmatch(pattern, head[len("ref: refs/heads/"):], wildmatchPathname), nil
+}
+
+// Parse the entries of a config file, as git does
+func _parseConfig(contents []byte, file string) ([]*ConfigEntry, error) {
+	return _newConfigParser(contents, file).Parse()
+}
+
+func _newConfigParser(contents []byte, file string) *configParser {
+	parser := &configParser{
+		data: contents,
+		file: file,
+		line: 1,
+	}
+	// A byte order mark is skipped, but kept in the offsets of the spans
+	if bytes.HasPrefix(contents, []byte("\xef\xbb\xbf")) {
+		parser.pos = 3
+	}
+	return parser
+}
+
+// A port of git's config file parser
+type configParser struct {
+	data []byte
+	pos  int
+	file string
+	line int
+
+	// "section" or "section.subsection" for the entries that follow
+	section string
+
+	// Where each section header and entry is, for editing the file
+	spans []configSpan
+}
+
+// Where a section header or an entry is in a config file
+type configSpan struct {
+	// "section" or "section.subsection", lowercased as in keys
+	section string
+
+	// nil for a section header
+	entry *ConfigEntry
+
+	// From the '[' to just after the ']', or from the first letter of
+	// the name to the end of the entry's last line, including the newline
+	start int
+	end   int
+
+	// Just after the entry's name
+	nameEnd int
+}
+
+const configEOF = -1
+
+// Return the next character, with "\r\n" as '\n'
+func (self *configParser) _next() int {
+	if self.pos >= len(self.data) {
+		return configEOF
+	}
+	c := self.data[self.pos]
+	self.pos++
+	if c == '\r' && self.pos < len(self.data) && self.data[self.pos] == '\n' {
+		c = '\n'
+		self.pos++
+	}
+	if c == '\n' {
+		self.line++
+	}
+	return int(c)
+}
+
+func (self *configParser) _peek() int {
+	if self.pos >= len(self.data) {
+		return configEOF
+	}
+	return int(self.data[self.pos])
+}
+
+func (self *configParser) _error() error {
+	return errors.Errorf("Bad config line %d in %s", self.line, self.file)
+}
+
+func (self *configParser) Parse() ([]*ConfigEntry, error) {
+	entries := make([]*ConfigEntry, 0)
+	comment := false
+	for {
+		c := self._next()
+		if c == configEOF {
+			return entries, nil
+		}
+		if c == '\n' {
+			comment = false
+			continue
+		}
+		if comment || _isConfigSpace(c) {
+			continue
+		}
+		if c == '#' || c == ';' {
+			comment = true
+			continue
+		}
+		if c == '[' {
+			start := self.pos - 1
+			err := self._parseSectionHeader()
+			if err != nil {
+				return nil, err
+			}
+			self.spans = append(self.spans, configSpan{section: self.section, start: start, end: self.pos})
+			continue
+		}
+		if !_isAlpha(byte(c)) || self.section == "" {
+			return nil, self._error()
+		}
+		entry, err := self._parseEntry(byte(c))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// Parse "[section]", "[section "subsection"]" or the deprecated
+// "[section.subsection]", after the '['
+func (self *configParser) _parseSectionHeader() error {
+	var name strings.Builder
+	for {
+		c := self._next()
+		if c == configEOF || c == '\n' {
+			return self._error()
+		}
+		if c == ']' {
+			self.section = strings.ToLower(name.String())
+			if self.section == "" {
+				return self._error()
+			}
+			return nil
+		}
+		if _isConfigSpace(c) {
+			return self._parseExtendedSubsection(strings.ToLower(name.String()))
+		}
+		if !_isAlpha(byte(c)) && !_isDigit(byte(c)) && c != '-' && c != '.' {
+			return self._error()
+		}
+		name.WriteByte(byte(c))
+	}
+}
+
+// Parse ` "subsection"]`, where the subsection can have '\' escapes
+func (self *configParser) _parseExtendedSubsection(section string) error {
+	c := self._next()
+	for _isConfigSpace(c) {
+		c = self._next()
+	}
+	if c != '"' || section == "" {
+		return self._error()
+	}
+	var subsection strings.Builder
+	for {
+		c = self._next()
+		if c == configEOF || c == '\n' {
+			return self._error()
+		}
+		if c == '"' {
+			break
+		}
+		if c == '\\' {
+			c = self._next()
+			if c == configEOF || c == '\n' {
+				return self._error()
+			}
+		}
+		subsection.WriteByte(byte(c))
+	}
+	if self._next() != ']' {
+		return self._error()
+	}
+	self.section = section + "." + subsection.String()
+	return nil
+}
+
+// Parse "name = value", "name =" or "name", whose first letter is c
+func (self *configParser) _parseEntry(c byte) (*ConfigEntry, error) {
+	entry := &ConfigEntry{File: self.file, Line: self.line}
+	span := configSpan{section: self.section, entry: entry, start: self.pos - 1}
+	name := []byte{_toLower(c)}
+	for {
+		next := self._peek()
+		if next == configEOF || !(_isAlpha(byte(next)) || _isDigit(byte(next)) || next == '-') {
+			break
+		}
+		name = append(name, _toLower(byte(self._next())))
+	}
+	entry.Key = self.section + "." + string(name)
+	span.nameEnd = self.pos
+
+	next := self._next()
+	for next == ' ' || next == '\t' {
+		next = self._next()
+	}
+	switch next {
+	case '\n', configEOF:
+		entry.NoValue = true
+	case '=':
+		value, err := self._parseValue()
+		if err != nil {
+			return nil, err
+		}
+		entry.Value = value
+	default:
+		return nil, self._error()
+	}
+	span.end = self.pos
+	self.spans = append(self.spans, span)
+	return entry, nil
+}
+
+// Parse the value after the '=', up to the end of the line. Whitespace is
+// kept between words, but not around them, unless it is quoted.
+func (self *configParser) _parseValue() (string, error) {
+	var value []byte
+	quoted := false
+	comment := false
+	spaces := 0
+	for {
+		c := self._next()
+		if c == '\n' || c == configEOF {
+			if quoted {
+				return "", self._error()
+			}
+			return string(value), nil
+		}
+		if comment {
+			continue
+		}
+		if _isConfigSpace(c) && !quoted {
+			if len(value) > 0 {
+				spaces++
+			}
+			continue
+		}
+		if !quoted && (c == ';' || c == '#') {
+			comment = true
+			continue
+		}
+		for ; spaces > 0; spaces-- {
+			value = append(value, ' ')
+		}
+		if c == '\\' {
+			c = self._next()
+			switch c {
+			case '\n', configEOF:
+				// A line continuation
+				continue
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'n':
+				c = '\n'
+			case '\\', '"':
+			default:
+				return "", self._error()
+			}
+			value = append(value, byte(c))
+			continue
+		}
+		if c == '"' {
+			quoted = !quoted
+			continue
+		}
+		value = append(value, byte(c))
+	}
+}
+
+// Whitespace other than a newline, as isspace() sees it
+func _isConfigSpace(c int) bool {
+	return c == ' ' || c == '\t' || c == '\v' || c == '\f' || c == '\r'
+}
@@ -0,0 +1,216 @@
+package gitobjects
+
+import (
+	. "gopkg.in/check.v1"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Set an environment variable until the returned function is called
+func setEnv(c *C, key string, value string) func() {
+	oldValue, had := os.LookupEnv(key)
+	c.Assert(os.Setenv(key, value), IsNil)
+	return func() {
+		if had {
+			os.Setenv(key, oldValue)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// Unset an environment variable, returning a function that restores it
+func unsetEnv(c *C, key string) func() {
+	oldValue, had := os.LookupEnv(key)
+	c.Assert(os.Unsetenv(key), IsNil)
+	return func() {
+		if had {
+			os.Setenv(key, oldValue)
+		}
+	}
+}
+
+// Format entries as "git config --list -z --show-scope" does, with keys
+// that have no value on their own
+func formatConfigEntries(entries []*ConfigEntry, showScope bool) string {
+	var output strings.Builder
+	for _, entry := range entries {
+		if showScope {
+			output.WriteString(entry.Scope.String() + "\x00")
+		}
+		output.WriteString(entry.Key)
+		if !entry.NoValue {
+			output.WriteString("\n" + entry.Value)
+		}
+		output.WriteString("\x00")
+	}
+	return output.String()
+}
+
+var configTestFile = strings.Join([]string{
+	"# A comment",
+	"; Another comment",
+	"[core]",
+	"\tbare = false",
+	"\tIgnoreCase = yes ; trailing comment",
+	"\tautocrlf",
+	"\tempty =",
+	"[Remote \"Origin\"]",
+	"\turl = https://example.com/repo.git",
+	"\tfetch = +refs/heads/*:refs/remotes/origin/*",
+	"\tfetch = +refs/tags/*:refs/tags/*",
+	"[remote \"with \\\"quotes\\\" and \\\\\"]",
+	"\turl = x",
+	"[Old.Style]",
+	"\tkey = value",
+	"[values] plain = spaces   inside   words   ",
+	"\tquoted = \"  kept  \"  and \" # not a comment\"",
+	"\tescapes = tab\\there\\nnewline \\\"quote\\\" back\\\\slash\\bb",
+	"\tcontinued = one \\",
+	"two",
+	"\tcrlf = value\r",
+	"\tsize = 10k",
+	"\thex = 0x10",
+	"\tbig = 2g",
+	"\tnegative = -1m",
+	"\tbad-number = 10x",
+	"\tbool-number = 2",
+	"\tbool-off = Off",
+	"\thome = ~/file",
+	"",
+}, "\n")
+
+func (s *MySuite) TestConfigFile(c *C) {
+	dir := c.MkDir()
+	configPath := filepath.Join(dir, "config")
+	writeFiles(c, dir, map[string]string{"config": configTestFile})
+
+	config, err := ReadConfigFile(configPath)
+	c.Assert(err, IsNil)
+	expected, err := exec.Command("git", "config", "--file", configPath, "--list", "-z").Output()
+	c.Assert(err, IsNil)
+	c.Check(formatConfigEntries(config.Entries, false), Equals, string(expected))
+
+	value, ok := config.Get("CORE.ignorecase")
+	c.Check(ok, Equals, true)
+	c.Check(value, Equals, "yes")
+	_, ok = config.Get("remote.origin.url")
+	c.Check(ok, Equals, false)
+	c.Check(config.GetAll("remote.Origin.fetch"), DeepEquals,
+		[]string{"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"})
+	c.Check(config.Subsections("remote"), DeepEquals, []string{"Origin", "with \"quotes\" and \\"})
+
+	for key, expected := range map[string]bool{
+		"core.bare":             false,
+		"core.ignoreCase":       true,
+		"core.autocrlf":         true,
+		"core.empty":            false,
+		"values.bool-number":    true,
+		"values.bool-off":       false,
+		"values.not-there":      true,
+		"remote.Origin.nothing": true,
+	} {
+		value, err := config.GetBool(key, true)
+		c.Assert(err, IsNil)
+		c.Check(value, Equals, expected, Commentf(key))
+	}
+	_, err = config.GetBool("values.plain", false)
+	c.Check(err, ErrorMatches, "Bad boolean config value 'spaces   inside   words' for 'values.plain' in .*")
+
+	for key, expected := range map[string]int64{
+		"values.size":     10 * 1024,
+		"values.hex":      16,
+		"values.big":      2 * 1024 * 1024 * 1024,
+		"values.negative": -1024 * 1024,
+		"values.missing":  42,
+	} {
+		value, err := config.GetInt(key, 42)
+		c.Assert(err, IsNil)
+		c.Check(value, Equals, expected, Commentf(key))
+	}
+	for _, key := range []string{"values.bad-number", "core.autocrlf", "core.empty"} {
+		_, err = config.GetInt(key, 0)
+		c.Check(err, ErrorMatches, "Bad numeric config value .*", Commentf(key))
+	}
+
+	home := os.Getenv("HOME")
+	path, err := config.GetPath("values.home", "")
+	c.Assert(err, IsNil)
+	c.Check(path, Equals, home+"/file")
+	path, err = config.GetPath("values.no-path", "default")
+	c.Assert(err, IsNil)
+	c.Check(path, Equals, "default")
+
+	for _, contents := range []string{
+		"key = outside a section\n",
+		"[section\nkey = value\n",
+		"[section \"unterminated]\n",
+		"[section]\nkey = \"unterminated\n",
+		"[section]\nkey = bad \\escape\n",
+		"[section]\nkey # no equals\n",
+		"[section]\n1key = value\n",
+	} {
+		writeFiles(c, dir, map[string]string{"bad": contents})
+		_, err = ReadConfigFile(filepath.Join(dir, "bad"))
+		c.Check(err, ErrorMatches, "Bad config line [0-9]+ in .*bad", Commentf("%q", contents))
+	}
+}
+
+func (s *MySuite) TestRepoConfig(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	dir := c.MkDir()
+	defer setEnv(c, "GIT_CONFIG_NOSYSTEM", "1")()
+	defer setEnv(c, "GIT_CONFIG_GLOBAL", filepath.Join(dir, "global"))()
+
+	writeFiles(c, dir, map[string]string{
+		"global": strings.Join([]string{
+			"[user]",
+			"\tname = Global",
+			"[include]",
+			"\tpath = included",
+			"[includeIf \"gitdir:" + filepath.ToSlash(repoDir) + "/\"]",
+			"\tpath = by-gitdir",
+			"[includeIf \"gitdir/i:" + strings.ToUpper(filepath.ToSlash(repoDir)) + "/.git\"]",
+			"\tpath = by-gitdir-i",
+			"[includeIf \"gitdir:/elsewhere/\"]",
+			"\tpath = not-included",
+			"[includeIf \"onbranch:*\"]",
+			"\tpath = by-branch",
+			"[includeIf \"onbranch:no-such-branch\"]",
+			"\tpath = not-included",
+			"[include]",
+			"\tpath = missing-file",
+			"",
+		}, "\n"),
+		"included":     "[user]\n\temail = included@example.com\n[include]\n\tpath = nested/file\n",
+		"nested/file":  "[nested]\n\tvalue = 1\n",
+		"by-gitdir":    "[by]\n\tgitdir = yes\n",
+		"by-gitdir-i":  "[by]\n\tgitdirI = yes\n",
+		"by-branch":    "[by]\n\tbranch = yes\n",
+		"not-included": "[not]\n\tincluded = yes\n",
+	})
+	runGitIn(c, repo, repoDir, "config", "user.name", "Local")
+	runGitIn(c, repo, repoDir, "config", "extensions.worktreeConfig", "true")
+	runGitIn(c, repo, repoDir, "config", "--worktree", "user.name", "Worktree")
+
+	config, err := repo.Config()
+	c.Assert(err, IsNil)
+	expected := runGitIn(c, repo, repoDir, "config", "--list", "-z", "--show-scope")
+	c.Check(strings.TrimRight(formatConfigEntries(config.Entries, true), "\n"), Equals, expected)
+
+	name, _ := config.Get("user.name")
+	c.Check(name, Equals, "Worktree")
+	for _, key := range []string{"by.gitdir", "by.gitdirI", "by.branch", "nested.value"} {
+		_, ok := config.Get(key)
+		c.Check(ok, Equals, true, Commentf(key))
+	}
+	_, ok := config.Get("not.included")
+	c.Check(ok, Equals, false)
+
+	// An include loop
+	writeFiles(c, dir, map[string]string{"included": "[include]\n\tpath = included\n"})
+	_, err = repo.Config()
+	c.Check(err, ErrorMatches, "Exceeded maximum include depth .*")
+}
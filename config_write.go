@@ -0,0 +1,449 @@
+package gitobjects
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Edits one config file the way "git config" does, rewriting only the lines
+// of the keys and section headers it changes, so that comments, ordering
+// and whitespace everywhere else survive. The file is locked from when it
+// is opened until Commit or Rollback, so no other git process can change
+// it in between. It is not safe for concurrent use.
+type ConfigEditor struct {
+	path string
+	lock *lockFile
+
+	// The edited contents, and where their sections and entries are
+	contents []byte
+	spans    []configSpan
+}
+
+// A key split into its parts, with their case as given
+type configKey struct {
+	section       string
+	subsection    string
+	hasSubsection bool
+
+	// "" for a section name
+	name string
+}
+
+// One change to the contents: replace [start, end) with text
+type configEdit struct {
+	start int
+	end   int
+	text  string
+}
+
+// Lock the repository's local config file for editing
+func (self *Repo) EditConfig() (*ConfigEditor, error) {
+	commonDir, err := self._commonDir()
+	if err != nil {
+		return nil, err
+	}
+	return EditConfigFile(filepath.Join(commonDir, "config"))
+}
+
+// Lock a config file for editing. If it does not exist, Commit creates it.
+func EditConfigFile(path string) (*ConfigEditor, error) {
+	perm := os.FileMode(0666)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	lock, err := newLockFile(path, perm)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := _readOptionalFile(path)
+	if err == nil {
+		editor := &ConfigEditor{path: path, lock: lock}
+		err = editor._setContents(contents)
+		if err == nil {
+			return editor, nil
+		}
+	}
+	lock.Rollback()
+	return nil, err
+}
+
+// Return the entries of the file as edited so far
+func (self *ConfigEditor) Config() *Config {
+	config := &Config{Entries: make([]*ConfigEntry, 0)}
+	for _, span := range self.spans {
+		if span.entry != nil {
+			config.Entries = append(config.Entries, span.entry)
+		}
+	}
+	return config
+}
+
+// Set a key to one value. An existing value is replaced where it is;
+// otherwise the key is added at the end of its section, which is created at
+// the end of the file if needed. It is an error if the key has several
+// values; use ReplaceAll for those.
+func (self *ConfigEditor) Set(key string, value string) error {
+	parsedKey, err := _parseConfigKey(key)
+	if err != nil {
+		return err
+	}
+	matches := self._entrySpans(parsedKey)
+	switch len(matches) {
+	case 0:
+		return self._add(parsedKey, value)
+	case 1:
+		return self._apply([]configEdit{self._replaceEntry(matches[0], _formatConfigEntry(parsedKey.name, value))})
+	default:
+		return errors.Errorf("Cannot overwrite multiple values of '%s' with a single value", key)
+	}
+}
+
+// Add another value to a key, such as a fetch refspec to a remote, at the
+// end of its section
+func (self *ConfigEditor) Add(key string, value string) error {
+	parsedKey, err := _parseConfigKey(key)
+	if err != nil {
+		return err
+	}
+	return self._add(parsedKey, value)
+}
+
+// Replace every value of a key with a list of values, which go where the
+// first of the old values was
+func (self *ConfigEditor) ReplaceAll(key string, values []string) error {
+	parsedKey, err := _parseConfigKey(key)
+	if err != nil {
+		return err
+	}
+	matches := self._entrySpans(parsedKey)
+	if len(matches) == 0 {
+		for _, value := range values {
+			err = self._add(parsedKey, value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	lines := make([]string, len(values))
+	for i, value := range values {
+		lines[i] = _formatConfigEntry(parsedKey.name, value)
+	}
+	edits := []configEdit{self._replaceEntry(matches[0], strings.Join(lines, ""))}
+	for _, match := range matches[1:] {
+		edits = append(edits, self._replaceEntry(match, ""))
+	}
+	return self._apply(edits)
+}
+
+// Remove a key, returning false if it is not set. It is an error if the
+// key has several values; use UnsetAll for those.
+func (self *ConfigEditor) Unset(key string) (bool, error) {
+	parsedKey, err := _parseConfigKey(key)
+	if err != nil {
+		return false, err
+	}
+	matches := self._entrySpans(parsedKey)
+	if len(matches) > 1 {
+		return false, errors.Errorf("Cannot unset multiple values of '%s'", key)
+	}
+	if len(matches) == 0 {
+		return false, nil
+	}
+	return true, self._apply([]configEdit{self._replaceEntry(matches[0], "")})
+}
+
+// Remove every value of a key, returning how many there were
+func (self *ConfigEditor) UnsetAll(key string) (int, error) {
+	parsedKey, err := _parseConfigKey(key)
+	if err != nil {
+		return 0, err
+	}
+	matches := self._entrySpans(parsedKey)
+	edits := make([]configEdit, len(matches))
+	for i, match := range matches {
+		edits[i] = self._replaceEntry(match, "")
+	}
+	return len(matches), self._apply(edits)
+}
+
+// Rename a key, keeping its values. Within a section, the names are
+// changed in place; otherwise the values move to the end of the new
+// section. Returns false if the key is not set.
+func (self *ConfigEditor) RenameKey(oldKey string, newKey string) (bool, error) {
+	parsedOld, err := _parseConfigKey(oldKey)
+	if err != nil {
+		return false, err
+	}
+	parsedNew, err := _parseConfigKey(newKey)
+	if err != nil {
+		return false, err
+	}
+	matches := self._entrySpans(parsedOld)
+	if len(matches) == 0 {
+		return false, nil
+	}
+
+	edits := make([]configEdit, len(matches))
+	if parsedOld.Section() == parsedNew.Section() {
+		for i, match := range matches {
+			edits[i] = configEdit{start: match.start, end: match.nameEnd, text: parsedNew.name}
+		}
+		return true, self._apply(edits)
+	}
+
+	for i, match := range matches {
+		edits[i] = self._replaceEntry(match, "")
+	}
+	err = self._apply(edits)
+	if err != nil {
+		return false, err
+	}
+	for _, match := range matches {
+		if match.entry.NoValue {
+			err = self._insert(parsedNew, "\t"+parsedNew.name+"\n")
+		} else {
+			err = self._add(parsedNew, match.entry.Value)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Rename a section, such as "remote.origin" to "remote.upstream", by
+// rewriting its headers. Returns false if there is no such section.
+func (self *ConfigEditor) RenameSection(oldName string, newName string) (bool, error) {
+	parsedOld, err := _parseConfigSectionName(oldName)
+	if err != nil {
+		return false, err
+	}
+	parsedNew, err := _parseConfigSectionName(newName)
+	if err != nil {
+		return false, err
+	}
+	edits := make([]configEdit, 0)
+	for _, span := range self.spans {
+		if span.entry == nil && span.section == parsedOld.Section() {
+			edits = append(edits, configEdit{start: span.start, end: span.end, text: parsedNew.Header()})
+		}
+	}
+	return len(edits) > 0, self._apply(edits)
+}
+
+// Remove a section, with all its entries and comments, wherever it
+// appears. Returns false if there is no such section.
+func (self *ConfigEditor) RemoveSection(name string) (bool, error) {
+	parsedName, err := _parseConfigSectionName(name)
+	if err != nil {
+		return false, err
+	}
+	edits := make([]configEdit, 0)
+	for i, span := range self.spans {
+		if span.entry != nil || span.section != parsedName.Section() {
+			continue
+		}
+		start, _ := self._lineStart(span.start)
+		end := len(self.contents)
+		for _, next := range self.spans[i+1:] {
+			if next.entry == nil {
+				end, _ = self._lineStart(next.start)
+				break
+			}
+		}
+		edits = append(edits, configEdit{start: start, end: end})
+	}
+	return len(edits) > 0, self._apply(edits)
+}
+
+// Write the edited file and release the lock
+func (self *ConfigEditor) Commit() error {
+	_, err := self.lock.Write(self.contents)
+	if err != nil {
+		self.lock.Rollback()
+		return err
+	}
+	return self.lock.Commit()
+}
+
+// Release the lock without changing the file
+func (self *ConfigEditor) Rollback() error {
+	return self.lock.Rollback()
+}
+
+func (self *ConfigEditor) _setContents(contents []byte) error {
+	parser := _newConfigParser(contents, self.path)
+	_, err := parser.Parse()
+	if err != nil {
+		return err
+	}
+	self.contents = contents
+	self.spans = parser.spans
+	return nil
+}
+
+func (self *ConfigEditor) _entrySpans(key *configKey) []configSpan {
+	canonicalKey := key.Section() + "." + strings.ToLower(key.name)
+	matches := make([]configSpan, 0)
+	for _, span := range self.spans {
+		if span.entry != nil && span.entry.Key == canonicalKey {
+			matches = append(matches, span)
+		}
+	}
+	return matches
+}
+
+// Make the edits, which were found in the current contents and do not
+// overlap, and parse the result
+func (self *ConfigEditor) _apply(edits []configEdit) error {
+	if len(edits) == 0 {
+		return nil
+	}
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start > edits[j].start
+	})
+	contents := self.contents
+	for _, edit := range edits {
+		edited := make([]byte, 0, len(contents)-(edit.end-edit.start)+len(edit.text))
+		edited = append(edited, contents[:edit.start]...)
+		edited = append(edited, edit.text...)
+		contents = append(edited, contents[edit.end:]...)
+	}
+	return self._setContents(contents)
+}
+
+// The edit that replaces an entry's line with text, which is "" to remove
+// it. An entry on the same line as its section header keeps the header
+// and its newline.
+func (self *ConfigEditor) _replaceEntry(span configSpan, text string) configEdit {
+	start, atLineStart := self._lineStart(span.start)
+	if !atLineStart && text == "" && self.contents[span.end-1] == '\n' {
+		text = "\n"
+	}
+	return configEdit{start: start, end: span.end, text: text}
+}
+
+// Return the start of the line that pos is on, if there is only
+// whitespace before pos on it, or else pos and false
+func (self *ConfigEditor) _lineStart(pos int) (int, bool) {
+	start := pos
+	for start > 0 && self.contents[start-1] != '\n' {
+		if !_isConfigSpace(int(self.contents[start-1])) {
+			return pos, false
+		}
+		start--
+	}
+	return start, true
+}
+
+func (self *ConfigEditor) _add(key *configKey, value string) error {
+	return self._insert(key, _formatConfigEntry(key.name, value))
+}
+
+// Insert lines after the last entry of the last section that the key is
+// in, or after its header if it has no entries. If there is no such
+// section, one is added to the end of the file.
+func (self *ConfigEditor) _insert(key *configKey, lines string) error {
+	pos := -1
+	for i, span := range self.spans {
+		if span.entry != nil || span.section != key.Section() {
+			continue
+		}
+		// The end of the header's line
+		pos = len(self.contents)
+		if newline := strings.IndexByte(string(self.contents[span.end:]), '\n'); newline >= 0 {
+			pos = span.end + newline + 1
+		}
+		for _, next := range self.spans[i+1:] {
+			if next.entry == nil {
+				break
+			}
+			pos = next.end
+		}
+	}
+
+	text := lines
+	if pos < 0 {
+		pos = len(self.contents)
+		text = key.Header() + "\n" + lines
+	}
+	if pos > 0 && self.contents[pos-1] != '\n' {
+		text = "\n" + text
+	}
+	return self._apply([]configEdit{{start: pos, end: pos, text: text}})
+}
+
+// "section" or "section.subsection", lowercased as in the spans
+func (self *configKey) Section() string {
+	if self.hasSubsection {
+		return strings.ToLower(self.section) + "." + self.subsection
+	}
+	return strings.ToLower(self.section)
+}
+
+// The header that git writes for the section
+func (self *configKey) Header() string {
+	if !self.hasSubsection {
+		return "[" + self.section + "]"
+	}
+	subsection := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(self.subsection)
+	return "[" + self.section + " \"" + subsection + "\"]"
+}
+
+// Split "section.name" or "section.subsection.name", checking that each
+// part is something git can write
+func _parseConfigKey(key string) (*configKey, error) {
+	last := strings.LastIndexByte(key, '.')
+	if last < 0 {
+		return nil, errors.Errorf("Key '%s' does not contain a section", key)
+	}
+	parsedKey, err := _parseConfigSectionName(key[:last])
+	if err != nil {
+		return nil, errors.Errorf("Invalid key '%s'", key)
+	}
+	parsedKey.name = key[last+1:]
+	valid := parsedKey.name != "" && _isAlpha(parsedKey.name[0])
+	for i := 0; i < len(parsedKey.name); i++ {
+		c := parsedKey.name[i]
+		valid = valid && (_isAlpha(c) || _isDigit(c) || c == '-')
+	}
+	if !valid {
+		return nil, errors.Errorf("Invalid key '%s'", key)
+	}
+	return parsedKey, nil
+}
+
+// Split "section" or "section.subsection"
+func _parseConfigSectionName(name string) (*configKey, error) {
+	parsedKey := &configKey{section: name}
+	if dot := strings.IndexByte(name, '.'); dot >= 0 {
+		parsedKey.section = name[:dot]
+		parsedKey.subsection = name[dot+1:]
+		parsedKey.hasSubsection = true
+	}
+	valid := parsedKey.section != "" && !strings.ContainsRune(parsedKey.subsection, '\n')
+	for i := 0; i < len(parsedKey.section); i++ {
+		c := parsedKey.section[i]
+		valid = valid && (_isAlpha(c) || _isDigit(c) || c == '-')
+	}
+	if !valid {
+		return nil, errors.Errorf("Invalid section name '%s'", name)
+	}
+	return parsedKey, nil
+}
+
+// Format "\tname = value\n" as git does, quoting a value with leading or
+// trailing spaces or comment characters, and escaping what needs it
+func _formatConfigEntry(name string, value string) string {
+	quote := ""
+	if strings.HasPrefix(value, " ") || strings.HasSuffix(value, " ") || strings.ContainsAny(value, ";#") {
+		quote = "\""
+	}
+	escaped := strings.NewReplacer("\n", `\n`, "\t", `\t`, `"`, `\"`, `\`, `\\`).Replace(value)
+	return "\t" + name + " = " + quote + escaped + quote + "\n"
+}
@@ -0,0 +1,184 @@
+package gitobjects
+
+import (
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var configWriteTestFile = strings.Join([]string{
+	"# Kept as it is",
+	"[core]",
+	"    bare = false   ; indented with spaces",
+	"",
+	"; Comment between sections",
+	"[remote \"origin\"]",
+	"\turl = https://example.com/old.git",
+	"\tfetch = +refs/heads/*:refs/remotes/origin/*",
+	"\t# Comment inside a section",
+	"\tfetch = +refs/tags/*:refs/tags/*",
+	"[Branch \"main\"] remote = origin",
+	"\tmerge = refs/heads/main",
+	"[gone]",
+	"\tkey = value",
+	"# Comment after the gone section",
+	"[last]",
+	"\tnoNewline = true",
+}, "\n")
+
+// Edit a copy of configWriteTestFile and return what was committed
+func editConfigTestFile(c *C, edit func(editor *ConfigEditor)) string {
+	dir := c.MkDir()
+	configPath := filepath.Join(dir, "config")
+	writeFiles(c, dir, map[string]string{"config": configWriteTestFile})
+
+	editor, err := EditConfigFile(configPath)
+	c.Assert(err, IsNil)
+	edit(editor)
+	c.Assert(editor.Commit(), IsNil)
+
+	// git reads back what Config says
+	expected, err := exec.Command("git", "config", "--file", configPath, "--list", "-z").Output()
+	c.Assert(err, IsNil)
+	c.Check(formatConfigEntries(editor.Config().Entries, false), Equals, string(expected))
+
+	contents, err := ioutil.ReadFile(configPath)
+	c.Assert(err, IsNil)
+	return string(contents)
+}
+
+func (s *MySuite) TestConfigEditorSet(c *C) {
+	contents := editConfigTestFile(c, func(editor *ConfigEditor) {
+		c.Assert(editor.Set("remote.origin.URL", "https://example.com/new.git"), IsNil)
+		c.Assert(editor.Set("core.bare", "true"), IsNil)
+		c.Assert(editor.Set("core.editor", " vim ; # \"quoted\"\\"), IsNil)
+		c.Assert(editor.Set("last.added", "line\nbreak\ttab"), IsNil)
+		c.Assert(editor.Set("branch.main.remote", "upstream"), IsNil)
+		c.Assert(editor.Set("New.Section.Name", "value"), IsNil)
+		// Subsections are case sensitive
+		c.Assert(editor.Set("new.section.name", "replaced"), IsNil)
+		c.Assert(editor.Add("new.section.name", "added"), IsNil)
+
+		err := editor.Set("remote.origin.fetch", "x")
+		c.Check(err, ErrorMatches, "Cannot overwrite multiple values of 'remote.origin.fetch' with a single value")
+		for _, key := range []string{"nosection", "core.1name", "core.na_me", "bad_section.name", ".name", "core."} {
+			c.Check(editor.Set(key, "x"), ErrorMatches, ".*'"+strings.Replace(key, ".", "\\.", -1)+"'.*", Commentf(key))
+		}
+	})
+	c.Check(contents, Equals, strings.Join([]string{
+		"# Kept as it is",
+		"[core]",
+		"\tbare = true",
+		"\teditor = \" vim ; # \\\"quoted\\\"\\\\\"",
+		"",
+		"; Comment between sections",
+		"[remote \"origin\"]",
+		"\tURL = https://example.com/new.git",
+		"\tfetch = +refs/heads/*:refs/remotes/origin/*",
+		"\t# Comment inside a section",
+		"\tfetch = +refs/tags/*:refs/tags/*",
+		"[Branch \"main\"] \tremote = upstream",
+		"\tmerge = refs/heads/main",
+		"[gone]",
+		"\tkey = value",
+		"# Comment after the gone section",
+		"[last]",
+		"\tnoNewline = true",
+		"\tadded = line\\nbreak\\ttab",
+		"[New \"Section\"]",
+		"\tName = value",
+		"[new \"section\"]",
+		"\tname = replaced",
+		"\tname = added",
+		"",
+	}, "\n"))
+}
+
+func (s *MySuite) TestConfigEditorUnsetAndRename(c *C) {
+	contents := editConfigTestFile(c, func(editor *ConfigEditor) {
+		_, err := editor.Unset("remote.origin.fetch")
+		c.Check(err, ErrorMatches, "Cannot unset multiple values of 'remote.origin.fetch'")
+		c.Assert(editor.ReplaceAll("remote.origin.fetch", []string{"+refs/heads/main:refs/remotes/origin/main", "+refs/notes/*:refs/notes/*"}), IsNil)
+		ok, err := editor.Unset("branch.main.remote")
+		c.Assert(err, IsNil)
+		c.Check(ok, Equals, true)
+		ok, err = editor.Unset("branch.main.missing")
+		c.Assert(err, IsNil)
+		c.Check(ok, Equals, false)
+
+		ok, err = editor.RenameKey("core.bare", "core.isBare")
+		c.Assert(err, IsNil)
+		c.Check(ok, Equals, true)
+		ok, err = editor.RenameKey("last.nonewline", "moved.here")
+		c.Assert(err, IsNil)
+		c.Check(ok, Equals, true)
+
+		ok, err = editor.RenameSection("remote.origin", "remote.upstream")
+		c.Assert(err, IsNil)
+		c.Check(ok, Equals, true)
+		ok, err = editor.RemoveSection("gone")
+		c.Assert(err, IsNil)
+		c.Check(ok, Equals, true)
+		ok, err = editor.RemoveSection("remote.origin")
+		c.Assert(err, IsNil)
+		c.Check(ok, Equals, false)
+
+		n, err := editor.UnsetAll("branch.main.merge")
+		c.Assert(err, IsNil)
+		c.Check(n, Equals, 1)
+	})
+	c.Check(contents, Equals, strings.Join([]string{
+		"# Kept as it is",
+		"[core]",
+		"    isBare = false   ; indented with spaces",
+		"",
+		"; Comment between sections",
+		"[remote \"upstream\"]",
+		"\turl = https://example.com/old.git",
+		"\tfetch = +refs/heads/main:refs/remotes/origin/main",
+		"\tfetch = +refs/notes/*:refs/notes/*",
+		"\t# Comment inside a section",
+		"[Branch \"main\"] ",
+		"[last]",
+		"[moved]",
+		"\there = true",
+		"",
+	}, "\n"))
+}
+
+func (s *MySuite) TestRepoEditConfig(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	editor, err := repo.EditConfig()
+	c.Assert(err, IsNil)
+
+	// The file stays locked until Commit or Rollback
+	_, err = repo.EditConfig()
+	c.Check(err, ErrorMatches, "Unable to create .*config.lock.*")
+	c.Assert(editor.Add("remote.mirror.fetch", "+refs/heads/*:refs/heads/*"), IsNil)
+	c.Assert(editor.Rollback(), IsNil)
+	c.Check(runGitIn(c, repo, repoDir, "config", "--default", "", "remote.mirror.fetch"), Equals, "")
+
+	editor, err = repo.EditConfig()
+	c.Assert(err, IsNil)
+	c.Assert(editor.Set("remote.mirror.url", "https://example.com/mirror.git"), IsNil)
+	c.Assert(editor.Add("remote.mirror.fetch", "+refs/heads/*:refs/heads/*"), IsNil)
+	c.Assert(editor.Add("remote.mirror.fetch", "+refs/tags/*:refs/tags/*"), IsNil)
+	c.Assert(editor.Commit(), IsNil)
+	c.Check(runGitIn(c, repo, repoDir, "config", "--get-all", "remote.mirror.fetch"), Equals,
+		"+refs/heads/*:refs/heads/*\n+refs/tags/*:refs/tags/*")
+	_, err = os.Stat(filepath.Join(repo.GitDir(), "config.lock"))
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// A file that does not exist yet
+	configPath := filepath.Join(c.MkDir(), "new-config")
+	editor, err = EditConfigFile(configPath)
+	c.Assert(err, IsNil)
+	c.Assert(editor.Set("core.bare", "true"), IsNil)
+	c.Assert(editor.Commit(), IsNil)
+	contents, err := ioutil.ReadFile(configPath)
+	c.Assert(err, IsNil)
+	c.Check(string(contents), Equals, "[core]\n\tbare = true\n")
+}
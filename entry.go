@@ -2,6 +2,8 @@ package gitobjects
 
 import (
 	"fmt"
+	"github.com/pkg/errors"
+	"os"
 )
 
 type Entry struct {
@@ -11,6 +13,9 @@ type Entry struct {
 
 	tree *Tree
 	blob *Blob
+
+	// A submodule commit, which has neither tree nor blob
+	gitlink bool
 }
 
 func (self *Entry) Type() string {
@@ -18,6 +23,8 @@ func (self *Entry) Type() string {
 		return "tree"
 	} else if self.blob != nil {
 		return "blob"
+	} else if self.gitlink {
+		return "commit"
 	} else {
 		panic(fmt.Sprintf("Entry sha1=%s has neither tree nor blob", self.sha1))
 	}
@@ -31,10 +38,44 @@ func (self *Entry) Sha1() string {
 	return self.sha1
 }
 
-func (self *Entry) Tree() *Tree {
+// The git mode of the entry, such as "100644" or "040000"
+func (self *Entry) Permissions() string {
+	return self.permissions
+}
+
+// The git mode of the entry, translated to an os.FileMode. Submodules
+// appear as directories, as they do in a checkout.
+func (self *Entry) FileMode() os.FileMode {
+	switch self.permissions {
+	case "100755":
+		return 0755
+	case "120000":
+		return os.ModeSymlink | 0777
+	case "040000", "40000", "160000":
+		return os.ModeDir | 0755
+	default:
+		return 0644
+	}
+}
+
+// Return the Tree for this entry, instantiating it first if the
+// Repo is in lazy mode and the Tree has not been read yet.
+func (self *Entry) Tree(repo *Repo) (*Tree, error) {
 	if self.tree == nil {
 		panic(fmt.Sprintf("Entry %s has no tree", self.sha1))
 	}
 
-	return self.tree
+	err := self.tree.Instantiate(repo)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Instantiating tree %s for entry %s", self.sha1, self.name)
+	}
+	return self.tree, nil
+}
+
+func (self *Entry) Blob() *Blob {
+	if self.blob == nil {
+		panic(fmt.Sprintf("Entry %s has no blob", self.sha1))
+	}
+
+	return self.blob
 }
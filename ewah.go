@@ -0,0 +1,81 @@
+package gitobjects
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+)
+
+// An EWAH-compressed bitmap, as git stores them in the index. Only reading
+// is supported, as the bit positions are all that is needed.
+//
+// The bitmap is a sequence of 64-bit words. A "running length word" says
+// how many words of all-0 or all-1 bits come next, and how many literal
+// words follow them, before the next running length word.
+type ewahBitmap struct {
+	// The number of bits the bitmap covers
+	bitCount uint32
+
+	words []uint64
+}
+
+// Parse a bitmap, returning the bytes after it
+func _parseEwahBitmap(data []byte) (*ewahBitmap, []byte, error) {
+	if len(data) < 8 {
+		return nil, nil, errors.New("Truncated EWAH bitmap header")
+	}
+	bitmap := &ewahBitmap{
+		bitCount: binary.BigEndian.Uint32(data[0:4]),
+	}
+	wordCount := int(binary.BigEndian.Uint32(data[4:8]))
+	data = data[8:]
+
+	// The words, and then the position of the last running length word
+	if len(data) < wordCount*8+4 {
+		return nil, nil, errors.Errorf("Truncated EWAH bitmap of %d words", wordCount)
+	}
+	bitmap.words = make([]uint64, wordCount)
+	for i := range bitmap.words {
+		bitmap.words[i] = binary.BigEndian.Uint64(data[i*8:])
+	}
+	return bitmap, data[wordCount*8+4:], nil
+}
+
+// Return the positions of the set bits, in increasing order
+func (self *ewahBitmap) Bits() ([]int, error) {
+	bits := make([]int, 0)
+	position := 0
+	for i := 0; i < len(self.words); {
+		runningLengthWord := self.words[i]
+		runningBit := runningLengthWord&1 == 1
+		runningLength := int((runningLengthWord >> 1) & 0xffffffff)
+		literalWords := int(runningLengthWord >> 33)
+		i++
+
+		if runningBit {
+			for bit := 0; bit < runningLength*64; bit++ {
+				bits = append(bits, position+bit)
+			}
+		}
+		position += runningLength * 64
+
+		if i+literalWords > len(self.words) {
+			return nil, errors.Errorf("EWAH bitmap has %d literal words after word %d, but only %d words",
+				literalWords, i-1, len(self.words))
+		}
+		for _, word := range self.words[i : i+literalWords] {
+			for bit := 0; bit < 64; bit++ {
+				if word&(1<<uint(bit)) != 0 {
+					bits = append(bits, position+bit)
+				}
+			}
+			position += 64
+		}
+		i += literalWords
+	}
+
+	// The last word may be padded
+	for len(bits) > 0 && bits[len(bits)-1] >= int(self.bitCount) {
+		bits = bits[:len(bits)-1]
+	}
+	return bits, nil
+}
@@ -0,0 +1,32 @@
+package gitobjects
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestParseEwahBitmap(c *C) {
+	// 70 bits: a running length word with no run and two literal words, with
+	// bits 0, 3 and 65 set, and then the position of the running length word
+	data := []byte{
+		0, 0, 0, 70, 0, 0, 0, 3,
+		0, 0, 0, 4, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 9,
+		0, 0, 0, 0, 0, 0, 0, 2,
+		0, 0, 0, 0,
+		'r', 'e', 's', 't',
+	}
+	bitmap, rest, err := _parseEwahBitmap(data)
+	c.Assert(err, IsNil)
+	c.Check(string(rest), Equals, "rest")
+	bits, err := bitmap.Bits()
+	c.Assert(err, IsNil)
+	c.Check(bits, DeepEquals, []int{0, 3, 65})
+
+	// A corrupt or truncated index gives an error, not a panic
+	for length := 0; length < 36; length++ {
+		_, _, err = _parseEwahBitmap(data[:length])
+		c.Check(err, ErrorMatches, "Truncated EWAH bitmap.*", Commentf("%d bytes", length))
+	}
+	_, _, err = _parseEwahBitmap([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	c.Check(err, ErrorMatches, "Truncated EWAH bitmap of 0 words")
+}
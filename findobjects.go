@@ -3,6 +3,7 @@ package gitobjects
 import (
 	"bufio"
 	"context"
+	"encoding/hex"
 	"fmt"
 	"github.com/crewjam/errset"
 	"github.com/pkg/errors"
@@ -10,80 +11,212 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 	"sync"
+	"time"
 )
 
-// Stream all Objects of a certain type on the Object channel. Once done reading the Object
-// Channel, read the error channel to see if the stream stopped due to any error. The context
-// can be used to cancel the request in progress.
-func (self *Repo) StreamObjectsOfType(ctx context.Context, objectType string, jFactor int) (<-chan Object, <-chan error) {
+// Tuning knobs for StreamObjectsOfTypeWithOptions. Start from
+// DefaultStreamOptions() and change what you need.
+type StreamOptions struct {
+	// How many pack indexes are read at the same time
+	PackReaders int
 
-	responseChan := make(chan Object, jFactor)
+	// How many objects are examined and instantiated at the same time
+	ObjectParsers int
 
-	// Buffered so it can be written to at any time
-	errorChan := make(chan error, 1)
+	// The buffer size of the channels between stages, and of the
+	// returned Object channel
+	ChannelBufferSize int
 
-	switch objectType {
-	case "commit":
-		// no-op
-	case "tree":
-		// no-op
-	case "blob":
-		// no-op
-	default:
-		close(responseChan)
-		errorChan <- errors.Errorf("Unknown object type '%s'", objectType)
-		close(errorChan)
-		return responseChan, errorChan
+	// Whether each Object is instantiated before it is sent. If false,
+	// Objects only know their sha1 and type (and size, for Blobs).
+	InstantiateObjects bool
+
+	// Whether the Tree of each Commit is instantiated before the
+	// Commit is sent
+	InstantiateCommitTrees bool
+
+	// If not nil, only Objects for which Filter returns true are sent.
+	// It is called from several goroutines at once.
+	Filter func(Object) bool
+
+	// If not nil, called every ProgressInterval (default: one second)
+	// with the progress of the stream, and once more at the end
+	Progress         ProgressFunc
+	ProgressInterval time.Duration
+}
+
+// Options scaled to the number of CPUs that Go will use
+func DefaultStreamOptions() *StreamOptions {
+	numProcs := runtime.GOMAXPROCS(0)
+	packReaders := numProcs / 4
+	if packReaders < 2 {
+		packReaders = 2
+	}
+	return &StreamOptions{
+		PackReaders:            packReaders,
+		ObjectParsers:          numProcs,
+		ChannelBufferSize:      numProcs,
+		InstantiateObjects:     true,
+		InstantiateCommitTrees: true,
 	}
+}
+
+// Stream all Objects of a certain type on the Object channel. Once done reading the Object
+// Channel, read the error channel to see if the stream stopped due to any error. The first
+// error stops the whole stream; if several stages failed, the error is an errset.ErrSet of
+// all of them. The context can be used to cancel the request in progress.
+func (self *Repo) StreamObjectsOfType(ctx context.Context, objectType string, jFactor int) (<-chan Object, <-chan error) {
+	return self.StreamObjectsOfTypeWithOptions(ctx, objectType, _jFactorStreamOptions(jFactor))
+}
 
+// The options which StreamObjectsOfType has always used
+func _jFactorStreamOptions(jFactor int) *StreamOptions {
 	if jFactor < 1 {
 		jFactor = 1
 	}
+	options := DefaultStreamOptions()
+	options.PackReaders = 2
+	options.ObjectParsers = jFactor
+	options.ChannelBufferSize = jFactor
+	return options
+}
+
+// Like StreamObjectsOfType, but with full control over the pipeline.
+// If options is nil, DefaultStreamOptions() is used.
+func (self *Repo) StreamObjectsOfTypeWithOptions(ctx context.Context, objectType string,
+	options *StreamOptions) (<-chan Object, <-chan error) {
+
+	return self.StreamObjectsOfTypes(ctx, []string{objectType}, options)
+}
+
+// The object types which can be streamed
+var streamableObjectTypes = []string{"commit", "tree", "blob", "tag"}
+
+// Stream the Objects of several types in a single pass over the object database.
+// Each Object is a *Commit, *Tree, *Blob or *Tag; use Type() to tell them apart.
+// Each is sent once, even if it is in several pack files or is also loose;
+// to do that, every sha1 seen is remembered until the stream ends, which
+// costs roughly 50 bytes per object in the repository, or about 500 MB for
+// 10 million objects. Callers need not dedupe them again. If objectTypes is empty, Objects of every type are sent. If options is nil,
+// DefaultStreamOptions() is used.
+func (self *Repo) StreamObjectsOfTypes(ctx context.Context, objectTypes []string,
+	options *StreamOptions) (<-chan Object, <-chan error) {
+
+	if options == nil {
+		options = DefaultStreamOptions()
+	}
+	numPackProcessors := options.PackReaders
+	if numPackProcessors < 1 {
+		numPackProcessors = 1
+	}
+	numObjectProcessors := options.ObjectParsers
+	if numObjectProcessors < 1 {
+		numObjectProcessors = 1
+	}
+	bufferSize := options.ChannelBufferSize
+	if bufferSize < 0 {
+		bufferSize = 0
+	}
+
+	responseChan := make(chan Object, bufferSize)
+
+	// Buffered so the one (possibly aggregated) error can be sent
+	// without waiting for the caller
+	errorChan := make(chan error, 1)
+
+	if len(objectTypes) == 0 {
+		objectTypes = streamableObjectTypes
+	}
+	wantedTypes := make(map[string]bool)
+	for _, objectType := range objectTypes {
+		switch objectType {
+		case "commit", "tree", "blob", "tag":
+			wantedTypes[objectType] = true
+		default:
+			close(responseChan)
+			errorChan <- errors.Errorf("Unknown object type '%s'", objectType)
+			close(errorChan)
+			return responseChan, errorChan
+		}
+	}
 
-	packFileChan := make(chan string)
-	looseObjectSha1Chan := make(chan string)
+	// Every stage runs under the group, so the first error cancels
+	// all of them, and every error is collected.
+	group := newPipelineGroup(ctx)
+	ctx = group.Context()
+
+	tracker := newProgressTracker("stream", options.Progress, options.ProgressInterval)
+	tracker.Start()
+
+	packFileChan := make(chan string, bufferSize)
+	looseObjectSha1Chan := make(chan string, bufferSize)
 
 	// One go-routine to find the pack files
-	go _findPackFiles(ctx, self.gitDir, packFileChan)
+	group.Go(func() error {
+		return _findPackFiles(ctx, self.gitDir, packFileChan, tracker)
+	})
 
 	// One go-routine to find the loose object files
-	go _findLooseObjectFiles(ctx, self.gitDir, looseObjectSha1Chan, errorChan)
+	group.Go(func() error {
+		return _findLooseObjectFiles(ctx, self.gitDir, looseObjectSha1Chan, tracker)
+	})
 
 	// Start n pack file processing go-routines
-	numPackProcessors := 2
 	packProcessorChans := make([]<-chan string, numPackProcessors)
 
 	for i := 0; i < numPackProcessors; i++ {
-		packObjectSha1Chan := make(chan string)
+		packObjectSha1Chan := make(chan string, bufferSize)
 		packProcessorChans[i] = packObjectSha1Chan
-		go _parsePackFile(ctx, self, packFileChan, packObjectSha1Chan, errorChan)
+		group.Go(func() error {
+			return _parsePackFile(ctx, self, packFileChan, packObjectSha1Chan, tracker)
+		})
 	}
 
 	// Launch a go-routine to merge all the object sha1 chans into one
-	objectSha1Chan := make(chan string)
-	go _mergeObjectSha1Chans(looseObjectSha1Chan, packProcessorChans, objectSha1Chan)
+	objectSha1Chan := make(chan string, bufferSize)
+	group.Go(func() error {
+		return _mergeObjectSha1Chans(ctx, looseObjectSha1Chan, packProcessorChans, objectSha1Chan)
+	})
 
 	// Start n routines to process individual object sha1s
-	numObjectProcessors := jFactor
 	objectProcessorChans := make([]<-chan Object, numObjectProcessors)
 
 	for i := 0; i < numObjectProcessors; i++ {
-		objectProcessorChan := make(chan Object)
+		objectProcessorChan := make(chan Object, bufferSize)
 		objectProcessorChans[i] = objectProcessorChan
-		go _parseObjectSha1(ctx, self, objectType, objectSha1Chan, objectProcessorChan, errorChan)
+		group.Go(func() error {
+			return _parseObjectSha1(ctx, self, wantedTypes, options, objectSha1Chan, objectProcessorChan, tracker)
+		})
 	}
 
 	// Launch a go-routine to merge the object processor chans into one.
 	// This is the routine that sends responsed ot the user
-	go _mergeProcessedObjectChans(objectProcessorChans, responseChan, errorChan)
+	group.Go(func() error {
+		return _mergeProcessedObjectChans(ctx, objectProcessorChans, responseChan)
+	})
+
+	// Once every stage has finished, report the errors, if any. The error is
+	// sent before responseChan is closed, so that callers which stop at the
+	// end of responseChan can still find it in errorChan.
+	go func() {
+		err := group.Wait()
+		tracker.Stop()
+		if err != nil {
+			errorChan <- err
+		}
+		close(responseChan)
+		close(errorChan)
+	}()
 
 	return responseChan, errorChan
 }
 
 // Examine the .git directory, looking for pack files
-func _findPackFiles(ctx context.Context, gitDir string, packFileChan chan<- string) {
+func _findPackFiles(ctx context.Context, gitDir string, packFileChan chan<- string,
+	tracker *progressTracker) error {
 	defer close(packFileChan)
 
 	globPattern := filepath.Join(gitDir, "objects", "pack", "pack-*.idx")
@@ -94,92 +227,122 @@ func _findPackFiles(ctx context.Context, gitDir string, packFileChan chan<- stri
 		panic(err.Error())
 	}
 
+	// Count the objects up front, for the ETA. This is best-effort;
+	// a bad index will be reported when it is parsed.
+	if tracker != nil {
+		for _, filename := range packFiles {
+			numObjects, err := _packIndexObjectCount(filename)
+			if err != nil {
+				numObjects = 0
+			}
+			tracker.AddPacks(1, numObjects)
+		}
+	}
+
 	for _, filename := range packFiles {
 		select {
+		case packFileChan <- filename:
 		case <-ctx.Done():
-			return
-		default:
-			break
+			return nil
 		}
-		packFileChan <- filename
 	}
+	return nil
 }
 
 // Parse each pack file and send the object sha1s contained within it
-func _parsePackFile(ctx context.Context, gitRepo *Repo, packFileChan <-chan string, packObjectSha1Chan chan<- string,
-	errorChan chan<- error) {
+func _parsePackFile(ctx context.Context, gitRepo *Repo, packFileChan <-chan string,
+	packObjectSha1Chan chan<- string, tracker *progressTracker) error {
 
 	defer close(packObjectSha1Chan)
 
 	for packFile := range packFileChan {
-		//		log.Printf("Examining pack file %s", packFile)
-		packFileObject, err := os.Open(packFile)
-		if err != nil {
-			errorChan <- errors.Wrapf(err, "Opening pack file %s", packFile)
-			return
-		}
-
-		cmd := gitRepo.Command([]string{"show-index"})
-		cmd.Stdin = packFileObject
-		cmd.Stdout = nil // During testing, I'm setting cmd.Stdout to os.Stdout, so this fixes is
-		stdout, err := cmd.StdoutPipe()
+		err := _parseOnePackFile(ctx, gitRepo, packFile, packObjectSha1Chan)
 		if err != nil {
-			errorChan <- errors.Wrapf(err, "Getting stdout pipe for git show-index on pack file %s", packFile)
-			_ = packFileObject.Close()
-			return
+			return err
 		}
-		err = cmd.Start()
-		if err != nil {
-			errorChan <- errors.Wrapf(err, "Starting git show-index on pack file %s", packFile)
-			_ = packFileObject.Close()
-			return
+		tracker.PackProcessed()
+		if ctx.Err() != nil {
+			return nil
 		}
+	}
+	return nil
+}
 
-		// Read the output line-by-line
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			fields := strings.Split(scanner.Text(), " ")
-			if len(fields) != 3 {
-				errorChan <- errors.Errorf("Got unexpected line from show-index of %s: %s",
-					packFile, scanner.Text())
-				_ = packFileObject.Close()
-				return
-			}
+// Run show-index on one pack file, always waiting for the command to exit
+func _parseOnePackFile(ctx context.Context, gitRepo *Repo, packFile string,
+	packObjectSha1Chan chan<- string) error {
 
-			// The 2nd field has the sha1
-			select {
-			case <-ctx.Done():
-				_ = packFileObject.Close()
-				return
-			default:
-				break
-			}
-			packObjectSha1Chan <- fields[1]
+	//		log.Printf("Examining pack file %s", packFile)
+	packFileObject, err := os.Open(packFile)
+	if err != nil {
+		return errors.Wrapf(err, "Opening pack file %s", packFile)
+	}
+
+	cmd := gitRepo.Command([]string{"show-index"})
+	cmd.Stdin = packFileObject
+	cmd.Stdout = nil // During testing, I'm setting cmd.Stdout to os.Stdout, so this fixes is
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		_ = packFileObject.Close()
+		return errors.Wrapf(err, "Getting stdout pipe for git show-index on pack file %s", packFile)
+	}
+	err = cmd.Start()
+	if err != nil {
+		_ = packFileObject.Close()
+		return errors.Wrapf(err, "Starting git show-index on pack file %s", packFile)
+	}
+
+	// Read the output line-by-line
+	var lineError error
+	stopped := false
+	scanner := bufio.NewScanner(stdout)
+	for !stopped && scanner.Scan() {
+		fields := strings.Split(scanner.Text(), " ")
+		if len(fields) != 3 {
+			lineError = errors.Errorf("Got unexpected line from show-index of %s: %s",
+				packFile, scanner.Text())
+			stopped = true
+			break
 		}
 
-		errs := errset.ErrSet{}
-		// Command error?
-		cmdError := cmd.Wait()
-		errs = append(errs, cmdError)
+		// The 2nd field has the sha1
+		select {
+		case packObjectSha1Chan <- fields[1]:
+		case <-ctx.Done():
+			stopped = true
+		}
+	}
+
+	// If we stopped reading early, show-index could block on a full pipe
+	// forever, so kill it; its exit status is then meaningless.
+	if stopped {
+		_ = cmd.Process.Kill()
+	}
+
+	errs := errset.ErrSet{lineError}
+	// Command error?
+	cmdError := cmd.Wait()
+	if !stopped {
+		errs = append(errs, errors.Wrapf(cmdError, "Running git show-index on pack file %s", packFile))
+	}
 
-		// os.Close() error?
-		fileError := packFileObject.Close()
-		errs = append(errs, fileError)
+	// os.Close() error?
+	fileError := packFileObject.Close()
+	errs = append(errs, fileError)
 
-		// Scanner error?
+	// Scanner error?
+	if !stopped {
 		scanError := scanner.Err()
 		errs = append(errs, scanError)
-
-		// Did any of those have an error?
-		if errs.ReturnValue() != nil {
-			errorChan <- errs.ReturnValue()
-			return
-		}
 	}
+
+	// Did any of those have an error?
+	return errs.ReturnValue()
 }
 
 // Find all loose object files
-func _findLooseObjectFiles(ctx context.Context, gitDir string, looseObjectSha1Chan chan<- string, errorChan chan<- error) {
+func _findLooseObjectFiles(ctx context.Context, gitDir string, looseObjectSha1Chan chan<- string,
+	tracker *progressTracker) error {
 	defer close(looseObjectSha1Chan)
 
 	sha1FilenameRegex, err := regexp.Compile(`^[0-9a-f]{38}$`)
@@ -196,6 +359,9 @@ func _findLooseObjectFiles(ctx context.Context, gitDir string, looseObjectSha1Ch
 
 	err = filepath.Walk(filepath.Join(gitDir, "objects"),
 		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return errors.Wrapf(err, "Walking %s", path)
+			}
 			if info.IsDir() {
 				return nil
 			}
@@ -204,145 +370,198 @@ func _findLooseObjectFiles(ctx context.Context, gitDir string, looseObjectSha1Ch
 				parentPath := filepath.Dir(path)
 				parentBase := filepath.Base(parentPath)
 				if sha1DirectoryRegex.MatchString(parentBase) {
+					tracker.LooseObjectFound()
 					select {
+					case looseObjectSha1Chan <- parentBase + base:
 					case <-ctx.Done():
 						return cancelSignalError
-					default:
-						break
 					}
-					looseObjectSha1Chan <- parentBase + base
 				}
 			}
 			return nil
 		})
 
 	if err != nil && err != cancelSignalError {
-		errorChan <- err
-		return
+		return err
 	}
+	return nil
 }
 
-// Take a sha1 and check the object type; if it is what we want, create an Object
+// Take a sha1 and check the object type; if it is one we want, create an Object
 // from it and send it.
-func _parseObjectSha1(ctx context.Context, gitRepo *Repo, objectType string, objectSha1Chan <-chan string,
-	objectProcessorChan chan<- Object, errorChan chan<- error) {
+func _parseObjectSha1(ctx context.Context, gitRepo *Repo, wantedTypes map[string]bool, options *StreamOptions,
+	objectSha1Chan <-chan string, objectProcessorChan chan<- Object, tracker *progressTracker) (err error) {
 
 	defer close(objectProcessorChan)
 
+	// One cat-file process answers for every object this goroutine examines
+	batchCheck, err := newCatFileBatchCheck(gitRepo)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := batchCheck.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
 	for sha1 := range objectSha1Chan {
 		//		log.Printf("Examining git object file %s", sha1)
-		output, err := gitRepo.CmdOutput([]string{"cat-file", "-t", sha1})
+		type_, size, err := batchCheck.Check(sha1)
 		if err != nil {
-			errorChan <- errors.Wrapf(err, "Getting object type for %s", sha1)
-			return
+			return errors.Wrapf(err, "Getting object type for %s", sha1)
 		}
-		type_ := strings.TrimRight(string(output), "\n")
-		if type_ == objectType {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				break
+		tracker.ObjectSeen(size)
+		if wantedTypes[type_] {
+			if ctx.Err() != nil {
+				return nil
 			}
-			switch objectType {
+			var obj Object
+			switch type_ {
 			case "commit":
 				commit := &Commit{
 					sha1: sha1,
 				}
-				//				log.Printf("Instantiating commit object")
-				err = commit.Instantiate(gitRepo)
-				if err != nil {
-					errorChan <- errors.Wrapf(err, "Instantiating commit %s", sha1)
-					return
+				if options.InstantiateObjects {
+					//				log.Printf("Instantiating commit object")
+					err = commit.Instantiate(gitRepo)
+					if err != nil {
+						return errors.Wrapf(err, "Instantiating commit %s", sha1)
+					}
 				}
-				// XXX Make this configurable
-				_, err = commit.InstantiateTree(gitRepo)
-				if err != nil {
-					errorChan <- errors.Wrapf(err, "Instantiating tee for commit %s", sha1)
-					return
+				if options.InstantiateObjects && options.InstantiateCommitTrees {
+					_, err = commit.InstantiateTree(gitRepo)
+					if err != nil {
+						return errors.Wrapf(err, "Instantiating tee for commit %s", sha1)
+					}
 				}
-
-				objectProcessorChan <- commit
+				obj = commit
 			case "tree":
 				tree := &Tree{
 					sha1: sha1,
 				}
-				//				log.Printf("Instantiating tree object")
-				err = tree.Instantiate(gitRepo)
-				if err != nil {
-					errorChan <- errors.Wrapf(err, "Instantiating tree %s", sha1)
-					return
+				if options.InstantiateObjects {
+					//				log.Printf("Instantiating tree object")
+					err = tree.Instantiate(gitRepo)
+					if err != nil {
+						return errors.Wrapf(err, "Instantiating tree %s", sha1)
+					}
 				}
-				objectProcessorChan <- tree
+				obj = tree
 			case "blob":
+				// The size comes for free
 				blob := &Blob{
+					sha1:      sha1,
+					size:      int(size),
+					sizeKnown: true,
+				}
+				if options.InstantiateObjects {
+					//				log.Printf("Instantiating blob object")
+					err = blob.Instantiate(gitRepo)
+					if err != nil {
+						return errors.Wrapf(err, "Instantiating blob %s", sha1)
+					}
+				}
+				obj = blob
+			case "tag":
+				tag := &Tag{
 					sha1: sha1,
 				}
-				//				log.Printf("Instantiating blob object")
-				err = blob.Instantiate(gitRepo)
-				if err != nil {
-					errorChan <- errors.Wrapf(err, "Instantiating blob %s", sha1)
-					return
+				if options.InstantiateObjects {
+					err = tag.Instantiate(gitRepo)
+					if err != nil {
+						return errors.Wrapf(err, "Instantiating tag %s", sha1)
+					}
 				}
-				objectProcessorChan <- blob
+				obj = tag
 			default:
-				panic(fmt.Sprintf("obj type %s not yet supported", objectType))
+				panic(fmt.Sprintf("obj type %s not yet supported", type_))
+			}
+
+			if options.Filter != nil && !options.Filter(obj) {
+				continue
+			}
+			select {
+			case objectProcessorChan <- obj:
+				tracker.ObjectMatched()
+			case <-ctx.Done():
+				return nil
 			}
 		}
 	}
+	return nil
 }
 
-// Merge the sha1s from 2 channels into 1 channel
-func _mergeObjectSha1Chans(looseObjectSha1Chan <-chan string, packProcessorChans []<-chan string,
-	objectSha1Chan chan<- string) {
+// Merge the sha1s from 2 channels into 1 channel. An object can be in
+// several packs, or be both loose and packed, so only the first of each
+// sha1 is passed on. The sha1s seen are kept in binary form, to halve the
+// memory the set takes.
+func _mergeObjectSha1Chans(ctx context.Context, looseObjectSha1Chan <-chan string,
+	packProcessorChans []<-chan string, objectSha1Chan chan<- string) error {
+
+	defer close(objectSha1Chan)
 
 	var wg sync.WaitGroup
+	var seenLock sync.Mutex
+	seen := make(map[[20]byte]struct{})
 
 	// Goroutine to shovel from one channel to another
 	shovelFunc := func(c <-chan string) {
+		defer wg.Done()
 		for sha1 := range c {
-			objectSha1Chan <- sha1
+			var key [20]byte
+			hex.Decode(key[:], []byte(sha1))
+			seenLock.Lock()
+			_, duplicate := seen[key]
+			seen[key] = struct{}{}
+			seenLock.Unlock()
+			if duplicate {
+				continue
+			}
+			select {
+			case objectSha1Chan <- sha1:
+			case <-ctx.Done():
+				return
+			}
 		}
-		wg.Done()
 	}
-	go shovelFunc(looseObjectSha1Chan)
 	wg.Add(1)
+	go shovelFunc(looseObjectSha1Chan)
 	for _, c := range packProcessorChans {
-		go shovelFunc(c)
 		wg.Add(1)
+		go shovelFunc(c)
 	}
 
-	// Goroutine to close the output channel when the previous goroutines finish
-	go func() {
-		wg.Wait()
-		close(objectSha1Chan)
-	}()
+	// Close the output channel when the shovels finish
+	wg.Wait()
+	return nil
 }
 
 // Merge the sha1s from the channels that have sha1s of the correct type,
-// and send them down the responseChan back to the caller
-func _mergeProcessedObjectChans(objectProcessorChans []<-chan Object, responseChan chan<- Object,
-	errorChan chan<- error) {
+// and send them down the responseChan back to the caller. The caller closes
+// responseChan once every stage of the pipeline is done.
+func _mergeProcessedObjectChans(ctx context.Context, objectProcessorChans []<-chan Object,
+	responseChan chan<- Object) error {
 
 	var wg sync.WaitGroup
 
 	// Goroutine to shovel from one channel to another
 	shovelFunc := func(c <-chan Object) {
+		defer wg.Done()
 		for obj := range c {
-			responseChan <- obj
+			select {
+			case responseChan <- obj:
+			case <-ctx.Done():
+				return
+			}
 		}
-		wg.Done()
 	}
 	for _, c := range objectProcessorChans {
-		go shovelFunc(c)
 		wg.Add(1)
+		go shovelFunc(c)
 	}
 
-	// Goroutine to close the output channel when the previous goroutines finish
-	go func() {
-		wg.Wait()
-		close(responseChan)
-		// Also close the ErrorChan, as we're the last goroutine in the pipeline
-		close(errorChan)
-	}()
+	wg.Wait()
+	return nil
 }
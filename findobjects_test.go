@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 )
@@ -80,6 +81,18 @@ func modifyReadmeAndPack(c *C, repo *Repo, repoDir string) {
 
 }
 
+// Leave objects in two packs, and loose objects that are also packed: the
+// objects of the first two commits are in the pack made by gc and in a new
+// one, and those of a third commit are loose and in the new pack
+func duplicateObjects(c *C, repo *Repo, repoDir string) {
+	modifyReadmeAndPack(c, repo, repoDir)
+	runGitIn(c, repo, repoDir, "commit", "--allow-empty", "-m", "Third")
+	runGitIn(c, repo, repoDir, "repack", "-a")
+	packs, err := filepath.Glob(filepath.Join(repo.GitDir(), "objects", "pack", "*.pack"))
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 2)
+}
+
 func addNotes(c *C, repo *Repo, repoDir string) {
 	// Commit a new file, to create a new loose object
 	// Touch a file
@@ -109,9 +122,15 @@ func (s *MySuite) TestFindLooseObjectFiles(c *C) {
 	// the goroutine goes crazy
 
 	sha1Chan := make(chan string)
-	errorChan := make(chan error)
+	errorChan := make(chan error, 1)
 	ctx, _ := context.WithCancel(context.Background())
-	go _findLooseObjectFiles(ctx, repo.GitDir(), sha1Chan, errorChan)
+	go func() {
+		defer close(errorChan)
+		err := _findLooseObjectFiles(ctx, repo.GitDir(), sha1Chan, nil)
+		if err != nil {
+			errorChan <- err
+		}
+	}()
 
 	timeout := time.NewTimer(time.Duration(3) * time.Second)
 	for keepGoing := true; keepGoing; {
@@ -131,12 +150,15 @@ func (s *MySuite) TestFindLooseObjectFiles(c *C) {
 				c.Assert(err, IsNil)
 				c.Check(output, DeepEquals, []byte{'t', 'e', 's', 't', '\n'})
 			}
-		case err := <-errorChan:
+		case err, ok := <-errorChan:
+			if !ok {
+				errorChan = nil
+				break
+			}
 			c.Errorf("Received error: %s", err)
 		}
 	}
 	timeout.Stop()
-	close(errorChan)
 }
 
 func (s *MySuite) TestPackFiles(c *C) {
@@ -148,10 +170,16 @@ func (s *MySuite) TestPackFiles(c *C) {
 
 	packFileChan := make(chan string)
 	sha1Chan := make(chan string)
-	errorChan := make(chan error)
+	errorChan := make(chan error, 1)
 	ctx, _ := context.WithCancel(context.Background())
-	go _findPackFiles(ctx, repo.GitDir(), packFileChan)
-	go _parsePackFile(ctx, repo, packFileChan, sha1Chan, errorChan)
+	go _findPackFiles(ctx, repo.GitDir(), packFileChan, nil)
+	go func() {
+		defer close(errorChan)
+		err := _parsePackFile(ctx, repo, packFileChan, sha1Chan, nil)
+		if err != nil {
+			errorChan <- err
+		}
+	}()
 
 	timeout := time.NewTimer(time.Duration(3) * time.Second)
 	foundFirst := false
@@ -181,12 +209,15 @@ func (s *MySuite) TestPackFiles(c *C) {
 					c.FailNow()
 				}
 			}
-		case err := <-errorChan:
+		case err, ok := <-errorChan:
+			if !ok {
+				errorChan = nil
+				break
+			}
 			c.Errorf("Received error: %s", err)
 		}
 	}
 	timeout.Stop()
-	close(errorChan)
 
 	c.Check(foundFirst, Equals, true)
 	c.Check(foundSecond, Equals, true)
@@ -268,3 +299,138 @@ func (s *MySuite) TestStreamCancel(c *C) {
 
 	c.Check(numFound, Equals, 0)
 }
+
+func (s *MySuite) TestStreamReportsConcurrentErrors(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	modifyReadmeAndPack(c, repo, repoDir)
+
+	// Several corrupt pack indexes, so that the pack processors
+	// all fail at about the same time
+	for _, name := range []string{"a", "b", "c", "d"} {
+		idxFile := filepath.Join(repo.GitDir(), "objects", "pack", "pack-"+name+".idx")
+		err := ioutil.WriteFile(idxFile, []byte("garbage"), 0666)
+		c.Assert(err, IsNil)
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(3)*time.Second)
+	defer cancelFunc()
+	objectChan, errorChan := repo.StreamObjectsOfType(ctx, "blob", 2)
+
+	// Don't read errorChan until objectChan is closed; the stream must
+	// still finish, and must not block on the extra errors.
+	for range objectChan {
+	}
+	c.Check(ctx.Err(), IsNil)
+
+	err := <-errorChan
+	c.Assert(err, NotNil)
+	c.Check(err, ErrorMatches, "(?s).*show-index.*")
+	_, ok := <-errorChan
+	c.Check(ok, Equals, false)
+}
+
+func (s *MySuite) TestStreamWithOptions(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	modifyReadmeAndPack(c, repo, repoDir)
+	addNotes(c, repo, repoDir)
+
+	defaults := DefaultStreamOptions()
+	c.Check(defaults.PackReaders >= 2, Equals, true)
+	c.Check(defaults.ObjectParsers, Equals, runtime.GOMAXPROCS(0))
+	c.Check(defaults.InstantiateCommitTrees, Equals, true)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(3)*time.Second)
+	defer cancelFunc()
+
+	// Only the commits that have a parent, without their trees
+	options := DefaultStreamOptions()
+	options.PackReaders = 1
+	options.ObjectParsers = 3
+	options.ChannelBufferSize = 0
+	options.InstantiateCommitTrees = false
+	options.Filter = func(obj Object) bool {
+		return len(obj.(*Commit).ParentSha1s()) > 0
+	}
+	objectChan, errorChan := repo.StreamObjectsOfTypeWithOptions(ctx, "commit", options)
+
+	numFound := 0
+	for obj := range objectChan {
+		commit := obj.(*Commit)
+		c.Check(commit.tree, IsNil)
+		c.Check(commit.Message(), Not(Equals), "Add README")
+		numFound++
+	}
+	c.Check(<-errorChan, IsNil)
+	c.Check(numFound, Equals, 2)
+
+	// nil means the defaults
+	objectChan, errorChan = repo.StreamObjectsOfTypeWithOptions(ctx, "commit", nil)
+	numFound = 0
+	for obj := range objectChan {
+		c.Check(obj.(*Commit).tree, NotNil)
+		numFound++
+	}
+	c.Check(<-errorChan, IsNil)
+	c.Check(numFound, Equals, 3)
+}
+
+func (s *MySuite) TestStreamSeveralTypes(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	modifyReadmeAndPack(c, repo, repoDir)
+	addNotes(c, repo, repoDir)
+
+	cmd := repo.Command([]string{"tag", "-a", "-m", "Release", "v1.0"})
+	cmd.Dir = repoDir
+	c.Assert(cmd.Run(), IsNil)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(3)*time.Second)
+	defer cancelFunc()
+
+	// Every type at once
+	counts := make(map[string]int)
+	objectChan, errorChan := repo.StreamObjectsOfTypes(ctx, nil, nil)
+	for obj := range objectChan {
+		counts[obj.Type()]++
+	}
+	c.Assert(<-errorChan, IsNil)
+	c.Check(counts, DeepEquals, map[string]int{"commit": 3, "tree": 3, "blob": 3, "tag": 1})
+
+	// Just two types
+	counts = make(map[string]int)
+	objectChan, errorChan = repo.StreamObjectsOfTypes(ctx, []string{"tag", "blob"}, nil)
+	for obj := range objectChan {
+		counts[obj.Type()]++
+		if tag, ok := obj.(*Tag); ok {
+			c.Check(tag.Name(), Equals, "v1.0")
+		}
+	}
+	c.Assert(<-errorChan, IsNil)
+	c.Check(counts, DeepEquals, map[string]int{"blob": 3, "tag": 1})
+
+	objectChan, errorChan = repo.StreamObjectsOfTypes(ctx, []string{"blob", "bogus"}, nil)
+	_, ok := <-objectChan
+	c.Check(ok, Equals, false)
+	c.Check(<-errorChan, ErrorMatches, "Unknown object type 'bogus'")
+}
+
+func (s *MySuite) TestStreamDuplicateObjects(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	duplicateObjects(c, repo, repoDir)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(3)*time.Second)
+	defer cancelFunc()
+
+	// Each object comes once
+	counts := make(map[string]int)
+	objectChan, errorChan := repo.StreamObjectsOfTypes(ctx, nil, nil)
+	for obj := range objectChan {
+		counts[obj.Sha1()]++
+	}
+	c.Assert(<-errorChan, IsNil)
+	expected := make(map[string]int)
+	output := runGitIn(c, repo, repoDir, "cat-file", "--batch-all-objects", "--batch-check=%(objectname)")
+	for _, sha1 := range strings.Fields(output) {
+		expected[sha1] = 1
+	}
+	c.Check(counts, DeepEquals, expected)
+}
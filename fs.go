@@ -0,0 +1,257 @@
+package gitobjects
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// A read-only io/fs.FS view of a Tree. Blobs are served as files, with their
+// modes taken from the tree entries and every ModTime set to the same time,
+// usually the commit time. Submodules appear as empty directories.
+type TreeFS struct {
+	repo    *Repo
+	tree    *Tree
+	modTime time.Time
+}
+
+var _ fs.FS = (*TreeFS)(nil)
+var _ fs.ReadDirFS = (*TreeFS)(nil)
+var _ fs.StatFS = (*TreeFS)(nil)
+
+// Create an fs.FS for a tree, with modTime as the ModTime of every file
+func NewTreeFS(repo *Repo, tree *Tree, modTime time.Time) *TreeFS {
+	return &TreeFS{
+		repo:    repo,
+		tree:    tree,
+		modTime: modTime,
+	}
+}
+
+// Create an fs.FS for the Tree of a Commit, using the commit time as
+// the ModTime of every file.
+func (self *Commit) FS(repo *Repo) (*TreeFS, error) {
+	tree := self.tree
+	if tree == nil {
+		var err error
+		tree, err = self.InstantiateTree(repo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	committer, err := self.Committer()
+	if err != nil {
+		return nil, err
+	}
+	return NewTreeFS(repo, tree, committer.When), nil
+}
+
+func (self *TreeFS) Open(name string) (fs.File, error) {
+	info, err := self._stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &treeFSDir{
+			fsys: self,
+			info: info,
+		}, nil
+	}
+
+	contents, err := info.entry.blob.Contents(self.repo)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &treeFSFile{
+		info:   info,
+		Reader: bytes.NewReader(contents),
+	}, nil
+}
+
+func (self *TreeFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := self._stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (self *TreeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := self._stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return self._readDir(name, info)
+}
+
+// Find the entry for a name; the root of the FS has no entry
+func (self *TreeFS) _stat(op string, name string) (*treeFSFileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &treeFSFileInfo{
+			fsys: self,
+			name: ".",
+		}, nil
+	}
+
+	entry, err := self.tree.Lookup(self.repo, name)
+	if IsPathNotFound(err) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	} else if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return &treeFSFileInfo{
+		fsys:  self,
+		name:  entry.name,
+		entry: entry,
+	}, nil
+}
+
+// Return the entries of a directory, sorted by name as fs.ReadDir requires
+func (self *TreeFS) _readDir(name string, info *treeFSFileInfo) ([]fs.DirEntry, error) {
+	// Submodules are empty
+	if info.entry != nil && info.entry.Type() == "commit" {
+		return []fs.DirEntry{}, nil
+	}
+
+	tree := self.tree
+	if info.entry != nil {
+		var err error
+		tree, err = info.entry.Tree(self.repo)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+	}
+	entries, err := tree.Entries(self.repo)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	dirEntries := make([]fs.DirEntry, len(entries))
+	for i, entry := range entries {
+		dirEntries[i] = fs.FileInfoToDirEntry(&treeFSFileInfo{
+			fsys:  self,
+			name:  entry.name,
+			entry: entry,
+		})
+	}
+	sort.Slice(dirEntries, func(i, j int) bool {
+		return dirEntries[i].Name() < dirEntries[j].Name()
+	})
+	return dirEntries, nil
+}
+
+// The fs.FileInfo for an Entry, or for the root if entry is nil
+type treeFSFileInfo struct {
+	fsys  *TreeFS
+	name  string
+	entry *Entry
+}
+
+func (self *treeFSFileInfo) Name() string {
+	return self.name
+}
+
+// The size of a blob is looked up lazily, so a failure to look it up
+// is reported as a size of 0.
+func (self *treeFSFileInfo) Size() int64 {
+	if self.entry == nil || self.entry.Type() != "blob" {
+		return 0
+	}
+	size, err := self.entry.blob.DecompressedSizeBytes(self.fsys.repo)
+	if err != nil {
+		return 0
+	}
+	return int64(size)
+}
+
+func (self *treeFSFileInfo) Mode() fs.FileMode {
+	if self.entry == nil {
+		return fs.ModeDir | 0755
+	}
+	return self.entry.FileMode()
+}
+
+func (self *treeFSFileInfo) ModTime() time.Time {
+	return self.fsys.modTime
+}
+
+func (self *treeFSFileInfo) IsDir() bool {
+	return self.Mode().IsDir()
+}
+
+// Returns the *Entry, or nil for the root
+func (self *treeFSFileInfo) Sys() interface{} {
+	return self.entry
+}
+
+// An open blob. It is also an io.Seeker and io.ReaderAt,
+// which http.FileServer needs.
+type treeFSFile struct {
+	*bytes.Reader
+	info *treeFSFileInfo
+}
+
+func (self *treeFSFile) Stat() (fs.FileInfo, error) {
+	return self.info, nil
+}
+
+func (self *treeFSFile) Close() error {
+	return nil
+}
+
+// An open directory
+type treeFSDir struct {
+	fsys *TreeFS
+	info *treeFSFileInfo
+
+	// Read by ReadDir(n) calls with n > 0
+	entries []fs.DirEntry
+	offset  int
+	read    bool
+}
+
+func (self *treeFSDir) Stat() (fs.FileInfo, error) {
+	return self.info, nil
+}
+
+func (self *treeFSDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: self.info.name, Err: fs.ErrInvalid}
+}
+
+func (self *treeFSDir) Close() error {
+	return nil
+}
+
+func (self *treeFSDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !self.read {
+		entries, err := self.fsys._readDir(self.info.name, self.info)
+		if err != nil {
+			return nil, err
+		}
+		self.entries = entries
+		self.read = true
+	}
+
+	remaining := self.entries[self.offset:]
+	if n <= 0 {
+		self.offset = len(self.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	self.offset += n
+	return remaining[:n], nil
+}
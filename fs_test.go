@@ -0,0 +1,76 @@
+package gitobjects
+
+import (
+	. "gopkg.in/check.v1"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing/fstest"
+)
+
+func (s *MySuite) TestCommitFS(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+
+	// Add an executable and a symlink
+	err := ioutil.WriteFile(filepath.Join(repoDir, "run.sh"), []byte("#!/bin/sh\n"), 0755)
+	c.Assert(err, IsNil)
+	err = os.Symlink("README", filepath.Join(repoDir, "LINK"))
+	c.Assert(err, IsNil)
+	cmd := repo.Command([]string{"add", "run.sh", "LINK"})
+	cmd.Dir = repoDir
+	c.Assert(cmd.Run(), IsNil)
+	cmd = repo.Command([]string{"commit", "-m", "Add run.sh and LINK"})
+	cmd.Dir = repoDir
+	c.Assert(cmd.Run(), IsNil)
+
+	output, err := repo.CmdOutput([]string{"rev-parse", "HEAD"})
+	c.Assert(err, IsNil)
+	commit, err := repo.GetCommit(strings.TrimRight(string(output), "\n"))
+	c.Assert(err, IsNil)
+	committer, err := commit.Committer()
+	c.Assert(err, IsNil)
+
+	fsys, err := commit.FS(repo)
+	c.Assert(err, IsNil)
+
+	err = fstest.TestFS(fsys, "README", "LINK", "run.sh", "src/main.go", "docs/api/index.md")
+	c.Check(err, IsNil)
+
+	contents, err := fs.ReadFile(fsys, "docs/api/index.md")
+	c.Assert(err, IsNil)
+	c.Check(string(contents), Equals, "docs/api/index.md\n")
+
+	info, err := fs.Stat(fsys, "run.sh")
+	c.Assert(err, IsNil)
+	c.Check(info.Mode(), Equals, fs.FileMode(0755))
+	c.Check(info.Size(), Equals, int64(10))
+	c.Check(info.ModTime().Equal(committer.When), Equals, true)
+
+	info, err = fs.Stat(fsys, "LINK")
+	c.Assert(err, IsNil)
+	c.Check(info.Mode()&fs.ModeSymlink, Equals, fs.ModeSymlink)
+
+	info, err = fs.Stat(fsys, "docs")
+	c.Assert(err, IsNil)
+	c.Check(info.IsDir(), Equals, true)
+
+	_, err = fsys.Open("docs/missing")
+	c.Check(err, NotNil)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	var walked []string
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			walked = append(walked, path)
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(walked, DeepEquals, []string{"LINK", "README", "docs/api/index.md", "run.sh", "src/main.go"})
+}
@@ -0,0 +1,463 @@
+package gitobjects
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Something wrong, or suspicious, that Fsck found
+type FsckFinding struct {
+	// "error" or "warning"
+	Severity string
+
+	// A short name for the check which failed, such as "hash-mismatch"
+	Check string
+
+	// The object with the problem; "" for pack and index files
+	Sha1 string
+
+	// The pack or index file with the problem; "" for objects
+	File string
+
+	Message string
+}
+
+func (self *FsckFinding) String() string {
+	subject := self.Sha1
+	if subject == "" {
+		subject = self.File
+	}
+	return fmt.Sprintf("%s %s %s: %s", self.Severity, self.Check, subject, self.Message)
+}
+
+const (
+	fsckError   = "error"
+	fsckWarning = "warning"
+)
+
+var hexSha1Regex = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Check the integrity of every object in the object database, and of every pack
+// and pack index. Each object is rehashed and compared with its name; trees are
+// checked for entry ordering, valid modes and duplicate names; commits and tags
+// for well-formed headers; and every object that a commit, tree or tag refers
+// to must exist. Problems are returned as findings; the error is only for
+// problems which stopped the check from running.
+func (self *Repo) Fsck(ctx context.Context) ([]*FsckFinding, error) {
+	findings, err := self._fsckPacks()
+	if err != nil {
+		return nil, err
+	}
+
+	// Learn the type of every object, so that references can be checked
+	options := DefaultStreamOptions()
+	options.InstantiateObjects = false
+	objectTypes := make(map[string]string)
+	objectChan, errorChan := self.StreamObjectsOfTypes(ctx, nil, options)
+	for obj := range objectChan {
+		objectTypes[obj.Sha1()] = obj.Type()
+	}
+	err = <-errorChan
+	if err != nil {
+		return nil, err
+	}
+
+	sha1s := make([]string, 0, len(objectTypes))
+	for sha1 := range objectTypes {
+		sha1s = append(sha1s, sha1)
+	}
+	sort.Strings(sha1s)
+
+	checker := &fsckChecker{
+		objectTypes: objectTypes,
+		corrupt:     make(map[string]bool),
+	}
+
+	// git cannot say what type a damaged loose object is, so the stream
+	// leaves it out; report it under its own name
+	looseSha1s, err := self._looseObjectSha1s(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, sha1 := range looseSha1s {
+		if _, has := objectTypes[sha1]; !has {
+			checker.corrupt[sha1] = true
+			checker.add(fsckError, "corrupt-object", sha1, "Loose object cannot be read: %s",
+				_looseObjectError(filepath.Join(self.gitDir, "objects", sha1[:2], sha1[2:])))
+		}
+	}
+
+	batch, err := newCatFileBatch(self)
+	if err != nil {
+		return nil, err
+	}
+	defer batch.Close()
+
+	for _, sha1 := range sha1s {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		type_, contents, err := batch.Read(sha1)
+		if err != nil {
+			return nil, err
+		}
+		if type_ == "missing" {
+			checker.add(fsckError, "unreadable-object", sha1, "Object was listed but cannot be read")
+			continue
+		}
+		checker.checkObject(sha1, type_, contents)
+	}
+
+	return append(findings, checker.findings...), nil
+}
+
+// Checks objects one at a time, collecting findings
+type fsckChecker struct {
+	// Key = sha1, Value = type, for every object in the database
+	objectTypes map[string]string
+
+	// Loose objects which exist but cannot be read
+	corrupt map[string]bool
+
+	findings []*FsckFinding
+}
+
+func (self *fsckChecker) add(severity string, check string, sha1 string, format string, args ...interface{}) {
+	self.findings = append(self.findings, &FsckFinding{
+		Severity: severity,
+		Check:    check,
+		Sha1:     sha1,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (self *fsckChecker) checkObject(sha1 string, type_ string, contents []byte) {
+	// The name of an object is the hash of its header and contents
+	hasher := sha1Hasher(type_, int64(len(contents)))
+	hasher.Write(contents)
+	actualSha1 := hex.EncodeToString(hasher.Sum(nil))
+	if actualSha1 != sha1 {
+		self.add(fsckError, "hash-mismatch", sha1, "Contents hash to %s", actualSha1)
+	}
+
+	switch type_ {
+	case "tree":
+		self.checkTree(sha1, contents)
+	case "commit":
+		self.checkCommit(sha1, contents)
+	case "tag":
+		self.checkTag(sha1, contents)
+	}
+}
+
+// Check that a referenced object exists, and has the right type
+func (self *fsckChecker) checkReference(sha1 string, referencedSha1 string, expectedType string, what string) {
+	actualType, has := self.objectTypes[referencedSha1]
+	if self.corrupt[referencedSha1] {
+		self.add(fsckError, "broken-link", sha1, "%s %s is corrupt", what, referencedSha1)
+	} else if !has {
+		self.add(fsckError, "missing-object", sha1, "%s %s is missing", what, referencedSha1)
+	} else if expectedType != "" && actualType != expectedType {
+		self.add(fsckError, "wrong-object-type", sha1, "%s %s is a %s, not a %s",
+			what, referencedSha1, actualType, expectedType)
+	}
+}
+
+// The sha1s of the loose object files, sorted
+func (self *Repo) _looseObjectSha1s(ctx context.Context) ([]string, error) {
+	sha1Chan := make(chan string)
+	errorChan := make(chan error, 1)
+	go func() {
+		errorChan <- _findLooseObjectFiles(ctx, self.gitDir, sha1Chan, nil)
+	}()
+	sha1s := make([]string, 0)
+	for sha1 := range sha1Chan {
+		sha1s = append(sha1s, sha1)
+	}
+	err := <-errorChan
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(sha1s)
+	return sha1s, nil
+}
+
+// Say what is wrong with a loose object file that git cannot read
+func _looseObjectError(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return err.Error()
+	}
+	defer file.Close()
+	reader, err := zlib.NewReader(file)
+	if err != nil {
+		return fmt.Sprintf("Cannot inflate: %s", err)
+	}
+	contents, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return fmt.Sprintf("Cannot inflate: %s", err)
+	}
+
+	// "<type> <size>\0<contents>"
+	nul := bytes.IndexByte(contents, 0)
+	if nul < 0 {
+		return "No object header"
+	}
+	fields := strings.Fields(string(contents[:nul]))
+	if len(fields) != 2 {
+		return fmt.Sprintf("Bad object header '%s'", contents[:nul])
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size != int64(len(contents)-nul-1) {
+		return fmt.Sprintf("Header gives size %s, but there are %d bytes", fields[1], len(contents)-nul-1)
+	}
+	return fmt.Sprintf("Object type '%s' is not known to git", fields[0])
+}
+
+// A tree is a list of "<mode> <name>\0<20-byte sha1>" entries, sorted as if
+// the names of subtrees ended in "/".
+func (self *fsckChecker) checkTree(sha1 string, contents []byte) {
+	names := make(map[string]bool)
+	previousSortName := ""
+	for len(contents) > 0 {
+		mode, name, entrySha1, rest, err := _nextRawTreeEntry(contents)
+		if err != nil {
+			self.add(fsckError, "bad-tree", sha1, "%s", err)
+			return
+		}
+		contents = rest
+
+		expectedType := ""
+		switch mode {
+		case "100644", "100755", "120000":
+			expectedType = "blob"
+		case "40000":
+			expectedType = "tree"
+		case "160000":
+			// A submodule commit, which lives in another repository
+		case "040000":
+			expectedType = "tree"
+			self.add(fsckWarning, "zero-padded-mode", sha1, "Entry '%s' has zero-padded mode %s", name, mode)
+		case "100664":
+			expectedType = "blob"
+			self.add(fsckWarning, "bad-mode", sha1, "Entry '%s' has old-style mode %s", name, mode)
+		default:
+			self.add(fsckError, "bad-mode", sha1, "Entry '%s' has invalid mode %s", name, mode)
+		}
+
+		switch {
+		case name == "":
+			self.add(fsckError, "bad-name", sha1, "Entry with an empty name")
+		case name == "." || name == "..":
+			self.add(fsckError, "bad-name", sha1, "Entry named '%s'", name)
+		case strings.Contains(name, "/"):
+			self.add(fsckError, "bad-name", sha1, "Entry '%s' contains a slash", name)
+		case strings.EqualFold(name, ".git"):
+			self.add(fsckError, "bad-name", sha1, "Entry named '%s'", name)
+		}
+
+		if names[name] {
+			self.add(fsckError, "duplicate-entry", sha1, "Entry '%s' appears more than once", name)
+		}
+		names[name] = true
+
+		sortName := name
+		if expectedType == "tree" {
+			sortName += "/"
+		}
+		if previousSortName != "" && sortName < previousSortName {
+			self.add(fsckError, "tree-not-sorted", sha1, "Entry '%s' is out of order", name)
+		}
+		previousSortName = sortName
+
+		if expectedType != "" {
+			self.checkReference(sha1, entrySha1, expectedType, fmt.Sprintf("Entry '%s'", name))
+		}
+	}
+}
+
+// A commit starts with exactly one tree, any number of parents, exactly one
+// author and exactly one committer, in that order
+func (self *fsckChecker) checkCommit(sha1 string, contents []byte) {
+	header := _objectHeaderLines(contents)
+	index := 0
+
+	if index < len(header) && strings.HasPrefix(header[index], "tree ") {
+		treeSha1 := header[index][len("tree "):]
+		if !hexSha1Regex.MatchString(treeSha1) {
+			self.add(fsckError, "bad-commit-header", sha1, "Invalid tree '%s'", treeSha1)
+		} else {
+			self.checkReference(sha1, treeSha1, "tree", "Tree")
+		}
+		index++
+	} else {
+		self.add(fsckError, "bad-commit-header", sha1, "Missing tree line")
+	}
+
+	for index < len(header) && strings.HasPrefix(header[index], "parent ") {
+		parentSha1 := header[index][len("parent "):]
+		if !hexSha1Regex.MatchString(parentSha1) {
+			self.add(fsckError, "bad-commit-header", sha1, "Invalid parent '%s'", parentSha1)
+		} else {
+			self.checkReference(sha1, parentSha1, "commit", "Parent")
+		}
+		index++
+	}
+
+	for _, keyword := range []string{"author", "committer"} {
+		if index < len(header) && strings.HasPrefix(header[index], keyword+" ") {
+			_, err := ParseSignature(header[index])
+			if err != nil {
+				self.add(fsckError, "bad-commit-header", sha1, "Invalid %s: %s", keyword, err)
+			}
+			index++
+		} else {
+			self.add(fsckError, "bad-commit-header", sha1, "Missing %s line", keyword)
+		}
+	}
+}
+
+// A tag has an object, a type, a tag name and (usually) a tagger, in that order
+func (self *fsckChecker) checkTag(sha1 string, contents []byte) {
+	header := _objectHeaderLines(contents)
+	values := make([]string, 3)
+	for i, keyword := range []string{"object", "type", "tag"} {
+		if i < len(header) && strings.HasPrefix(header[i], keyword+" ") {
+			values[i] = header[i][len(keyword)+1:]
+		} else {
+			self.add(fsckError, "bad-tag-header", sha1, "Missing %s line", keyword)
+		}
+	}
+
+	objectSha1, objectType := values[0], values[1]
+	if objectSha1 != "" {
+		if !hexSha1Regex.MatchString(objectSha1) {
+			self.add(fsckError, "bad-tag-header", sha1, "Invalid object '%s'", objectSha1)
+		} else {
+			self.checkReference(sha1, objectSha1, objectType, "Tagged object")
+		}
+	}
+
+	if len(header) > 3 && strings.HasPrefix(header[3], "tagger ") {
+		_, err := ParseSignature(header[3])
+		if err != nil {
+			self.add(fsckError, "bad-tag-header", sha1, "Invalid tagger: %s", err)
+		}
+	} else {
+		self.add(fsckWarning, "missing-tagger", sha1, "No tagger line")
+	}
+}
+
+// The header lines of a commit or tag, up to the blank line
+func _objectHeaderLines(contents []byte) []string {
+	end := bytes.Index(contents, []byte("\n\n"))
+	if end >= 0 {
+		contents = contents[:end]
+	}
+	lines := strings.Split(string(contents), "\n")
+	header := make([]string, 0, len(lines))
+	for _, line := range lines {
+		// Continuation lines, as in a gpgsig, belong to the line before
+		if strings.HasPrefix(line, " ") {
+			continue
+		}
+		header = append(header, line)
+	}
+	return header
+}
+
+// Check the trailing checksum of every pack and pack index, and that each
+// index was made for its pack
+func (self *Repo) _fsckPacks() ([]*FsckFinding, error) {
+	findings := make([]*FsckFinding, 0)
+	add := func(check string, file string, format string, args ...interface{}) {
+		findings = append(findings, &FsckFinding{
+			Severity: fsckError,
+			Check:    check,
+			File:     file,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	idxFiles, err := filepath.Glob(filepath.Join(self.gitDir, "objects", "pack", "pack-*.idx"))
+	if err != nil {
+		panic(err.Error())
+	}
+	for _, idxFile := range idxFiles {
+		packFile := strings.TrimSuffix(idxFile, ".idx") + ".pack"
+
+		// The index ends with the pack's checksum, then its own
+		idxTrailer, idxOK, err := _verifyFileChecksum(idxFile, 40)
+		if err != nil {
+			return nil, err
+		}
+		if !idxOK {
+			add("idx-checksum", idxFile, "Checksum does not match contents")
+		}
+
+		packTrailer, packOK, err := _verifyFileChecksum(packFile, 20)
+		if os.IsNotExist(errors.Cause(err)) {
+			add("missing-pack", packFile, "Pack file for %s is missing", filepath.Base(idxFile))
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !packOK {
+			add("pack-checksum", packFile, "Checksum does not match contents")
+		}
+		if idxTrailer != nil && packTrailer != nil && !bytes.Equal(idxTrailer[:20], packTrailer) {
+			add("idx-pack-mismatch", idxFile, "Index was not made for %s", filepath.Base(packFile))
+		}
+	}
+	return findings, nil
+}
+
+// Check that the last 20 bytes of a file are the SHA-1 of everything before
+// them. Returns the last trailerSize bytes of the file, or nil if the file
+// is too short to have them.
+func _verifyFileChecksum(filename string, trailerSize int64) ([]byte, bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "Opening %s", filename)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "Getting size of %s", filename)
+	}
+	if info.Size() < trailerSize {
+		return nil, false, nil
+	}
+
+	hasher := sha1.New()
+	_, err = io.CopyN(hasher, file, info.Size()-20)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "Reading %s", filename)
+	}
+	checksum := make([]byte, 20)
+	_, err = io.ReadFull(file, checksum)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "Reading checksum of %s", filename)
+	}
+
+	trailer := make([]byte, trailerSize)
+	_, err = file.ReadAt(trailer, info.Size()-trailerSize)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "Reading trailer of %s", filename)
+	}
+	return trailer, bytes.Equal(hasher.Sum(nil), checksum), nil
+}
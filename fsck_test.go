@@ -0,0 +1,158 @@
+package gitobjects
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/hex"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Write an object with no checks at all, returning its sha1
+func hashObjectLiterally(c *C, repo *Repo, type_ string, contents []byte) string {
+	cmd := repo.Command([]string{"hash-object", "-t", type_, "--literally", "-w", "--stdin"})
+	cmd.Stdin = bytes.NewReader(contents)
+	output, err := cmd.Output()
+	c.Assert(err, IsNil)
+	return strings.TrimRight(string(output), "\n")
+}
+
+// The raw form of one tree entry
+func rawTreeEntry(c *C, mode string, name string, sha1 string) []byte {
+	binarySha1, err := hex.DecodeString(sha1)
+	c.Assert(err, IsNil)
+	entry := []byte(mode + " " + name + "\x00")
+	return append(entry, binarySha1...)
+}
+
+func runFsck(c *C, repo *Repo) map[string][]*FsckFinding {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	defer cancelFunc()
+	findings, err := repo.Fsck(ctx)
+	c.Assert(err, IsNil)
+
+	byCheck := make(map[string][]*FsckFinding)
+	for _, finding := range findings {
+		byCheck[finding.Check] = append(byCheck[finding.Check], finding)
+	}
+	return byCheck
+}
+
+func (s *MySuite) TestFsckCleanRepo(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	modifyReadmeAndPack(c, repo, repoDir)
+	addNotes(c, repo, repoDir)
+
+	cmd := repo.Command([]string{"tag", "-a", "-m", "Release", "v1.0"})
+	cmd.Dir = repoDir
+	c.Assert(cmd.Run(), IsNil)
+
+	c.Check(runFsck(c, repo), DeepEquals, map[string][]*FsckFinding{})
+}
+
+func (s *MySuite) TestFsckBadObjects(c *C) {
+	repo, _ := s.setupRepoWithReadme(c)
+
+	readmeSha1, err := repo.ResolveRevision("HEAD:README")
+	c.Assert(err, IsNil)
+	missingSha1 := "1234567890123456789012345678901234567890"
+
+	// Out of order, a duplicate, a bad mode, a missing blob, and a blob
+	// where a tree should be
+	var treeContents []byte
+	treeContents = append(treeContents, rawTreeEntry(c, "100644", "b", readmeSha1)...)
+	treeContents = append(treeContents, rawTreeEntry(c, "100644", "a", readmeSha1)...)
+	treeContents = append(treeContents, rawTreeEntry(c, "100644", "a", readmeSha1)...)
+	treeContents = append(treeContents, rawTreeEntry(c, "100600", "c", readmeSha1)...)
+	treeContents = append(treeContents, rawTreeEntry(c, "100644", "d", missingSha1)...)
+	treeContents = append(treeContents, rawTreeEntry(c, "40000", "e", readmeSha1)...)
+	treeSha1 := hashObjectLiterally(c, repo, "tree", treeContents)
+
+	commitSha1 := hashObjectLiterally(c, repo, "commit",
+		[]byte("tree "+treeSha1+"\nparent "+missingSha1+"\nauthor nobody\n\nBad commit\n"))
+
+	// A loose object whose contents do not match its name
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	_, err = writer.Write([]byte("blob 4\x00abc\n"))
+	c.Assert(err, IsNil)
+	c.Assert(writer.Close(), IsNil)
+	wrongSha1 := "ffffffffffffffffffffffffffffffffffffffff"
+	looseDir := filepath.Join(repo.GitDir(), "objects", "ff")
+	c.Assert(os.MkdirAll(looseDir, 0777), IsNil)
+	err = ioutil.WriteFile(filepath.Join(looseDir, wrongSha1[2:]), compressed.Bytes(), 0444)
+	c.Assert(err, IsNil)
+
+	findings := runFsck(c, repo)
+
+	c.Assert(len(findings["hash-mismatch"]), Equals, 1)
+	c.Check(findings["hash-mismatch"][0].Sha1, Equals, wrongSha1)
+
+	c.Assert(len(findings["tree-not-sorted"]), Equals, 1)
+	c.Check(findings["tree-not-sorted"][0].Sha1, Equals, treeSha1)
+	c.Assert(len(findings["duplicate-entry"]), Equals, 1)
+	c.Check(findings["duplicate-entry"][0].Message, Equals, "Entry 'a' appears more than once")
+	c.Assert(len(findings["bad-mode"]), Equals, 1)
+	c.Check(findings["bad-mode"][0].Severity, Equals, "error")
+	c.Assert(len(findings["wrong-object-type"]), Equals, 1)
+	c.Check(findings["wrong-object-type"][0].Message, Matches, "Entry 'e' .* is a blob, not a tree")
+
+	// The tree's missing blob, and the commit's missing parent
+	c.Assert(len(findings["missing-object"]), Equals, 2)
+
+	c.Assert(len(findings["bad-commit-header"]), Equals, 2)
+	for _, finding := range findings["bad-commit-header"] {
+		c.Check(finding.Sha1, Equals, commitSha1)
+	}
+	c.Check(findings["bad-commit-header"][0].Message, Matches, "Invalid author: .*")
+	c.Check(findings["bad-commit-header"][1].Message, Equals, "Missing committer line")
+}
+
+func (s *MySuite) TestFsckBadPack(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	modifyReadmeAndPack(c, repo, repoDir)
+
+	packFiles, err := filepath.Glob(filepath.Join(repo.GitDir(), "objects", "pack", "pack-*.pack"))
+	c.Assert(err, IsNil)
+	c.Assert(len(packFiles), Equals, 1)
+
+	// Damage the pack's own checksum
+	contents, err := ioutil.ReadFile(packFiles[0])
+	c.Assert(err, IsNil)
+	contents[len(contents)-1] ^= 0xff
+	c.Assert(os.Chmod(packFiles[0], 0666), IsNil)
+	c.Assert(ioutil.WriteFile(packFiles[0], contents, 0666), IsNil)
+
+	findings := runFsck(c, repo)
+	c.Assert(len(findings["pack-checksum"]), Equals, 1)
+	c.Check(findings["pack-checksum"][0].File, Equals, packFiles[0])
+	c.Check(len(findings["idx-pack-mismatch"]), Equals, 1)
+	c.Check(len(findings["idx-checksum"]), Equals, 0)
+}
+
+func (s *MySuite) TestFsckCorruptLooseObject(c *C) {
+	repo, _ := s.setupRepoWithReadme(c)
+	readmeSha1, err := repo.ResolveRevision("HEAD:README")
+	c.Assert(err, IsNil)
+	treeSha1, err := repo.ResolveRevision("HEAD^{tree}")
+	c.Assert(err, IsNil)
+
+	// A loose object which does not inflate
+	path := filepath.Join(repo.GitDir(), "objects", readmeSha1[:2], readmeSha1[2:])
+	c.Assert(os.Chmod(path, 0644), IsNil)
+	c.Assert(ioutil.WriteFile(path, []byte("garbage\n"), 0644), IsNil)
+
+	findings := runFsck(c, repo)
+	c.Assert(len(findings["corrupt-object"]), Equals, 1)
+	c.Check(findings["corrupt-object"][0].Sha1, Equals, readmeSha1)
+	c.Check(findings["corrupt-object"][0].Message, Matches, "Loose object cannot be read: Cannot inflate: .*")
+	c.Assert(len(findings["broken-link"]), Equals, 1)
+	c.Check(findings["broken-link"][0].Sha1, Equals, treeSha1)
+	c.Check(findings["broken-link"][0].Message, Equals, "Entry 'README' "+readmeSha1+" is corrupt")
+	c.Check(findings["missing-object"], HasLen, 0)
+}
@@ -0,0 +1,21 @@
+package gitobjects
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"hash"
+)
+
+// A SHA-1 hasher primed with the "<type> <size>\0" header that git puts in
+// front of an object's contents. Write the contents to it, and its sum is
+// the object's name.
+func sha1Hasher(objectType string, size int64) hash.Hash {
+	hasher := sha1.New()
+	hasher.Write(sha1HeaderBytes(objectType, size))
+	return hasher
+}
+
+// The "<type> <size>\0" header of an object
+func sha1HeaderBytes(objectType string, size int64) []byte {
+	return []byte(fmt.Sprintf("%s %d\x00", objectType, size))
+}
@@ -0,0 +1,345 @@
+package gitobjects
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// A pattern from a .gitignore or .gitattributes line
+type pathPattern struct {
+	// The glob, without a leading "!" or trailing "/"
+	pattern string
+
+	// The directory of the file it came from, ending in "/", or "" at the top
+	base string
+
+	// .gitignore lines starting with "!" re-include what an earlier line
+	// excluded
+	negative bool
+
+	// Patterns ending in "/" only match directories
+	mustBeDir bool
+
+	// Patterns without a slash match the name at any depth below base;
+	// others are matched against the whole path relative to base
+	noDir bool
+}
+
+// Parse a pattern which is relative to base. Returns nil if nothing is left
+// of it.
+func _parsePathPattern(line string, base string) *pathPattern {
+	pattern := &pathPattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		pattern.negative = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		pattern.mustBeDir = true
+		line = line[:len(line)-1]
+	}
+	if line == "" {
+		return nil
+	}
+	pattern.noDir = !strings.Contains(line, "/")
+	pattern.pattern = strings.TrimPrefix(line, "/")
+	return pattern
+}
+
+// Does the pattern match a slash-separated path, relative to the top?
+func (self *pathPattern) Matches(path string, isDir bool, caseFold bool) bool {
+	flags := 0
+	if caseFold {
+		flags = wildmatchCaseFold
+	}
+	if self.mustBeDir && !isDir {
+		return false
+	}
+	if self.noDir {
+		return wildmatch(self.pattern, path[strings.LastIndexByte(path, '/')+1:], flags)
+	}
+	if len(path) <= len(self.base) {
+		return false
+	}
+	base := path[:len(self.base)]
+	if base != self.base && !(caseFold && strings.EqualFold(base, self.base)) {
+		return false
+	}
+	return wildmatch(self.pattern, path[len(self.base):], flags|wildmatchPathname)
+}
+
+// Decides which paths are ignored, the way git does. Patterns come from
+// a .gitignore in each directory, which are read as they are needed,
+// then from info/exclude and core.excludesFile. A deeper .gitignore wins
+// over a shallower one, and a later line over an earlier one. Nothing in
+// an ignored directory can be re-included. It is safe for concurrent use.
+type IgnoreMatcher struct {
+	sync.Mutex
+
+	// Returns the contents of the .gitignore in a directory, or nil
+	readIgnoreFile func(dir string) ([]byte, error)
+
+	// info/exclude, then core.excludesFile
+	globalPatterns [][]*pathPattern
+
+	// core.ignoreCase
+	caseFold bool
+
+	// Parsed .gitignore files, keyed by directory
+	dirPatterns map[string][]*pathPattern
+
+	// Whether each directory seen so far is ignored
+	dirsIgnored map[string]bool
+}
+
+// Return an IgnoreMatcher which reads the .gitignore files in the work tree
+func (self *Repo) NewIgnoreMatcher() (*IgnoreMatcher, error) {
+	if self.workTree == "" {
+		return nil, errors.Errorf("Repository %s has no work tree", self.gitDir)
+	}
+	return self._newIgnoreMatcher(func(dir string) ([]byte, error) {
+		ignorePath := filepath.Join(self.workTree, filepath.FromSlash(dir), ".gitignore")
+		info, err := os.Lstat(ignorePath)
+		if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+			return nil, nil
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "Reading %s", ignorePath)
+		}
+		// git does not follow a symlinked .gitignore
+		if !info.Mode().IsRegular() {
+			return nil, nil
+		}
+		contents, err := ioutil.ReadFile(ignorePath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Reading %s", ignorePath)
+		}
+		return contents, nil
+	})
+}
+
+// Return an IgnoreMatcher which reads the .gitignore blobs in a Tree, such
+// as the root tree of a commit, instead of the work tree
+func (self *Repo) NewTreeIgnoreMatcher(tree *Tree) (*IgnoreMatcher, error) {
+	return self._newIgnoreMatcher(self._treeFileReader(tree, ".gitignore"))
+}
+
+// Return a function which reads the file with a name, such as
+// ".gitignore", in a directory of a Tree. It returns nil if there is no
+// such file, or if it is a symlink.
+func (self *Repo) _treeFileReader(tree *Tree, name string) func(dir string) ([]byte, error) {
+	return func(dir string) ([]byte, error) {
+		filePath := path.Join(dir, name)
+		entry, err := tree.Lookup(self, filePath)
+		if IsPathNotFound(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if entry.Type() != "blob" || entry.Permissions() == "120000" {
+			return nil, nil
+		}
+		contents, err := entry.Blob().Contents(self)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Reading %s in tree %s", filePath, tree.Sha1())
+		}
+		return contents, nil
+	}
+}
+
+func (self *Repo) _newIgnoreMatcher(readIgnoreFile func(dir string) ([]byte, error)) (*IgnoreMatcher, error) {
+	matcher := &IgnoreMatcher{
+		readIgnoreFile: readIgnoreFile,
+		dirPatterns:    make(map[string][]*pathPattern),
+		dirsIgnored:    make(map[string]bool),
+	}
+
+	config, err := self.Config()
+	if err != nil {
+		return nil, err
+	}
+	matcher.caseFold, err = config.GetBool("core.ignoreCase", false)
+	if err != nil {
+		return nil, err
+	}
+	excludesFile, err := config.GetPath("core.excludesFile", _xdgConfigPath("ignore"))
+	if err != nil {
+		return nil, err
+	}
+	// info/ is shared by every work tree, as git shares it
+	commonDir, err := self._commonDir()
+	if err != nil {
+		return nil, err
+	}
+	for _, patternsPath := range []string{filepath.Join(commonDir, "info", "exclude"), excludesFile} {
+		if patternsPath == "" {
+			continue
+		}
+		contents, err := _readOptionalFile(patternsPath)
+		if err != nil {
+			return nil, err
+		}
+		matcher.globalPatterns = append(matcher.globalPatterns, _parseIgnorePatterns(contents, ""))
+	}
+	return matcher, nil
+}
+
+// Where git looks for a file such as "ignore" under $XDG_CONFIG_HOME/git,
+// or ~/.config/git, when its config variable is not set
+func _xdgConfigPath(name string) string {
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		return filepath.Join(configHome, "git", name)
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config", "git", name)
+	}
+	return ""
+}
+
+// Is the slash-separated path, relative to the top of the work tree or
+// Tree, ignored? Directory-only patterns need isDir to be set.
+func (self *IgnoreMatcher) IsIgnored(path string, isDir bool) (bool, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return false, nil
+	}
+
+	self.Lock()
+	defer self.Unlock()
+	parent := ""
+	if i := strings.LastIndexByte(path, '/'); i >= 0 {
+		parent = path[:i]
+		ignored, err := self._isDirIgnored(parent)
+		if err != nil || ignored {
+			return ignored, err
+		}
+	}
+	return self._match(parent, path, isDir)
+}
+
+func (self *IgnoreMatcher) _isDirIgnored(dir string) (bool, error) {
+	if ignored, ok := self.dirsIgnored[dir]; ok {
+		return ignored, nil
+	}
+	parent := ""
+	ignored := false
+	var err error
+	if i := strings.LastIndexByte(dir, '/'); i >= 0 {
+		parent = dir[:i]
+		ignored, err = self._isDirIgnored(parent)
+		if err != nil {
+			return false, err
+		}
+	}
+	if !ignored {
+		ignored, err = self._match(parent, dir, true)
+		if err != nil {
+			return false, err
+		}
+	}
+	self.dirsIgnored[dir] = ignored
+	return ignored, nil
+}
+
+// Check a path against the .gitignore files from its parent directory
+// up to the top, then the global patterns. The first of those with a
+// matching line decides.
+func (self *IgnoreMatcher) _match(parent string, path string, isDir bool) (bool, error) {
+	dir := parent
+	for {
+		patterns, err := self._patternsForDir(dir)
+		if err != nil {
+			return false, err
+		}
+		if pattern := self._lastMatchingPattern(patterns, path, isDir); pattern != nil {
+			return !pattern.negative, nil
+		}
+		if dir == "" {
+			break
+		}
+		if i := strings.LastIndexByte(dir, '/'); i >= 0 {
+			dir = dir[:i]
+		} else {
+			dir = ""
+		}
+	}
+	for _, patterns := range self.globalPatterns {
+		if pattern := self._lastMatchingPattern(patterns, path, isDir); pattern != nil {
+			return !pattern.negative, nil
+		}
+	}
+	return false, nil
+}
+
+func (self *IgnoreMatcher) _patternsForDir(dir string) ([]*pathPattern, error) {
+	if patterns, ok := self.dirPatterns[dir]; ok {
+		return patterns, nil
+	}
+	contents, err := self.readIgnoreFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	base := ""
+	if dir != "" {
+		base = dir + "/"
+	}
+	patterns := _parseIgnorePatterns(contents, base)
+	self.dirPatterns[dir] = patterns
+	return patterns, nil
+}
+
+func (self *IgnoreMatcher) _lastMatchingPattern(patterns []*pathPattern, path string, isDir bool) *pathPattern {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if patterns[i].Matches(path, isDir, self.caseFold) {
+			return patterns[i]
+		}
+	}
+	return nil
+}
+
+// Parse the lines of an ignore file whose patterns are relative to base
+func _parseIgnorePatterns(contents []byte, base string) []*pathPattern {
+	contents = bytes.TrimPrefix(contents, []byte("\xef\xbb\xbf"))
+	patterns := make([]*pathPattern, 0)
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		pattern := _parsePathPattern(_trimIgnoreTrailingSpaces(line), base)
+		if pattern != nil {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// Remove trailing spaces, unless they are escaped with a backslash
+func _trimIgnoreTrailingSpaces(line string) string {
+	lastSpace := -1
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			if lastSpace < 0 {
+				lastSpace = i
+			}
+		case '\\':
+			i++
+			if i == len(line) {
+				return line
+			}
+			lastSpace = -1
+		default:
+			lastSpace = -1
+		}
+	}
+	if lastSpace >= 0 {
+		return line[:lastSpace]
+	}
+	return line
+}
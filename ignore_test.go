@@ -0,0 +1,146 @@
+package gitobjects
+
+import (
+	. "gopkg.in/check.v1"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Check that the IgnoreMatcher agrees with "git check-ignore" about each
+// path. Directories end in a slash.
+func checkIgnoredMatchesGit(c *C, repo *Repo, repoDir string, matcher *IgnoreMatcher, paths []string) {
+	cmd := repo.Command(append([]string{"check-ignore", "--no-index", "--verbose", "--non-matching", "--"}, paths...))
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	// It exits with 1 if nothing was ignored
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		c.Assert(err, IsNil)
+	}
+
+	// "<source>:<line number>:<pattern>\t<path>"
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	c.Assert(len(lines), Equals, len(paths))
+	for i, line := range lines {
+		fields := strings.SplitN(line, "\t", 2)
+		pattern := fields[0][strings.LastIndex(fields[0], ":")+1:]
+		expected := pattern != "" && !strings.HasPrefix(pattern, "!")
+
+		ignored, err := matcher.IsIgnored(paths[i], strings.HasSuffix(paths[i], "/"))
+		c.Assert(err, IsNil)
+		c.Check(ignored, Equals, expected, Commentf("%s: git says %s", paths[i], line))
+	}
+}
+
+var ignoreTestFiles = map[string]string{
+	".gitignore": strings.Join([]string{
+		"# comment",
+		"*.log",
+		"!keep.log",
+		"build/",
+		"/top-only",
+		"docs/**/generated",
+		"**/cache",
+		"trailing-space\\ ",
+		"spaces   ",
+		"\\#hash",
+		"\\!bang",
+		"out*/",
+		"!out-keep/",
+		"vendor/",
+		"!vendor/keep.txt",
+		"[Tt]emp?",
+		"",
+	}, "\n"),
+	"src/.gitignore":      "!debug.log\n/local\nnested/*.tmp\n",
+	"src/deep/.gitignore": "*.log\r\n!*.go\n",
+}
+
+var ignoreTestPaths = []string{
+	"a.log", "keep.log", "src/debug.log", "src/deep/debug.log", "src/deep/x/keep.log",
+	"build/", "build", "src/build/", "build/file",
+	"top-only", "src/top-only",
+	"docs/generated", "docs/a/b/generated", "src/docs/generated",
+	"cache", "src/cache", "src/cache/file",
+	"trailing-space ", "trailing-space", "spaces", "spaces   ",
+	"#hash", "!bang",
+	"output/", "output", "out-keep/", "out-keep/file",
+	"vendor/keep.txt", "vendor/other",
+	"Temp1", "temp2", "tempxy",
+	"src/local", "local", "src/sub/local",
+	"src/nested/a.tmp", "src/nested/sub/a.tmp",
+	"src/deep/main.go", "src/deep/x/y.go",
+	"excluded-info", "src/excluded-info", "excluded-global", "src/excluded-global/",
+}
+
+func (s *MySuite) TestIgnoreMatcher(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	writeFiles(c, repoDir, ignoreTestFiles)
+	writeFiles(c, repo.GitDir(), map[string]string{"info/exclude": "excluded-info\n"})
+	excludesFile := filepath.Join(filepath.Dir(repoDir), "global-ignore")
+	writeFiles(c, filepath.Dir(repoDir), map[string]string{"global-ignore": "excluded-global\n!excluded-info\n"})
+	runGitIn(c, repo, repoDir, "config", "core.excludesFile", excludesFile)
+
+	matcher, err := repo.NewIgnoreMatcher()
+	c.Assert(err, IsNil)
+	checkIgnoredMatchesGit(c, repo, repoDir, matcher, ignoreTestPaths)
+
+	// core.ignoreCase
+	runGitIn(c, repo, repoDir, "config", "core.ignoreCase", "true")
+	matcher, err = repo.NewIgnoreMatcher()
+	c.Assert(err, IsNil)
+	checkIgnoredMatchesGit(c, repo, repoDir, matcher, []string{"A.LOG", "BUILD/", "Src/Local", "src/Local", "TOP-ONLY"})
+}
+
+func (s *MySuite) TestTreeIgnoreMatcher(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	writeFiles(c, repoDir, ignoreTestFiles)
+	runGitIn(c, repo, repoDir, "add", "-f", ".gitignore", "src")
+	runGitIn(c, repo, repoDir, "commit", "-q", "-m", "Add ignore files")
+	sha1, err := repo.ResolveRevision("HEAD^{tree}")
+	c.Assert(err, IsNil)
+	tree, err := repo.GetTree(sha1)
+	c.Assert(err, IsNil)
+
+	// The tree is read, not the work tree
+	c.Assert(os.Remove(filepath.Join(repoDir, ".gitignore")), IsNil)
+	c.Assert(os.Remove(filepath.Join(repoDir, "src", ".gitignore")), IsNil)
+	matcher, err := repo.NewTreeIgnoreMatcher(tree)
+	c.Assert(err, IsNil)
+	for path, expected := range map[string]bool{
+		"a.log":              true,
+		"keep.log":           false,
+		"src/debug.log":      false,
+		"src/deep/debug.log": true,
+		"build/":             true,
+		"build/file":         true,
+		"src/local":          true,
+		"vendor/keep.txt":    true,
+		"README":             false,
+	} {
+		ignored, err := matcher.IsIgnored(strings.TrimSuffix(path, "/"), strings.HasSuffix(path, "/"))
+		c.Assert(err, IsNil)
+		c.Check(ignored, Equals, expected, Commentf(path))
+	}
+}
+
+func (s *MySuite) TestWorktreeIgnoreMatcher(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	writeFiles(c, repo.GitDir(), map[string]string{"info/exclude": "secret\n"})
+	worktreeDir := filepath.Join(c.MkDir(), "worktree")
+	runGitIn(c, repo, repoDir, "worktree", "add", "-q", "-b", "in-worktree", worktreeDir)
+	worktree, err := NewRepo(worktreeDir)
+	c.Assert(err, IsNil)
+	writeFiles(c, worktreeDir, map[string]string{"secret": "secret\n"})
+
+	// info/exclude is shared by every work tree
+	matcher, err := worktree.NewIgnoreMatcher()
+	c.Assert(err, IsNil)
+	ignored, err := matcher.IsIgnored("secret", false)
+	c.Assert(err, IsNil)
+	c.Check(ignored, Equals, true)
+	statuses := checkStatusMatchesGit(c, worktree, worktreeDir)
+	c.Assert(statuses, HasLen, 1)
+	c.Check(statuses[0].String(), Equals, "!! secret")
+}
@@ -0,0 +1,387 @@
+package gitobjects
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// The staging area, as read from the index file. Entries are sorted by path,
+// and then by stage.
+type Index struct {
+	// 2, 3 or 4
+	Version uint32
+
+	Entries []*IndexEntry
+
+	// The TREE extension, or nil if the index has none
+	CacheTree *IndexCacheTree
+
+	// The REUC extension: how to undo the resolution of merge conflicts
+	ResolveUndo []*IndexResolveUndo
+
+	// The UNTR extension, or nil if the index has none
+	UntrackedCache *IndexUntrackedCache
+
+	// The sha1 of the shared index that this split index is based on, from
+	// the link extension; "" if this is not a split index. Entries already
+	// includes the entries of the shared index.
+	SharedIndexSha1 string
+
+	// True if the index has the sdir extension, which means that some
+	// entries may be sparse directories
+	Sparse bool
+
+	// Optional extensions which are not understood, keyed by signature
+	OtherExtensions map[string][]byte
+
+	// The sha1 of the whole index file
+	Checksum string
+
+	// The bitmaps of the link extension, for merging with the shared index
+	splitIndexLink *indexSplitIndexLink
+}
+
+// A file, or a sparse directory, in the staging area
+type IndexEntry struct {
+	// Stat data of the file in the work tree, as it was when the entry
+	// was last updated. They are used to see if the file has changed.
+	CTime time.Time
+	MTime time.Time
+	Dev   uint32
+	Ino   uint32
+	UID   uint32
+	GID   uint32
+
+	// The file size, truncated to 32 bits
+	Size uint32
+
+	// Such as 0100644, 0100755, 0120000 or 0160000
+	Mode uint32
+
+	Sha1 string
+
+	// 0 normally; 1, 2 and 3 for the base, ours and theirs
+	// versions of a conflicted path
+	Stage int
+
+	AssumeValid  bool
+	SkipWorktree bool
+	IntentToAdd  bool
+
+	// Slash-separated, relative to the top of the work tree. Sparse
+	// directories end in a slash.
+	Path string
+}
+
+// The mode as "git ls-files --stage" shows it, such as "100644"
+func (self *IndexEntry) Permissions() string {
+	return fmt.Sprintf("%06o", self.Mode)
+}
+
+// Is this a directory that a sparse index has collapsed into one entry?
+// Its Sha1 is that of a tree.
+func (self *IndexEntry) IsSparseDir() bool {
+	return self.Mode == 040000 && len(self.Path) > 0 && self.Path[len(self.Path)-1] == '/'
+}
+
+// The index entry flags
+const (
+	indexFlagAssumeValid  = 0x8000
+	indexFlagExtended     = 0x4000
+	indexFlagStageMask    = 0x3000
+	indexFlagStageShift   = 12
+	indexFlagNameMask     = 0x0fff
+	indexFlagSkipWorktree = 0x4000
+	indexFlagIntentToAdd  = 0x2000
+)
+
+// The size of an entry before its path, without and with extended flags
+const (
+	indexEntryFixedSize         = 62
+	indexEntryExtendedFixedSize = 64
+)
+
+// Read the index of a repository. A repository without an index, such as a
+// new or bare one, has an empty index. A split index is merged with its
+// shared index, so Entries has every entry.
+func (self *Repo) Index() (*Index, error) {
+	indexFile := filepath.Join(self.gitDir, "index")
+	index, err := _readIndexFile(indexFile)
+	if os.IsNotExist(errors.Cause(err)) {
+		return &Index{
+			Version:         2,
+			Entries:         []*IndexEntry{},
+			OtherExtensions: make(map[string][]byte),
+		}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if index.SharedIndexSha1 != "" {
+		sharedIndexFile := filepath.Join(self.gitDir, "sharedindex."+index.SharedIndexSha1)
+		sharedIndex, err := _readIndexFile(sharedIndexFile)
+		if err != nil {
+			return nil, err
+		}
+		err = index._mergeSharedIndex(sharedIndex)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Merging %s with %s", indexFile, sharedIndexFile)
+		}
+	}
+	return index, nil
+}
+
+// Find the entry for a path at a stage, or nil if there is none
+func (self *Index) Entry(path string, stage int) *IndexEntry {
+	i := self._search(path, stage)
+	if i < len(self.Entries) && self.Entries[i].Path == path && self.Entries[i].Stage == stage {
+		return self.Entries[i]
+	}
+	return nil
+}
+
+// The position at which an entry for the path and stage is, or would be
+func (self *Index) _search(path string, stage int) int {
+	return sort.Search(len(self.Entries), func(i int) bool {
+		entry := self.Entries[i]
+		if entry.Path != path {
+			return entry.Path > path
+		}
+		return entry.Stage >= stage
+	})
+}
+
+func _readIndexFile(indexFile string) (*Index, error) {
+	data, err := ioutil.ReadFile(indexFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Reading %s", indexFile)
+	}
+	index, err := _parseIndex(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Parsing %s", indexFile)
+	}
+	return index, nil
+}
+
+func _parseIndex(data []byte) (*Index, error) {
+	if len(data) < 12+sha1.Size {
+		return nil, errors.New("Index file is too short")
+	}
+	contents := data[:len(data)-sha1.Size]
+	checksum := data[len(data)-sha1.Size:]
+
+	// With index.skipHash, the checksum is left as all zeroes
+	if !bytes.Equal(checksum, make([]byte, sha1.Size)) {
+		actual := sha1.Sum(contents)
+		if !bytes.Equal(checksum, actual[:]) {
+			return nil, errors.Errorf("Index checksum is %x, but the contents hash to %x", checksum, actual)
+		}
+	}
+
+	if string(contents[0:4]) != "DIRC" {
+		return nil, errors.Errorf("Index has bad signature '%s'", contents[0:4])
+	}
+	index := &Index{
+		Version:         binary.BigEndian.Uint32(contents[4:8]),
+		OtherExtensions: make(map[string][]byte),
+		Checksum:        hex.EncodeToString(checksum),
+	}
+	if index.Version < 2 || index.Version > 4 {
+		return nil, errors.Errorf("Index version %d is not supported", index.Version)
+	}
+	entryCount := int(binary.BigEndian.Uint32(contents[8:12]))
+
+	rest := contents[12:]
+	index.Entries = make([]*IndexEntry, 0, entryCount)
+	previousPath := ""
+	for i := 0; i < entryCount; i++ {
+		entry, remaining, err := _parseIndexEntry(rest, index.Version, previousPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Parsing entry %d", i)
+		}
+		index.Entries = append(index.Entries, entry)
+		previousPath = entry.Path
+		rest = remaining
+	}
+
+	for len(rest) > 0 {
+		if len(rest) < 8 {
+			return nil, errors.New("Truncated extension header")
+		}
+		signature := string(rest[0:4])
+		size := binary.BigEndian.Uint32(rest[4:8])
+		if uint64(size) > uint64(len(rest)-8) {
+			return nil, errors.Errorf("Extension %s of %d bytes is truncated", signature, size)
+		}
+		err := index._parseExtension(signature, rest[8:8+size])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Parsing extension %s", signature)
+		}
+		rest = rest[8+size:]
+	}
+	return index, nil
+}
+
+// Parse one entry, returning the bytes after it
+func _parseIndexEntry(data []byte, version uint32, previousPath string) (*IndexEntry, []byte, error) {
+	if len(data) < indexEntryFixedSize {
+		return nil, nil, errors.New("Truncated entry")
+	}
+	uint32At := func(offset int) uint32 {
+		return binary.BigEndian.Uint32(data[offset : offset+4])
+	}
+	entry := &IndexEntry{
+		CTime: time.Unix(int64(uint32At(0)), int64(uint32At(4))),
+		MTime: time.Unix(int64(uint32At(8)), int64(uint32At(12))),
+		Dev:   uint32At(16),
+		Ino:   uint32At(20),
+		Mode:  uint32At(24),
+		UID:   uint32At(28),
+		GID:   uint32At(32),
+		Size:  uint32At(36),
+		Sha1:  hex.EncodeToString(data[40:60]),
+	}
+	flags := binary.BigEndian.Uint16(data[60:62])
+	entry.AssumeValid = flags&indexFlagAssumeValid != 0
+	entry.Stage = int(flags&indexFlagStageMask) >> indexFlagStageShift
+
+	pathStart := indexEntryFixedSize
+	if flags&indexFlagExtended != 0 {
+		if version < 3 {
+			return nil, nil, errors.Errorf("Extended flags in a version %d index", version)
+		}
+		if len(data) < indexEntryExtendedFixedSize {
+			return nil, nil, errors.New("Truncated entry")
+		}
+		extendedFlags := binary.BigEndian.Uint16(data[62:64])
+		entry.SkipWorktree = extendedFlags&indexFlagSkipWorktree != 0
+		entry.IntentToAdd = extendedFlags&indexFlagIntentToAdd != 0
+		pathStart = indexEntryExtendedFixedSize
+	}
+	rest := data[pathStart:]
+
+	// Version 4 paths are the end of the previous path, replaced by a suffix
+	prefix := ""
+	if version >= 4 {
+		var stripLength uint64
+		var err error
+		stripLength, rest, err = _decodeIndexVarint(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		if stripLength > uint64(len(previousPath)) {
+			return nil, nil, errors.Errorf("Entry strips %d bytes from the previous path '%s'",
+				stripLength, previousPath)
+		}
+		prefix = previousPath[:len(previousPath)-int(stripLength)]
+	}
+	nul := bytes.IndexByte(rest, 0)
+	if nul < 0 {
+		return nil, nil, errors.New("Entry path is not terminated")
+	}
+	entry.Path = prefix + string(rest[:nul])
+
+	// Versions 2 and 3 pad each entry with 1 to 8 NULs, to a multiple of 8 bytes
+	if version >= 4 {
+		return entry, rest[nul+1:], nil
+	}
+	entrySize := (pathStart + nul + 8) &^ 7
+	if entrySize > len(data) {
+		return nil, nil, errors.New("Truncated entry padding")
+	}
+	return entry, data[entrySize:], nil
+}
+
+// Decode git's variable-length integer, in which each byte holds 7 bits, and
+// every byte but the last adds 1 before the next 7 bits are shifted in, so
+// that each number has only one encoding.
+func _decodeIndexVarint(data []byte) (uint64, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, errors.New("Truncated variable-length integer")
+	}
+	c := data[0]
+	value := uint64(c & 0x7f)
+	i := 1
+	for c&0x80 != 0 {
+		if i >= len(data) {
+			return 0, nil, errors.New("Truncated variable-length integer")
+		}
+		c = data[i]
+		value = ((value + 1) << 7) | uint64(c&0x7f)
+		i++
+	}
+	return value, data[i:], nil
+}
+
+// Build the full list of entries of a split index, from the entries in the
+// shared index and this one. The first entries of a split index replace the
+// shared entries marked in the replace bitmap, keeping their paths; the rest
+// are added, or replace entries with the same path and stage.
+func (self *Index) _mergeSharedIndex(shared *Index) error {
+	link := self.splitIndexLink
+	if link == nil {
+		return errors.New("Split index has no link extension")
+	}
+	replaceBits, err := link.replaceBitmap.Bits()
+	if err != nil {
+		return errors.Wrap(err, "Reading replace bitmap")
+	}
+	deleteBits, err := link.deleteBitmap.Bits()
+	if err != nil {
+		return errors.Wrap(err, "Reading delete bitmap")
+	}
+	if len(replaceBits) > len(self.Entries) {
+		return errors.Errorf("%d shared entries are replaced, but there are only %d entries",
+			len(replaceBits), len(self.Entries))
+	}
+
+	merged := make([]*IndexEntry, len(shared.Entries))
+	copy(merged, shared.Entries)
+	for i, position := range replaceBits {
+		if position >= len(merged) {
+			return errors.Errorf("Replaced entry %d is past the end of the shared index", position)
+		}
+		replacement := *self.Entries[i]
+		replacement.Path = merged[position].Path
+		merged[position] = &replacement
+	}
+	for _, position := range deleteBits {
+		if position >= len(merged) {
+			return errors.Errorf("Deleted entry %d is past the end of the shared index", position)
+		}
+		merged[position] = nil
+	}
+
+	entries := make([]*IndexEntry, 0, len(merged)+len(self.Entries)-len(replaceBits))
+	for _, entry := range merged {
+		if entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	merging := &Index{Entries: entries}
+	for _, entry := range self.Entries[len(replaceBits):] {
+		if entry.Path == "" {
+			return errors.New("Split index entry after the replacements has an empty path")
+		}
+		i := merging._search(entry.Path, entry.Stage)
+		if i < len(merging.Entries) && merging.Entries[i].Path == entry.Path &&
+			merging.Entries[i].Stage == entry.Stage {
+			merging.Entries[i] = entry
+		} else {
+			merging.Entries = append(merging.Entries, nil)
+			copy(merging.Entries[i+1:], merging.Entries[i:])
+			merging.Entries[i] = entry
+		}
+	}
+	self.Entries = merging.Entries
+	return nil
+}
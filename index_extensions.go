@@ -0,0 +1,380 @@
+package gitobjects
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"strconv"
+)
+
+// A node of the cache tree from the TREE extension, which records the tree
+// sha1s of directories whose entries have not changed since the last commit,
+// so that a commit can be written without hashing every tree again.
+type IndexCacheTree struct {
+	// The name of the directory; "" for the top directory
+	Name string
+
+	// The number of index entries under this directory, or -1 if the
+	// directory has changed and Sha1 is not valid
+	EntryCount int
+
+	Sha1 string
+
+	Subtrees []*IndexCacheTree
+}
+
+// Is the tree sha1 of this directory known?
+func (self *IndexCacheTree) IsValid() bool {
+	return self.EntryCount >= 0
+}
+
+// How a merge conflict at a path looked before it was resolved. Modes and
+// Sha1s are for stages 1 to 3; a mode of 0 means the stage was missing.
+type IndexResolveUndo struct {
+	Path  string
+	Modes [3]uint32
+	Sha1s [3]string
+}
+
+// The untracked cache from the UNTR extension, which remembers the untracked
+// files of each directory, so that "git status" need not read directories
+// which have not changed.
+type IndexUntrackedCache struct {
+	// Describe the environments the cache is valid in, such as the
+	// location of the work tree
+	Environments []string
+
+	// The sha1s of $GIT_DIR/info/exclude and of core.excludesFile
+	InfoExcludeSha1  string
+	ExcludesFileSha1 string
+
+	// The flags passed to git's directory reader
+	DirFlags uint32
+
+	// The name of the per-directory exclude file, usually ".gitignore"
+	ExcludePerDir string
+
+	// The top of the work tree, or nil if no directories are cached
+	Root *IndexUntrackedDir
+}
+
+// A directory in the untracked cache
+type IndexUntrackedDir struct {
+	// The name of the directory, without its parents; "" for the top
+	Name string
+
+	// The names of the untracked files and directories in it
+	Untracked []string
+
+	Subdirs []*IndexUntrackedDir
+
+	// Whether the untracked list of this directory can be used
+	Valid bool
+
+	// Whether only the existence of untracked files was checked
+	CheckOnly bool
+
+	// The sha1 of this directory's exclude file, or "" if unknown
+	ExcludeSha1 string
+}
+
+// The bitmaps of the link extension
+type indexSplitIndexLink struct {
+	deleteBitmap  *ewahBitmap
+	replaceBitmap *ewahBitmap
+}
+
+func (self *Index) _parseExtension(signature string, data []byte) error {
+	switch signature {
+	case "TREE":
+		cacheTree, rest, err := _parseIndexCacheTree(data)
+		if err != nil {
+			return err
+		}
+		if len(rest) != 0 {
+			return errors.Errorf("%d bytes left after the cache tree", len(rest))
+		}
+		self.CacheTree = cacheTree
+	case "REUC":
+		resolveUndo, err := _parseIndexResolveUndo(data)
+		if err != nil {
+			return err
+		}
+		self.ResolveUndo = resolveUndo
+	case "UNTR":
+		untrackedCache, err := _parseIndexUntrackedCache(data)
+		if err != nil {
+			return err
+		}
+		self.UntrackedCache = untrackedCache
+	case "link":
+		if len(data) < sha1.Size {
+			return errors.New("Truncated shared index sha1")
+		}
+		self.SharedIndexSha1 = hex.EncodeToString(data[:sha1.Size])
+		link := &indexSplitIndexLink{}
+		// A split index written by "git update-index --split-index" before
+		// any changes has no bitmaps
+		rest := data[sha1.Size:]
+		if len(rest) > 0 {
+			var err error
+			link.deleteBitmap, rest, err = _parseEwahBitmap(rest)
+			if err != nil {
+				return errors.Wrap(err, "Reading delete bitmap")
+			}
+			link.replaceBitmap, _, err = _parseEwahBitmap(rest)
+			if err != nil {
+				return errors.Wrap(err, "Reading replace bitmap")
+			}
+		} else {
+			link.deleteBitmap = &ewahBitmap{}
+			link.replaceBitmap = &ewahBitmap{}
+		}
+		self.splitIndexLink = link
+	case "sdir":
+		self.Sparse = true
+	default:
+		// Extensions with lowercase signatures must be understood;
+		// the others are optional
+		if signature[0] < 'A' || signature[0] > 'Z' {
+			return errors.Errorf("Required extension %s is not supported", signature)
+		}
+		self.OtherExtensions[signature] = data
+	}
+	return nil
+}
+
+// Read a NUL-terminated string, returning the bytes after it
+func _indexCString(data []byte) (string, []byte, error) {
+	nul := bytes.IndexByte(data, 0)
+	if nul < 0 {
+		return "", nil, errors.New("String is not terminated")
+	}
+	return string(data[:nul]), data[nul+1:], nil
+}
+
+// Each node is "<name>\0<entry count> <subtree count>\n", then the sha1
+// if the entry count is not -1, then the subtrees
+func _parseIndexCacheTree(data []byte) (*IndexCacheTree, []byte, error) {
+	name, rest, err := _indexCString(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	newline := bytes.IndexByte(rest, '\n')
+	if newline < 0 {
+		return nil, nil, errors.Errorf("Cache tree node '%s' has no counts", name)
+	}
+	counts := bytes.Fields(rest[:newline])
+	if len(counts) != 2 {
+		return nil, nil, errors.Errorf("Cache tree node '%s' has bad counts '%s'", name, rest[:newline])
+	}
+	entryCount, err := strconv.Atoi(string(counts[0]))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Parsing entry count of cache tree node '%s'", name)
+	}
+	subtreeCount, err := strconv.Atoi(string(counts[1]))
+	if err != nil || subtreeCount < 0 {
+		return nil, nil, errors.Errorf("Cache tree node '%s' has bad subtree count '%s'", name, counts[1])
+	}
+	rest = rest[newline+1:]
+
+	node := &IndexCacheTree{
+		Name:       name,
+		EntryCount: entryCount,
+		Subtrees:   make([]*IndexCacheTree, 0, subtreeCount),
+	}
+	if node.IsValid() {
+		if len(rest) < sha1.Size {
+			return nil, nil, errors.Errorf("Cache tree node '%s' is truncated", name)
+		}
+		node.Sha1 = hex.EncodeToString(rest[:sha1.Size])
+		rest = rest[sha1.Size:]
+	}
+	for i := 0; i < subtreeCount; i++ {
+		var subtree *IndexCacheTree
+		subtree, rest, err = _parseIndexCacheTree(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		node.Subtrees = append(node.Subtrees, subtree)
+	}
+	return node, rest, nil
+}
+
+// Each entry is "<path>\0", three "<octal mode>\0", and then
+// the sha1 of each stage whose mode is not 0
+func _parseIndexResolveUndo(data []byte) ([]*IndexResolveUndo, error) {
+	entries := make([]*IndexResolveUndo, 0)
+	for len(data) > 0 {
+		entry := &IndexResolveUndo{}
+		var err error
+		entry.Path, data, err = _indexCString(data)
+		if err != nil {
+			return nil, err
+		}
+		for stage := 0; stage < 3; stage++ {
+			var mode string
+			mode, data, err = _indexCString(data)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Reading modes of '%s'", entry.Path)
+			}
+			value, err := strconv.ParseUint(mode, 8, 32)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Parsing mode of '%s'", entry.Path)
+			}
+			entry.Modes[stage] = uint32(value)
+		}
+		for stage := 0; stage < 3; stage++ {
+			if entry.Modes[stage] == 0 {
+				continue
+			}
+			if len(data) < sha1.Size {
+				return nil, errors.Errorf("Truncated sha1s of '%s'", entry.Path)
+			}
+			entry.Sha1s[stage] = hex.EncodeToString(data[:sha1.Size])
+			data = data[sha1.Size:]
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// The stat data which the untracked cache keeps for exclude files: ctime,
+// mtime, dev, ino, uid, gid and size
+const indexUntrackedStatSize = 36
+
+func _parseIndexUntrackedCache(data []byte) (*IndexUntrackedCache, error) {
+	cache := &IndexUntrackedCache{}
+
+	// The environments, as NUL-terminated strings, preceded by their total size
+	identLength, rest, err := _decodeIndexVarint(data)
+	if err != nil {
+		return nil, err
+	}
+	if identLength > uint64(len(rest)) {
+		return nil, errors.New("Truncated environments")
+	}
+	idents := rest[:identLength]
+	rest = rest[identLength:]
+	for len(idents) > 0 {
+		var ident string
+		ident, idents, err = _indexCString(idents)
+		if err != nil {
+			return nil, errors.Wrap(err, "Reading environments")
+		}
+		cache.Environments = append(cache.Environments, ident)
+	}
+
+	// The stat data of both exclude files, the flags, and then the sha1s
+	// of both exclude files
+	headerSize := 2*indexUntrackedStatSize + 4 + 2*sha1.Size
+	if len(rest) < headerSize {
+		return nil, errors.New("Truncated header")
+	}
+	cache.DirFlags = binary.BigEndian.Uint32(rest[2*indexUntrackedStatSize:])
+	sha1sStart := 2*indexUntrackedStatSize + 4
+	cache.InfoExcludeSha1 = hex.EncodeToString(rest[sha1sStart : sha1sStart+sha1.Size])
+	cache.ExcludesFileSha1 = hex.EncodeToString(rest[sha1sStart+sha1.Size : headerSize])
+	cache.ExcludePerDir, rest, err = _indexCString(rest[headerSize:])
+	if err != nil {
+		return nil, errors.Wrap(err, "Reading exclude file name")
+	}
+
+	dirCount, rest, err := _decodeIndexVarint(rest)
+	if err != nil {
+		return nil, err
+	}
+	if dirCount == 0 {
+		return cache, nil
+	}
+
+	// The directories, depth first, and then bitmaps which say
+	// which of them are valid
+	dirs := make([]*IndexUntrackedDir, 0)
+	cache.Root, rest, err = _parseIndexUntrackedDir(rest, &dirs)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(dirs)) != dirCount {
+		return nil, errors.Errorf("Expected %d directories, but found %d", dirCount, len(dirs))
+	}
+
+	bitmaps := make([][]int, 3)
+	for i := range bitmaps {
+		var bitmap *ewahBitmap
+		bitmap, rest, err = _parseEwahBitmap(rest)
+		if err != nil {
+			return nil, errors.Wrap(err, "Reading directory bitmaps")
+		}
+		bitmaps[i], err = bitmap.Bits()
+		if err != nil {
+			return nil, errors.Wrap(err, "Reading directory bitmaps")
+		}
+		for _, bit := range bitmaps[i] {
+			if bit >= len(dirs) {
+				return nil, errors.Errorf("Directory bitmap refers to directory %d of %d", bit, len(dirs))
+			}
+		}
+	}
+	for _, bit := range bitmaps[0] {
+		dirs[bit].Valid = true
+	}
+	for _, bit := range bitmaps[1] {
+		dirs[bit].CheckOnly = true
+	}
+
+	// The stat data of each valid directory, and then the sha1s of the
+	// exclude files of the directories which have one
+	validDirs, hashedDirs := bitmaps[0], bitmaps[2]
+	if len(rest) < len(validDirs)*indexUntrackedStatSize+len(hashedDirs)*sha1.Size {
+		return nil, errors.New("Truncated exclude file data")
+	}
+	rest = rest[len(validDirs)*indexUntrackedStatSize:]
+	for _, bit := range hashedDirs {
+		dirs[bit].ExcludeSha1 = hex.EncodeToString(rest[:sha1.Size])
+		rest = rest[sha1.Size:]
+	}
+	return cache, nil
+}
+
+// Each directory is "<untracked count><subdir count><name>\0", then the
+// untracked names, each ending in NUL, and then its subdirectories
+func _parseIndexUntrackedDir(data []byte, dirs *[]*IndexUntrackedDir) (*IndexUntrackedDir, []byte, error) {
+	untrackedCount, rest, err := _decodeIndexVarint(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	subdirCount, rest, err := _decodeIndexVarint(rest)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir := &IndexUntrackedDir{
+		Untracked: make([]string, 0),
+		Subdirs:   make([]*IndexUntrackedDir, 0),
+	}
+	dir.Name, rest, err = _indexCString(rest)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Reading directory name")
+	}
+	for i := uint64(0); i < untrackedCount; i++ {
+		var name string
+		name, rest, err = _indexCString(rest)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Reading untracked names in '%s'", dir.Name)
+		}
+		dir.Untracked = append(dir.Untracked, name)
+	}
+
+	*dirs = append(*dirs, dir)
+	for i := uint64(0); i < subdirCount; i++ {
+		var subdir *IndexUntrackedDir
+		subdir, rest, err = _parseIndexUntrackedDir(rest, dirs)
+		if err != nil {
+			return nil, nil, err
+		}
+		dir.Subdirs = append(dir.Subdirs, subdir)
+	}
+	return dir, rest, nil
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package gitobjects
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Fill in the stat data that os.FileInfo does not have
+func _fillIndexEntrySysStat(entry *IndexEntry, info os.FileInfo) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	entry.CTime = time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec))
+	entry.Dev = uint32(stat.Dev)
+	entry.Ino = uint32(stat.Ino)
+	entry.UID = stat.Uid
+	entry.GID = stat.Gid
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package gitobjects
+
+import (
+	"os"
+)
+
+// Only Linux stat data is understood; elsewhere the ctime is taken to be
+// the mtime, and the other fields are left at 0, as git does on Windows.
+func _fillIndexEntrySysStat(entry *IndexEntry, info os.FileInfo) {
+	entry.CTime = info.ModTime()
+}
@@ -0,0 +1,191 @@
+package gitobjects
+
+import (
+	"fmt"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Run git in the work tree, and return its output
+func runGitIn(c *C, repo *Repo, repoDir string, argv ...string) string {
+	cmd := repo.Command(argv)
+	cmd.Dir = repoDir
+	output, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("git %v: %s", argv, output))
+	return strings.TrimRight(string(output), "\n")
+}
+
+// Check that the index has what "git ls-files --stage" shows
+func checkIndexMatchesGit(c *C, repo *Repo, repoDir string, lsFilesArgs ...string) *Index {
+	index, err := repo.Index()
+	c.Assert(err, IsNil)
+
+	expected := runGitIn(c, repo, repoDir, append([]string{"ls-files", "--stage"}, lsFilesArgs...)...)
+	actual := make([]string, len(index.Entries))
+	for i, entry := range index.Entries {
+		actual[i] = fmt.Sprintf("%s %s %d\t%s", entry.Permissions(), entry.Sha1, entry.Stage, entry.Path)
+	}
+	c.Check(strings.Join(actual, "\n"), Equals, expected)
+	return index
+}
+
+func (s *MySuite) TestIndexVersions(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+
+	index := checkIndexMatchesGit(c, repo, repoDir)
+	c.Check(index.Version, Equals, uint32(2))
+	c.Check(index.Entries[0].Path, Equals, "README")
+	c.Check(index.Entries[0].Size, Equals, uint32(5))
+	c.Check(index.Entries[0].Mode, Equals, uint32(0100644))
+	info, err := os.Stat(filepath.Join(repoDir, "README"))
+	c.Assert(err, IsNil)
+	c.Check(index.Entries[0].MTime.Equal(info.ModTime()), Equals, true)
+	c.Check(index.Entry("src/main.go", 0), Equals, index.Entries[2])
+	c.Check(index.Entry("src", 0), IsNil)
+
+	// The commit left a valid cache tree
+	c.Assert(index.CacheTree, NotNil)
+	c.Check(index.CacheTree.Sha1, Equals, runGitIn(c, repo, repoDir, "rev-parse", "HEAD^{tree}"))
+	c.Check(index.CacheTree.EntryCount, Equals, 3)
+	subtrees := make(map[string]*IndexCacheTree)
+	for _, subtree := range index.CacheTree.Subtrees {
+		subtrees[subtree.Name] = subtree
+	}
+	c.Assert(len(subtrees), Equals, 2)
+	c.Check(subtrees["docs"].Sha1, Equals, runGitIn(c, repo, repoDir, "rev-parse", "HEAD:docs"))
+
+	// Extended flags need version 3
+	runGitIn(c, repo, repoDir, "update-index", "--skip-worktree", "README")
+	index = checkIndexMatchesGit(c, repo, repoDir)
+	c.Check(index.Version, Equals, uint32(3))
+	c.Check(index.Entries[0].SkipWorktree, Equals, true)
+	c.Check(index.Entries[1].SkipWorktree, Equals, false)
+
+	// Version 4 compresses paths
+	runGitIn(c, repo, repoDir, "update-index", "--index-version", "4")
+	index = checkIndexMatchesGit(c, repo, repoDir)
+	c.Check(index.Version, Equals, uint32(4))
+	c.Check(index.Entries[0].SkipWorktree, Equals, true)
+}
+
+func (s *MySuite) TestIndexConflicts(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	writeReadme := func(contents string) {
+		err := ioutil.WriteFile(filepath.Join(repoDir, "README"), []byte(contents), 0666)
+		c.Assert(err, IsNil)
+	}
+
+	runGitIn(c, repo, repoDir, "checkout", "-q", "-b", "other")
+	writeReadme("other\n")
+	runGitIn(c, repo, repoDir, "commit", "-q", "-a", "-m", "Other")
+	runGitIn(c, repo, repoDir, "checkout", "-q", "-")
+	writeReadme("mine\n")
+	runGitIn(c, repo, repoDir, "commit", "-q", "-a", "-m", "Mine")
+
+	cmd := repo.Command([]string{"merge", "other"})
+	cmd.Dir = repoDir
+	c.Assert(cmd.Run(), NotNil)
+	index := checkIndexMatchesGit(c, repo, repoDir)
+	c.Assert(len(index.Entries), Equals, 3)
+	for i, entry := range index.Entries {
+		c.Check(entry.Stage, Equals, i+1)
+	}
+	c.Check(index.Entry("README", 0), IsNil)
+	c.Check(index.Entry("README", 3), Equals, index.Entries[2])
+
+	// Resolving the conflict records how to undo it
+	writeReadme("merged\n")
+	runGitIn(c, repo, repoDir, "add", "README")
+	index = checkIndexMatchesGit(c, repo, repoDir)
+	c.Assert(len(index.ResolveUndo), Equals, 1)
+	resolveUndo := index.ResolveUndo[0]
+	c.Check(resolveUndo.Path, Equals, "README")
+	c.Check(resolveUndo.Modes, Equals, [3]uint32{0100644, 0100644, 0100644})
+	c.Check(resolveUndo.Sha1s[1], Equals, runGitIn(c, repo, repoDir, "rev-parse", "HEAD:README"))
+	c.Check(resolveUndo.Sha1s[2], Equals, runGitIn(c, repo, repoDir, "rev-parse", "other:README"))
+}
+
+func (s *MySuite) TestIndexSplit(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+	runGitIn(c, repo, repoDir, "update-index", "--split-index")
+
+	// Replace one shared entry, delete another, and add a new one
+	err := ioutil.WriteFile(filepath.Join(repoDir, "README"), []byte("changed\n"), 0666)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(repoDir, "NEW"), []byte("new\n"), 0666)
+	c.Assert(err, IsNil)
+	runGitIn(c, repo, repoDir, "add", "README", "NEW")
+	runGitIn(c, repo, repoDir, "rm", "-q", "--cached", "src/main.go")
+
+	index := checkIndexMatchesGit(c, repo, repoDir)
+	c.Check(index.SharedIndexSha1, Matches, "[0-9a-f]{40}")
+	c.Check(len(index.Entries), Equals, 3)
+}
+
+func (s *MySuite) TestIndexUntrackedCache(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+	err := ioutil.WriteFile(filepath.Join(repoDir, "src", "untracked"), []byte("untracked\n"), 0666)
+	c.Assert(err, IsNil)
+	// Only src has an exclude file, so fewer directories have sha1s than
+	// are valid. It is tracked, so git records its blob's sha1.
+	err = ioutil.WriteFile(filepath.Join(repoDir, "src", ".gitignore"), []byte("*.tmp\n"), 0666)
+	c.Assert(err, IsNil)
+	runGitIn(c, repo, repoDir, "add", "src/.gitignore")
+	runGitIn(c, repo, repoDir, "update-index", "--untracked-cache")
+	runGitIn(c, repo, repoDir, "status", "--porcelain")
+
+	index := checkIndexMatchesGit(c, repo, repoDir)
+	cache := index.UntrackedCache
+	c.Assert(cache, NotNil)
+	c.Check(cache.ExcludePerDir, Equals, ".gitignore")
+	c.Assert(cache.Root, NotNil)
+	c.Check(cache.Root.Valid, Equals, true)
+	var src *IndexUntrackedDir
+	for _, subdir := range cache.Root.Subdirs {
+		if subdir.Name == "src" {
+			src = subdir
+		}
+	}
+	c.Assert(src, NotNil)
+	c.Check(src.Untracked, DeepEquals, []string{"untracked"})
+	c.Check(src.ExcludeSha1, Equals, runGitIn(c, repo, repoDir, "hash-object", "src/.gitignore"))
+	c.Check(cache.Root.ExcludeSha1, Equals, "")
+}
+
+func (s *MySuite) TestIndexSparse(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+	runGitIn(c, repo, repoDir, "sparse-checkout", "set", "--cone", "--sparse-index", "src")
+
+	index := checkIndexMatchesGit(c, repo, repoDir, "--sparse")
+	c.Check(index.Sparse, Equals, true)
+	docs := index.Entry("docs/", 0)
+	c.Assert(docs, NotNil)
+	c.Check(docs.IsSparseDir(), Equals, true)
+	c.Check(docs.SkipWorktree, Equals, true)
+	c.Check(docs.Sha1, Equals, runGitIn(c, repo, repoDir, "rev-parse", "HEAD:docs"))
+}
+
+func (s *MySuite) TestIndexMissingOrCorrupt(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	indexFile := filepath.Join(repoDir, ".git", "index")
+	data, err := ioutil.ReadFile(indexFile)
+	c.Assert(err, IsNil)
+
+	data[len(data)-30] ^= 0xff
+	err = ioutil.WriteFile(indexFile, data, 0666)
+	c.Assert(err, IsNil)
+	_, err = repo.Index()
+	c.Check(err, ErrorMatches, "Parsing .*index: Index checksum is .*")
+
+	c.Assert(os.Remove(indexFile), IsNil)
+	index, err := repo.Index()
+	c.Assert(err, IsNil)
+	c.Check(len(index.Entries), Equals, 0)
+}
@@ -0,0 +1,490 @@
+package gitobjects
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Create an index with the contents of a tree, like "git read-tree". The
+// entries have no stat data, so git will hash each file once to check it.
+// The cache tree is filled in, as every directory matches its tree.
+func NewIndexFromTree(repo *Repo, tree *Tree) (*Index, error) {
+	index := &Index{
+		Version:         2,
+		Entries:         make([]*IndexEntry, 0),
+		OtherExtensions: make(map[string][]byte),
+	}
+	cacheTree, err := index._addTree(repo, tree, "")
+	if err != nil {
+		return nil, err
+	}
+	index.CacheTree = cacheTree
+	sort.SliceStable(index.Entries, func(i, j int) bool {
+		return index.Entries[i].Path < index.Entries[j].Path
+	})
+	return index, nil
+}
+
+// Add the entries of a tree, and return its cache tree node
+func (self *Index) _addTree(repo *Repo, tree *Tree, parentPath string) (*IndexCacheTree, error) {
+	entries, err := tree.Entries(repo)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Reading tree %s at '%s'", tree.Sha1(), parentPath)
+	}
+	node := &IndexCacheTree{
+		Name:     filepath.Base(parentPath),
+		Sha1:     tree.Sha1(),
+		Subtrees: make([]*IndexCacheTree, 0),
+	}
+	if parentPath == "" {
+		node.Name = ""
+	}
+	for _, entry := range entries {
+		path := entry.name
+		if parentPath != "" {
+			path = parentPath + "/" + entry.name
+		}
+		if entry.Type() == "tree" {
+			subtree, err := entry.Tree(repo)
+			if err != nil {
+				return nil, err
+			}
+			subtreeNode, err := self._addTree(repo, subtree, path)
+			if err != nil {
+				return nil, err
+			}
+			node.EntryCount += subtreeNode.EntryCount
+			node.Subtrees = append(node.Subtrees, subtreeNode)
+			continue
+		}
+		mode, err := _parseOctalMode(entry.permissions)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Parsing mode of '%s'", path)
+		}
+		self.Entries = append(self.Entries, &IndexEntry{
+			Mode: mode,
+			Sha1: entry.sha1,
+			Path: path,
+		})
+		node.EntryCount++
+	}
+	return node, nil
+}
+
+func _parseOctalMode(permissions string) (uint32, error) {
+	var mode uint32
+	_, err := fmt.Sscanf(permissions, "%o", &mode)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Parsing mode '%s'", permissions)
+	}
+	return mode, nil
+}
+
+// Write the index to the repository's index file, holding git's index.lock
+// while doing so. The untracked cache, the link to a shared index, and any
+// other extensions which are not understood are not written; git rebuilds
+// what it needs of them.
+func (self *Repo) WriteIndex(index *Index) error {
+	data, version, err := index._encode()
+	if err != nil {
+		return errors.Wrap(err, "Encoding index")
+	}
+
+	indexFile := filepath.Join(self.gitDir, "index")
+	lock, err := newLockFile(indexFile, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = lock.Write(data)
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	err = lock.Commit()
+	if err != nil {
+		return err
+	}
+
+	// Match what is now on disk
+	index.Version = version
+	index.Checksum = hex.EncodeToString(data[len(data)-sha1.Size:])
+	index.UntrackedCache = nil
+	index.SharedIndexSha1 = ""
+	index.splitIndexLink = nil
+	index.OtherExtensions = make(map[string][]byte)
+	return nil
+}
+
+// Stage a file from the work tree, like "git add": its contents are written to
+// the object database, and its entry gets the file's current stat data. A
+// conflict at the path is resolved, and anything that the file replaces, such
+// as a directory of the same name, is removed.
+func (self *Index) Add(repo *Repo, workTree string, path string) error {
+	path, err := _cleanIndexPath(path)
+	if err != nil {
+		return err
+	}
+	fullPath := filepath.Join(workTree, filepath.FromSlash(path))
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return errors.Wrapf(err, "Adding '%s'", path)
+	}
+
+	var contents []byte
+	var mode uint32
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(fullPath)
+		if err != nil {
+			return errors.Wrapf(err, "Adding '%s'", path)
+		}
+		contents = []byte(target)
+		mode = 0120000
+	case info.Mode().IsRegular():
+		contents, err = ioutil.ReadFile(fullPath)
+		if err != nil {
+			return errors.Wrapf(err, "Adding '%s'", path)
+		}
+		// Only the owner's execute bit counts, as in git
+		mode = 0100644
+		if info.Mode()&0100 != 0 {
+			mode = 0100755
+		}
+	default:
+		return errors.Errorf("Adding '%s': not a regular file or symlink", path)
+	}
+
+	sha1, err := repo._writeLooseObject("blob", contents)
+	if err != nil {
+		return errors.Wrapf(err, "Adding '%s'", path)
+	}
+	entry := &IndexEntry{
+		MTime: info.ModTime(),
+		Size:  uint32(info.Size()),
+		Mode:  mode,
+		Sha1:  sha1,
+		Path:  path,
+	}
+	_fillIndexEntrySysStat(entry, info)
+	return self._addEntry(entry)
+}
+
+// Unstage a path, like "git rm --cached", removing every stage of it.
+// Returns false if the path was not in the index.
+func (self *Index) Remove(path string) bool {
+	path, err := _cleanIndexPath(path)
+	if err != nil {
+		return false
+	}
+	return len(self._removeStages(path)) > 0
+}
+
+// A path as the index has it: relative, slash-separated and clean
+func _cleanIndexPath(path string) (string, error) {
+	path = filepath.ToSlash(path)
+	cleaned := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "./")
+	if path == "" || cleaned == "." || strings.HasPrefix(cleaned, "/") ||
+		cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", errors.Errorf("'%s' is not a path inside the work tree", path)
+	}
+	for _, component := range strings.Split(cleaned, "/") {
+		if strings.EqualFold(component, ".git") {
+			return "", errors.Errorf("'%s' is inside a .git directory", path)
+		}
+	}
+	return cleaned, nil
+}
+
+// Put a stage 0 entry in its place, replacing what is in its way
+func (self *Index) _addEntry(entry *IndexEntry) error {
+	path := entry.Path
+
+	// Entries for each parent directory that is a file, or a sparse directory
+	for parent := path; strings.Contains(parent, "/"); {
+		parent = parent[:strings.LastIndexByte(parent, '/')]
+		if sparseDir := self.Entry(parent+"/", 0); sparseDir != nil && sparseDir.IsSparseDir() {
+			return errors.Errorf("Adding '%s': it is inside sparse directory '%s'", path, sparseDir.Path)
+		}
+		self._removeStages(parent)
+	}
+
+	// Entries under a directory of the same name
+	i := self._search(path+"/", 0)
+	j := i
+	for j < len(self.Entries) && strings.HasPrefix(self.Entries[j].Path, path+"/") {
+		self._invalidateCacheTree(self.Entries[j].Path)
+		j++
+	}
+	self.Entries = append(self.Entries[:i], self.Entries[j:]...)
+
+	// A conflict is resolved, and remembered in case it must be undone
+	resolveUndo := &IndexResolveUndo{Path: path}
+	for _, conflicted := range self._removeStages(path) {
+		if conflicted.Stage > 0 {
+			resolveUndo.Modes[conflicted.Stage-1] = conflicted.Mode
+			resolveUndo.Sha1s[conflicted.Stage-1] = conflicted.Sha1
+		}
+	}
+	if resolveUndo.Modes != [3]uint32{} {
+		self._recordResolveUndo(resolveUndo)
+	}
+
+	i = self._search(path, 0)
+	self.Entries = append(self.Entries, nil)
+	copy(self.Entries[i+1:], self.Entries[i:])
+	self.Entries[i] = entry
+	self._invalidateCacheTree(path)
+	return nil
+}
+
+// Remove every stage of a path, returning what was removed
+func (self *Index) _removeStages(path string) []*IndexEntry {
+	i := self._search(path, 0)
+	j := i
+	for j < len(self.Entries) && self.Entries[j].Path == path {
+		j++
+	}
+	removed := append([]*IndexEntry{}, self.Entries[i:j]...)
+	if len(removed) > 0 {
+		self.Entries = append(self.Entries[:i], self.Entries[j:]...)
+		self._invalidateCacheTree(path)
+	}
+	return removed
+}
+
+// Keep the resolve-undo entries sorted by path, one per path
+func (self *Index) _recordResolveUndo(resolveUndo *IndexResolveUndo) {
+	i := sort.Search(len(self.ResolveUndo), func(i int) bool {
+		return self.ResolveUndo[i].Path >= resolveUndo.Path
+	})
+	if i < len(self.ResolveUndo) && self.ResolveUndo[i].Path == resolveUndo.Path {
+		self.ResolveUndo[i] = resolveUndo
+		return
+	}
+	self.ResolveUndo = append(self.ResolveUndo, nil)
+	copy(self.ResolveUndo[i+1:], self.ResolveUndo[i:])
+	self.ResolveUndo[i] = resolveUndo
+}
+
+// A change to a path means the tree of each directory above it must be
+// hashed again
+func (self *Index) _invalidateCacheTree(path string) {
+	node := self.CacheTree
+	components := strings.Split(path, "/")
+	for node != nil {
+		node.EntryCount = -1
+		node.Sha1 = ""
+		if len(components) <= 1 {
+			return
+		}
+		var next *IndexCacheTree
+		for _, subtree := range node.Subtrees {
+			if subtree.Name == components[0] {
+				next = subtree
+				break
+			}
+		}
+		node = next
+		components = components[1:]
+	}
+}
+
+// Encode the index, returning the version which was used: version 2 is
+// raised to 3 if any entry needs extended flags.
+func (self *Index) _encode() ([]byte, uint32, error) {
+	version := self.Version
+	if version == 0 {
+		version = 2
+	}
+	if version < 2 || version > 4 {
+		return nil, 0, errors.Errorf("Index version %d is not supported", version)
+	}
+	for _, entry := range self.Entries {
+		if version == 2 && (entry.SkipWorktree || entry.IntentToAdd) {
+			version = 3
+		}
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("DIRC")
+	binary.Write(&buffer, binary.BigEndian, version)
+	binary.Write(&buffer, binary.BigEndian, uint32(len(self.Entries)))
+
+	previousPath := ""
+	for i, entry := range self.Entries {
+		if i > 0 {
+			previous := self.Entries[i-1]
+			if previous.Path > entry.Path || (previous.Path == entry.Path && previous.Stage >= entry.Stage) {
+				return nil, 0, errors.Errorf("Entry '%s' stage %d is out of order", entry.Path, entry.Stage)
+			}
+		}
+		err := _encodeIndexEntry(&buffer, entry, version, previousPath)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "Encoding entry '%s'", entry.Path)
+		}
+		previousPath = entry.Path
+	}
+
+	if self.CacheTree != nil {
+		var extension bytes.Buffer
+		_encodeIndexCacheTree(&extension, self.CacheTree)
+		_writeIndexExtension(&buffer, "TREE", extension.Bytes())
+	}
+	if len(self.ResolveUndo) > 0 {
+		var extension bytes.Buffer
+		for _, resolveUndo := range self.ResolveUndo {
+			err := _encodeIndexResolveUndo(&extension, resolveUndo)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		_writeIndexExtension(&buffer, "REUC", extension.Bytes())
+	}
+	if self.Sparse {
+		_writeIndexExtension(&buffer, "sdir", nil)
+	}
+
+	checksum := sha1.Sum(buffer.Bytes())
+	buffer.Write(checksum[:])
+	return buffer.Bytes(), version, nil
+}
+
+func _encodeIndexEntry(buffer *bytes.Buffer, entry *IndexEntry, version uint32, previousPath string) error {
+	binarySha1, err := hex.DecodeString(entry.Sha1)
+	if err != nil || len(binarySha1) != sha1.Size {
+		return errors.Errorf("Bad sha1 '%s'", entry.Sha1)
+	}
+	if entry.Stage < 0 || entry.Stage > 3 {
+		return errors.Errorf("Bad stage %d", entry.Stage)
+	}
+	if entry.Path == "" || strings.IndexByte(entry.Path, 0) >= 0 {
+		return errors.New("Bad path")
+	}
+
+	start := buffer.Len()
+	ctimeSeconds, ctimeNanoseconds := _indexTime(entry.CTime)
+	mtimeSeconds, mtimeNanoseconds := _indexTime(entry.MTime)
+	for _, value := range []uint32{ctimeSeconds, ctimeNanoseconds, mtimeSeconds, mtimeNanoseconds,
+		entry.Dev, entry.Ino, entry.Mode, entry.UID, entry.GID, entry.Size} {
+		binary.Write(buffer, binary.BigEndian, value)
+	}
+	buffer.Write(binarySha1)
+
+	flags := uint16(entry.Stage) << indexFlagStageShift
+	if len(entry.Path) < indexFlagNameMask {
+		flags |= uint16(len(entry.Path))
+	} else {
+		flags |= indexFlagNameMask
+	}
+	if entry.AssumeValid {
+		flags |= indexFlagAssumeValid
+	}
+	extended := entry.SkipWorktree || entry.IntentToAdd
+	if extended {
+		flags |= indexFlagExtended
+	}
+	binary.Write(buffer, binary.BigEndian, flags)
+	if extended {
+		var extendedFlags uint16
+		if entry.SkipWorktree {
+			extendedFlags |= indexFlagSkipWorktree
+		}
+		if entry.IntentToAdd {
+			extendedFlags |= indexFlagIntentToAdd
+		}
+		binary.Write(buffer, binary.BigEndian, extendedFlags)
+	}
+
+	if version >= 4 {
+		common := 0
+		for common < len(previousPath) && common < len(entry.Path) && previousPath[common] == entry.Path[common] {
+			common++
+		}
+		buffer.Write(_encodeIndexVarint(uint64(len(previousPath) - common)))
+		buffer.WriteString(entry.Path[common:])
+		buffer.WriteByte(0)
+		return nil
+	}
+
+	// Padded with 1 to 8 NULs, to a multiple of 8 bytes
+	buffer.WriteString(entry.Path)
+	entrySize := (buffer.Len() - start + 8) &^ 7
+	buffer.Write(make([]byte, entrySize-(buffer.Len()-start)))
+	return nil
+}
+
+// The seconds and nanoseconds of a time; the zero time is 0
+func _indexTime(t time.Time) (uint32, uint32) {
+	if t.IsZero() {
+		return 0, 0
+	}
+	return uint32(t.Unix()), uint32(t.Nanosecond())
+}
+
+// The inverse of _decodeIndexVarint
+func _encodeIndexVarint(value uint64) []byte {
+	var varint [16]byte
+	pos := len(varint) - 1
+	varint[pos] = byte(value & 0x7f)
+	for value >>= 7; value != 0; value >>= 7 {
+		value--
+		pos--
+		varint[pos] = 0x80 | byte(value&0x7f)
+	}
+	return varint[pos:]
+}
+
+func _writeIndexExtension(buffer *bytes.Buffer, signature string, data []byte) {
+	buffer.WriteString(signature)
+	binary.Write(buffer, binary.BigEndian, uint32(len(data)))
+	buffer.Write(data)
+}
+
+func _encodeIndexCacheTree(buffer *bytes.Buffer, node *IndexCacheTree) {
+	entryCount := node.EntryCount
+	binarySha1, err := hex.DecodeString(node.Sha1)
+	if err != nil || len(binarySha1) != sha1.Size {
+		// Not worth failing for; git will hash the tree again
+		entryCount = -1
+	}
+	buffer.WriteString(node.Name)
+	buffer.WriteByte(0)
+	fmt.Fprintf(buffer, "%d %d\n", entryCount, len(node.Subtrees))
+	if entryCount >= 0 {
+		buffer.Write(binarySha1)
+	}
+	for _, subtree := range node.Subtrees {
+		_encodeIndexCacheTree(buffer, subtree)
+	}
+}
+
+func _encodeIndexResolveUndo(buffer *bytes.Buffer, resolveUndo *IndexResolveUndo) error {
+	buffer.WriteString(resolveUndo.Path)
+	buffer.WriteByte(0)
+	for _, mode := range resolveUndo.Modes {
+		fmt.Fprintf(buffer, "%o", mode)
+		buffer.WriteByte(0)
+	}
+	for stage, mode := range resolveUndo.Modes {
+		if mode == 0 {
+			continue
+		}
+		binarySha1, err := hex.DecodeString(resolveUndo.Sha1s[stage])
+		if err != nil || len(binarySha1) != sha1.Size {
+			return errors.Errorf("Resolve-undo entry '%s' has bad sha1 '%s'", resolveUndo.Path,
+				resolveUndo.Sha1s[stage])
+		}
+		buffer.Write(binarySha1)
+	}
+	return nil
+}
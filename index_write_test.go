@@ -0,0 +1,137 @@
+package gitobjects
+
+import (
+	"bytes"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Encoding an index that git wrote must give back the same bytes
+func checkIndexRoundTrip(c *C, repo *Repo, repoDir string) {
+	original, err := ioutil.ReadFile(filepath.Join(repoDir, ".git", "index"))
+	c.Assert(err, IsNil)
+	index, err := repo.Index()
+	c.Assert(err, IsNil)
+	encoded, version, err := index._encode()
+	c.Assert(err, IsNil)
+	c.Check(version, Equals, index.Version)
+	c.Check(bytes.Equal(encoded, original), Equals, true, Commentf("Version %d", index.Version))
+}
+
+func (s *MySuite) TestIndexEncodeRoundTrip(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+	checkIndexRoundTrip(c, repo, repoDir)
+
+	runGitIn(c, repo, repoDir, "update-index", "--skip-worktree", "README")
+	checkIndexRoundTrip(c, repo, repoDir)
+
+	runGitIn(c, repo, repoDir, "update-index", "--index-version", "4")
+	checkIndexRoundTrip(c, repo, repoDir)
+
+	c.Check(_encodeIndexVarint(0), DeepEquals, []byte{0})
+	for _, value := range []uint64{1, 127, 128, 16511, 16512, 1 << 40} {
+		decoded, rest, err := _decodeIndexVarint(_encodeIndexVarint(value))
+		c.Assert(err, IsNil)
+		c.Check(decoded, Equals, value)
+		c.Check(len(rest), Equals, 0)
+	}
+}
+
+func (s *MySuite) TestWriteIndexFromTree(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+	expected := runGitIn(c, repo, repoDir, "ls-files", "--stage")
+
+	commit, err := repo.GetCommit(runGitIn(c, repo, repoDir, "rev-parse", "HEAD"))
+	c.Assert(err, IsNil)
+	tree, err := commit.InstantiateTree(repo)
+	c.Assert(err, IsNil)
+	index, err := NewIndexFromTree(repo, tree)
+	c.Assert(err, IsNil)
+	c.Check(index.CacheTree.EntryCount, Equals, 3)
+
+	c.Assert(os.Remove(filepath.Join(repoDir, ".git", "index")), IsNil)
+	c.Assert(repo.WriteIndex(index), IsNil)
+	checkIndexMatchesGit(c, repo, repoDir)
+	c.Check(runGitIn(c, repo, repoDir, "ls-files", "--stage"), Equals, expected)
+	c.Check(runGitIn(c, repo, repoDir, "write-tree"), Equals, tree.Sha1())
+
+	// Without stat data, git looks at each file once, and finds no changes
+	c.Check(runGitIn(c, repo, repoDir, "status", "--porcelain"), Equals, "")
+}
+
+func (s *MySuite) TestIndexAddRemove(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+	headTree := runGitIn(c, repo, repoDir, "rev-parse", "HEAD^{tree}")
+
+	err := ioutil.WriteFile(filepath.Join(repoDir, "README"), []byte("changed\n"), 0666)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(repoDir, "docs", "run.sh"), []byte("#!/bin/sh\n"), 0777)
+	c.Assert(err, IsNil)
+	groupPath := filepath.Join(repoDir, "docs", "group.sh")
+	c.Assert(ioutil.WriteFile(groupPath, []byte("#!/bin/sh\n"), 0666), IsNil)
+	c.Assert(os.Chmod(groupPath, 0654), IsNil)
+
+	index, err := repo.Index()
+	c.Assert(err, IsNil)
+	c.Assert(index.Add(repo, repoDir, "README"), IsNil)
+	c.Assert(index.Add(repo, repoDir, "./docs/run.sh"), IsNil)
+	c.Assert(index.Add(repo, repoDir, "docs/group.sh"), IsNil)
+	c.Check(index.Remove("src/main.go"), Equals, true)
+	c.Check(index.Remove("src/main.go"), Equals, false)
+	c.Check(index.Add(repo, repoDir, "../outside"), ErrorMatches, ".*not a path inside the work tree")
+	c.Check(index.Add(repo, repoDir, "docs"), ErrorMatches, ".*not a regular file or symlink")
+
+	// The cache tree above each change must not be trusted
+	c.Check(index.CacheTree.IsValid(), Equals, false)
+	c.Assert(repo.WriteIndex(index), IsNil)
+
+	// The stat data matches, so git sees no unstaged changes to README
+	c.Check(runGitIn(c, repo, repoDir, "diff-files", "--name-only"), Equals, "")
+	c.Check(runGitIn(c, repo, repoDir, "status", "--porcelain"), Equals,
+		"M  README\nA  docs/group.sh\nA  docs/run.sh\nD  src/main.go\n?? src/")
+	c.Check(runGitIn(c, repo, repoDir, "cat-file", "blob", ":README"), Equals, "changed")
+	c.Check(runGitIn(c, repo, repoDir, "ls-files", "--stage", "docs/run.sh")[:6], Equals, "100755")
+	// Executable by its group, but not by its owner
+	c.Check(runGitIn(c, repo, repoDir, "ls-files", "--stage", "docs/group.sh")[:6], Equals, "100644")
+	c.Check(runGitIn(c, repo, repoDir, "write-tree"), Not(Equals), headTree)
+	c.Check(runGitIn(c, repo, repoDir, "fsck", "--no-dangling"), Equals, "")
+}
+
+func (s *MySuite) TestIndexAddResolvesConflict(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	runGitIn(c, repo, repoDir, "checkout", "-q", "-b", "other")
+	err := ioutil.WriteFile(filepath.Join(repoDir, "README"), []byte("other\n"), 0666)
+	c.Assert(err, IsNil)
+	runGitIn(c, repo, repoDir, "commit", "-q", "-a", "-m", "Other")
+	runGitIn(c, repo, repoDir, "checkout", "-q", "-")
+	err = ioutil.WriteFile(filepath.Join(repoDir, "README"), []byte("mine\n"), 0666)
+	c.Assert(err, IsNil)
+	runGitIn(c, repo, repoDir, "commit", "-q", "-a", "-m", "Mine")
+	cmd := repo.Command([]string{"merge", "other"})
+	cmd.Dir = repoDir
+	c.Assert(cmd.Run(), NotNil)
+
+	index, err := repo.Index()
+	c.Assert(err, IsNil)
+	c.Assert(index.Add(repo, repoDir, "README"), IsNil)
+	c.Assert(len(index.Entries), Equals, 1)
+	c.Check(index.Entries[0].Stage, Equals, 0)
+	c.Assert(len(index.ResolveUndo), Equals, 1)
+	c.Check(index.ResolveUndo[0].Sha1s[2], Equals, runGitIn(c, repo, repoDir, "rev-parse", "other:README"))
+
+	// Another writer holds the lock
+	lockFile := filepath.Join(repoDir, ".git", "index.lock")
+	c.Assert(ioutil.WriteFile(lockFile, nil, 0666), IsNil)
+	c.Check(repo.WriteIndex(index), ErrorMatches, "Unable to create .*index.lock.*")
+	c.Assert(os.Remove(lockFile), IsNil)
+
+	c.Assert(repo.WriteIndex(index), IsNil)
+	c.Check(runGitIn(c, repo, repoDir, "ls-files", "--unmerged"), Equals, "")
+	runGitIn(c, repo, repoDir, "checkout", "-m", "README")
+	c.Check(len(runGitIn(c, repo, repoDir, "ls-files", "--unmerged")), Not(Equals), 0)
+}
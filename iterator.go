@@ -0,0 +1,201 @@
+package gitobjects
+
+import (
+	"context"
+)
+
+// A pull-style alternative to the (Object channel, error channel) pair returned
+// by StreamObjectsOfType:
+//
+//	iter := repo.ObjectsOfType(ctx, "commit", 4)
+//	defer iter.Close()
+//	for iter.Next() {
+//		obj := iter.Object()
+//	}
+//	if err := iter.Err(); err != nil {
+//	}
+//
+// Closing the iterator, or canceling the context, stops the stream and
+// lets its goroutines exit.
+type ObjectIterator struct {
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	objectChan <-chan Object
+	errorChan  <-chan error
+
+	current Object
+	err     error
+	done    bool
+}
+
+func (self *Repo) ObjectsOfType(ctx context.Context, objectType string, jFactor int) *ObjectIterator {
+	return self.ObjectsOfTypeWithOptions(ctx, objectType, _jFactorStreamOptions(jFactor))
+}
+
+// Like ObjectsOfType, but with full control over the pipeline.
+// If options is nil, DefaultStreamOptions() is used.
+func (self *Repo) ObjectsOfTypeWithOptions(ctx context.Context, objectType string,
+	options *StreamOptions) *ObjectIterator {
+
+	return self.ObjectsOfTypes(ctx, []string{objectType}, options)
+}
+
+// The iterator form of StreamObjectsOfTypes
+func (self *Repo) ObjectsOfTypes(ctx context.Context, objectTypes []string,
+	options *StreamOptions) *ObjectIterator {
+
+	ctx, cancelFunc := context.WithCancel(ctx)
+	objectChan, errorChan := self.StreamObjectsOfTypes(ctx, objectTypes, options)
+	return &ObjectIterator{
+		ctx:        ctx,
+		cancelFunc: cancelFunc,
+		objectChan: objectChan,
+		errorChan:  errorChan,
+	}
+}
+
+// Advance to the next Object. Returns false when there are no more
+// Objects, or after an error; check Err() to tell the difference.
+func (self *ObjectIterator) Next() bool {
+	self.current = nil
+	for !self.done {
+		select {
+		case obj, ok := <-self.objectChan:
+			if !ok {
+				self._finish()
+				return false
+			}
+			self.current = obj
+			return true
+		case err, ok := <-self.errorChan:
+			if !ok {
+				// Only objects can be left
+				self.errorChan = nil
+				continue
+			}
+			self.err = err
+			self.Close()
+			return false
+		}
+	}
+	return false
+}
+
+// The Object found by the last call to Next()
+func (self *ObjectIterator) Object() Object {
+	return self.current
+}
+
+// The first error which stopped the stream, if any
+func (self *ObjectIterator) Err() error {
+	return self.err
+}
+
+// Stop the stream and wait for its goroutines to finish. It is safe to
+// call Close more than once, and after Next() has returned false.
+func (self *ObjectIterator) Close() error {
+	if self.done {
+		return nil
+	}
+	self.done = true
+	self.cancelFunc()
+	for range self.objectChan {
+	}
+	if self.errorChan != nil {
+		for range self.errorChan {
+		}
+	}
+	return nil
+}
+
+// The object channel was closed; pick up any error and clean up
+func (self *ObjectIterator) _finish() {
+	if self.errorChan != nil {
+		for err := range self.errorChan {
+			if self.err == nil {
+				self.err = err
+			}
+		}
+	}
+	// If the caller canceled the context, the stream ended early
+	if self.err == nil {
+		self.err = self.ctx.Err()
+	}
+	self.done = true
+	self.cancelFunc()
+}
+
+// A pull-style alternative to the channels returned by
+// Tree.StreamBlobPathsUnique. Use it like an ObjectIterator.
+type BlobPathIterator struct {
+	cancelFunc   context.CancelFunc
+	blobPathChan <-chan *BlobPath
+	errorChan    <-chan error
+
+	current *BlobPath
+	err     error
+	done    bool
+}
+
+func (self *Tree) BlobPathsUnique(ctx context.Context, repo *Repo, sha1sSeen map[string]bool) *BlobPathIterator {
+	return self.BlobPathsUniqueWithProgress(ctx, repo, sha1sSeen, nil)
+}
+
+// Like BlobPathsUnique, but progress is called every second, and at the end of
+// the walk. ObjectsSeen counts tree entries, and ObjectsMatched counts BlobPaths.
+func (self *Tree) BlobPathsUniqueWithProgress(ctx context.Context, repo *Repo, sha1sSeen map[string]bool,
+	progress ProgressFunc) *BlobPathIterator {
+
+	ctx, cancelFunc := context.WithCancel(ctx)
+	tracker := newProgressTracker("tree-walk", progress, 0)
+	blobPathChan, errorChan := self._startBlobPathsUnique(ctx, repo, sha1sSeen, tracker)
+	return &BlobPathIterator{
+		cancelFunc:   cancelFunc,
+		blobPathChan: blobPathChan,
+		errorChan:    errorChan,
+	}
+}
+
+// Advance to the next BlobPath. Returns false when there are no more
+// BlobPaths, or after an error; check Err() to tell the difference.
+func (self *BlobPathIterator) Next() bool {
+	self.current = nil
+	if self.done {
+		return false
+	}
+	blobPath, ok := <-self.blobPathChan
+	if !ok {
+		// The error, if any, is sent before blobPathChan is closed
+		self.err = <-self.errorChan
+		self.done = true
+		self.cancelFunc()
+		return false
+	}
+	self.current = blobPath
+	return true
+}
+
+// The BlobPath found by the last call to Next()
+func (self *BlobPathIterator) BlobPath() *BlobPath {
+	return self.current
+}
+
+// The error which stopped the walk, if any
+func (self *BlobPathIterator) Err() error {
+	return self.err
+}
+
+// Stop the walk and wait for its goroutine to finish. It is safe to
+// call Close more than once, and after Next() has returned false.
+func (self *BlobPathIterator) Close() error {
+	if self.done {
+		return nil
+	}
+	self.done = true
+	self.cancelFunc()
+	for range self.blobPathChan {
+	}
+	for range self.errorChan {
+	}
+	return nil
+}
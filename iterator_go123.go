@@ -0,0 +1,41 @@
+//go:build go1.23
+
+package gitobjects
+
+import (
+	"iter"
+)
+
+// Range over the Objects with a for loop. The error, if any, is yielded
+// last, with a nil Object. The iterator is closed when the loop ends.
+//
+//	for obj, err := range repo.ObjectsOfType(ctx, "commit", 4).All() {
+//	}
+func (self *ObjectIterator) All() iter.Seq2[Object, error] {
+	return func(yield func(Object, error) bool) {
+		defer self.Close()
+		for self.Next() {
+			if !yield(self.Object(), nil) {
+				return
+			}
+		}
+		if self.Err() != nil {
+			yield(nil, self.Err())
+		}
+	}
+}
+
+// Range over the BlobPaths with a for loop, in the same way as ObjectIterator.All()
+func (self *BlobPathIterator) All() iter.Seq2[*BlobPath, error] {
+	return func(yield func(*BlobPath, error) bool) {
+		defer self.Close()
+		for self.Next() {
+			if !yield(self.BlobPath(), nil) {
+				return
+			}
+		}
+		if self.Err() != nil {
+			yield(nil, self.Err())
+		}
+	}
+}
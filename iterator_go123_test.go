@@ -0,0 +1,44 @@
+//go:build go1.23
+
+package gitobjects
+
+import (
+	"context"
+	. "gopkg.in/check.v1"
+	"strings"
+	"time"
+)
+
+func (s *MySuite) TestObjectIteratorRange(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNotes(c, repo, repoDir)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(3)*time.Second)
+	defer cancelFunc()
+
+	numFound := 0
+	for obj, err := range repo.ObjectsOfType(ctx, "commit", 1).All() {
+		c.Assert(err, IsNil)
+		c.Check(obj.Type(), Equals, "commit")
+		numFound++
+	}
+	c.Check(numFound, Equals, 2)
+
+	// Breaking out of the loop closes the iterator
+	for _, err := range repo.ObjectsOfType(ctx, "commit", 1).All() {
+		c.Assert(err, IsNil)
+		break
+	}
+
+	output, err := repo.CmdOutput([]string{"rev-parse", "HEAD^{tree}"})
+	c.Assert(err, IsNil)
+	tree, err := repo.GetTree(strings.TrimRight(string(output), "\n"))
+	c.Assert(err, IsNil)
+
+	var paths []string
+	for blobPath, err := range tree.BlobPathsUnique(ctx, repo, make(map[string]bool)).All() {
+		c.Assert(err, IsNil)
+		paths = append(paths, blobPath.Path)
+	}
+	c.Check(paths, DeepEquals, []string{"NOTES", "README"})
+}
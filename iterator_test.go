@@ -0,0 +1,79 @@
+package gitobjects
+
+import (
+	"context"
+	. "gopkg.in/check.v1"
+	"strings"
+	"time"
+)
+
+func (s *MySuite) TestObjectIterator(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	modifyReadmeAndPack(c, repo, repoDir)
+	addNotes(c, repo, repoDir)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(3)*time.Second)
+	defer cancelFunc()
+
+	iter := repo.ObjectsOfType(ctx, "commit", 2)
+	numFound := 0
+	for iter.Next() {
+		c.Check(iter.Object().Type(), Equals, "commit")
+		numFound++
+	}
+	c.Check(iter.Err(), IsNil)
+	c.Check(iter.Close(), IsNil)
+	c.Check(numFound, Equals, 3)
+
+	// Stopping early
+	iter = repo.ObjectsOfType(ctx, "blob", 1)
+	c.Assert(iter.Next(), Equals, true)
+	c.Check(iter.Close(), IsNil)
+	c.Check(iter.Next(), Equals, false)
+	c.Check(iter.Err(), IsNil)
+
+	// Bad arguments are reported through Err()
+	iter = repo.ObjectsOfType(ctx, "bogus", 1)
+	c.Check(iter.Next(), Equals, false)
+	c.Check(iter.Err(), ErrorMatches, "Unknown object type 'bogus'")
+}
+
+func (s *MySuite) TestObjectIteratorCanceled(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	modifyReadmeAndPack(c, repo, repoDir)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	iter := repo.ObjectsOfType(ctx, "commit", 1)
+	cancelFunc()
+
+	for iter.Next() {
+	}
+	c.Check(iter.Err(), Equals, context.Canceled)
+	c.Check(iter.Close(), IsNil)
+}
+
+func (s *MySuite) TestBlobPathIterator(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+
+	output, err := repo.CmdOutput([]string{"rev-parse", "HEAD^{tree}"})
+	c.Assert(err, IsNil)
+	tree, err := repo.GetTree(strings.TrimRight(string(output), "\n"))
+	c.Assert(err, IsNil)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(3)*time.Second)
+	defer cancelFunc()
+
+	var paths []string
+	iter := tree.BlobPathsUnique(ctx, repo, make(map[string]bool))
+	for iter.Next() {
+		paths = append(paths, iter.BlobPath().Path)
+	}
+	c.Check(iter.Err(), IsNil)
+	c.Check(paths, DeepEquals, []string{"README", "docs/api/index.md", "src/main.go"})
+
+	// Stop after the first one; Close must not hang
+	iter = tree.BlobPathsUnique(ctx, repo, make(map[string]bool))
+	c.Assert(iter.Next(), Equals, true)
+	c.Check(iter.Close(), IsNil)
+}
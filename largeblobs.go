@@ -0,0 +1,233 @@
+package gitobjects
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A blob found by FindLargeBlobs, with where and when it appeared in history
+type LargeBlob struct {
+	Sha1 string
+
+	// The decompressed size, and the space it takes in the object database
+	SizeBytes     int64
+	DiskSizeBytes int64
+
+	// Every path it was committed under, sorted
+	Paths []string
+
+	// The oldest and newest commits whose trees contain the blob; "" if no
+	// commit reachable from a ref contains it
+	FirstCommit string
+	LastCommit  string
+}
+
+// Find the largest blobs in the object database, and for each one the paths it
+// was committed under, and the first and last commits that contain it: what
+// needs to be known before rewriting history to remove it. If topN is positive,
+// at most topN blobs are returned; if minSizeBytes is positive, only blobs at
+// least that big are returned. If neither is, the 10 largest are returned.
+// The result is sorted with the largest blob first.
+func (self *Repo) FindLargeBlobs(ctx context.Context, topN int, minSizeBytes int64) ([]*LargeBlob, error) {
+	if topN <= 0 && minSizeBytes <= 0 {
+		topN = defaultAnalysisTopN
+	}
+
+	options := DefaultStreamOptions()
+	options.InstantiateObjects = false
+	options.Filter = func(obj Object) bool {
+		size, _ := obj.(*Blob)._cachedSize()
+		return int64(size) >= minSizeBytes
+	}
+	largest := newAnalysisTopN(topN)
+	objectChan, errorChan := self.StreamObjectsOfTypes(ctx, []string{"blob"}, options)
+	for obj := range objectChan {
+		size, _ := obj.(*Blob)._cachedSize()
+		largest.Add("", &AnalysisItem{Sha1: obj.Sha1(), Value: int64(size)})
+	}
+	err := <-errorChan
+	if err != nil {
+		return nil, err
+	}
+	// A canceled stream ends early, without an error
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	items := largest.Items()
+	if len(items) == 0 {
+		return []*LargeBlob{}, nil
+	}
+	largeBlobs := make([]*LargeBlob, len(items))
+	bySha1 := make(map[string]*LargeBlob)
+	sha1s := make([]string, len(items))
+	for i, item := range items {
+		largeBlobs[i] = &LargeBlob{
+			Sha1:      item.Sha1,
+			SizeBytes: item.Value,
+			Paths:     []string{},
+		}
+		bySha1[item.Sha1] = largeBlobs[i]
+		sha1s[i] = item.Sha1
+	}
+
+	diskSizes, err := self._diskSizes(sha1s)
+	if err != nil {
+		return nil, err
+	}
+	for _, largeBlob := range largeBlobs {
+		largeBlob.DiskSizeBytes = diskSizes[largeBlob.Sha1]
+	}
+
+	err = self._findLargeBlobCommits(ctx, bySha1)
+	if err != nil {
+		return nil, err
+	}
+	return largeBlobs, nil
+}
+
+// Fill in the paths and commits of the large blobs by visiting every
+// commit, oldest first
+func (self *Repo) _findLargeBlobCommits(ctx context.Context, bySha1 map[string]*LargeBlob) error {
+	// "<commit sha1> <tree sha1>" lines
+	output, err := self.CmdOutput([]string{"log", "--all", "--reverse", "--date-order", "--format=%H %T"})
+	if err != nil {
+		return errors.Wrap(err, "Listing commits")
+	}
+
+	finder := &largeBlobFinder{
+		repo:      self,
+		bySha1:    bySha1,
+		blobPaths: make(map[string][]*BlobPath),
+	}
+	paths := make(map[string]map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			return errors.Errorf("Got unexpected line from git log: %s", scanner.Text())
+		}
+		commitSha1, treeSha1 := fields[0], fields[1]
+
+		blobPaths, err := finder.BlobPaths(treeSha1)
+		if err != nil {
+			return errors.Wrapf(err, "Reading tree of commit %s", commitSha1)
+		}
+		for _, blobPath := range blobPaths {
+			largeBlob := bySha1[blobPath.Blob.Sha1()]
+			if largeBlob.FirstCommit == "" {
+				largeBlob.FirstCommit = commitSha1
+				paths[largeBlob.Sha1] = make(map[string]bool)
+			}
+			largeBlob.LastCommit = commitSha1
+			paths[largeBlob.Sha1][blobPath.Path] = true
+		}
+	}
+
+	for sha1, blobPaths := range paths {
+		largeBlob := bySha1[sha1]
+		for path := range blobPaths {
+			largeBlob.Paths = append(largeBlob.Paths, path)
+		}
+		sort.Strings(largeBlob.Paths)
+	}
+	return nil
+}
+
+// Return the compressed size of each object, as stored in a pack
+// or a loose object file
+func (self *Repo) _diskSizes(sha1s []string) (map[string]int64, error) {
+	cmd := self.Command([]string{"cat-file", "--batch-check=%(objectname) %(objectsize:disk)"})
+	cmd.Stdin = strings.NewReader(strings.Join(sha1s, "\n") + "\n")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "Calling git cat-file --batch-check for disk sizes")
+	}
+
+	diskSizes := make(map[string]int64)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[1] == "missing" {
+			return nil, errors.Errorf("Got unexpected line from git cat-file --batch-check: %s",
+				scanner.Text())
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Parsing disk size from git cat-file --batch-check: %s",
+				scanner.Text())
+		}
+		diskSizes[fields[0]] = size
+	}
+	return diskSizes, nil
+}
+
+// Finds where the large blobs are in trees, remembering the answer for
+// each tree, as most trees are shared by many commits
+type largeBlobFinder struct {
+	repo *Repo
+
+	// The blobs to look for
+	bySha1 map[string]*LargeBlob
+
+	// Key = tree sha1, Value = the large blobs below it, with paths
+	// relative to it
+	blobPaths map[string][]*BlobPath
+}
+
+func (self *largeBlobFinder) BlobPaths(treeSha1 string) ([]*BlobPath, error) {
+	if blobPaths, has := self.blobPaths[treeSha1]; has {
+		return blobPaths, nil
+	}
+
+	// Subtrees are not instantiated, as each is visited on its own
+	tree, err := self.repo._cachedTree(treeSha1)
+	if err != nil {
+		return nil, err
+	}
+	err = tree._instantiate(self.repo, false)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := tree.Entries(self.repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobPaths []*BlobPath
+	for _, entry := range entries {
+		switch entry.Type() {
+		case "blob":
+			if _, has := self.bySha1[entry.sha1]; has {
+				blobPaths = append(blobPaths, &BlobPath{
+					Blob: entry.blob,
+					Path: entry.name,
+				})
+			}
+		case "tree":
+			subtreeBlobPaths, err := self.BlobPaths(entry.sha1)
+			if err != nil {
+				return nil, err
+			}
+			for _, blobPath := range subtreeBlobPaths {
+				blobPaths = append(blobPaths, &BlobPath{
+					Blob: blobPath.Blob,
+					Path: filepath.Join(entry.name, blobPath.Path),
+				})
+			}
+		case "commit":
+			// Submodule contents are in another repository
+		}
+	}
+	self.blobPaths[treeSha1] = blobPaths
+	return blobPaths, nil
+}
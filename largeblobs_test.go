@@ -0,0 +1,90 @@
+package gitobjects
+
+import (
+	"context"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func (s *MySuite) TestFindLargeBlobs(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+
+	runGit := func(argv ...string) {
+		cmd := repo.Command(argv)
+		cmd.Dir = repoDir
+		c.Assert(cmd.Run(), IsNil, Commentf("git %v", argv))
+	}
+	revParse := func(revision string) string {
+		sha1, err := repo.ResolveRevision(revision)
+		c.Assert(err, IsNil)
+		return sha1
+	}
+
+	// Copy docs/api/index.md, then delete both copies
+	err := ioutil.WriteFile(filepath.Join(repoDir, "copy.md"), []byte("docs/api/index.md\n"), 0666)
+	c.Assert(err, IsNil)
+	runGit("add", "copy.md")
+	runGit("commit", "-m", "Copy index.md")
+	runGit("rm", "-r", "-q", "copy.md", "docs")
+	runGit("commit", "-m", "Remove docs")
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	defer cancelFunc()
+
+	largeBlobs, err := repo.FindLargeBlobs(ctx, 1, 0)
+	c.Assert(err, IsNil)
+	c.Assert(len(largeBlobs), Equals, 1)
+	indexBlob := largeBlobs[0]
+	c.Check(indexBlob.Sha1, Equals, revParse("HEAD~1:copy.md"))
+	c.Check(indexBlob.SizeBytes, Equals, int64(18))
+	c.Check(indexBlob.DiskSizeBytes > 0, Equals, true)
+	c.Check(indexBlob.Paths, DeepEquals, []string{"copy.md", "docs/api/index.md"})
+	c.Check(indexBlob.FirstCommit, Equals, revParse("HEAD~2"))
+	c.Check(indexBlob.LastCommit, Equals, revParse("HEAD~1"))
+
+	largeBlobs, err = repo.FindLargeBlobs(ctx, 0, 12)
+	c.Assert(err, IsNil)
+	c.Assert(len(largeBlobs), Equals, 2)
+	c.Check(largeBlobs[0].Sha1, Equals, indexBlob.Sha1)
+	c.Check(largeBlobs[1].Sha1, Equals, revParse("HEAD:src/main.go"))
+	c.Check(largeBlobs[1].Paths, DeepEquals, []string{"src/main.go"})
+	c.Check(largeBlobs[1].FirstCommit, Equals, revParse("HEAD~2"))
+	c.Check(largeBlobs[1].LastCommit, Equals, revParse("HEAD"))
+}
+
+func (s *MySuite) TestFindLargeBlobsLooseAndPacked(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+	indexSha1 := runGitIn(c, repo, repoDir, "rev-parse", "HEAD:docs/api/index.md")
+	mainSha1 := runGitIn(c, repo, repoDir, "rev-parse", "HEAD:src/main.go")
+
+	// Pack the largest blob, and put its loose copy back
+	loosePath := filepath.Join(repo.GitDir(), "objects", indexSha1[:2], indexSha1[2:])
+	contents, err := ioutil.ReadFile(loosePath)
+	c.Assert(err, IsNil)
+	runGitIn(c, repo, repoDir, "gc", "-q")
+	_, err = os.Stat(loosePath)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(os.MkdirAll(filepath.Dir(loosePath), 0777), IsNil)
+	c.Assert(ioutil.WriteFile(loosePath, contents, 0444), IsNil)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	defer cancelFunc()
+
+	// The stream sends it once, so it is only reported once, with its paths
+	// and commits
+	largeBlobs, err := repo.FindLargeBlobs(ctx, 2, 0)
+	c.Assert(err, IsNil)
+	c.Assert(len(largeBlobs), Equals, 2)
+	c.Check(largeBlobs[0].Sha1, Equals, indexSha1)
+	c.Check(largeBlobs[0].Paths, DeepEquals, []string{"docs/api/index.md"})
+	c.Check(largeBlobs[1].Sha1, Equals, mainSha1)
+	c.Check(largeBlobs[1].Paths, DeepEquals, []string{"src/main.go"})
+	for _, largeBlob := range largeBlobs {
+		c.Check(largeBlob.FirstCommit, Equals, runGitIn(c, repo, repoDir, "rev-parse", "HEAD"))
+	}
+}
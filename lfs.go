@@ -0,0 +1,202 @@
+package gitobjects
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A Git LFS pointer file, which is committed in place of a large file
+// that is kept in LFS storage
+type LFSPointer struct {
+	// The sha256 of the large file, in hex
+	Oid string
+
+	// The size of the large file
+	SizeBytes int64
+}
+
+// Git LFS does not treat bigger blobs as pointers
+const lfsPointerMaxSize = 1024
+
+// The smallest possible pointer: the version line, the oid line with its
+// 64 hex digits, and a one-digit size
+const lfsPointerMinSize = 43 + 76 + 7
+
+// The first line of a pointer; the second is what early versions of
+// Git LFS wrote
+var lfsPointerVersions = []string{
+	"version https://git-lfs.github.com/spec/v1",
+	"version https://hawser.github.com/spec/v1",
+}
+
+// The error for a pointer whose large file is not in the local LFS storage
+type LFSObjectMissingError struct {
+	Oid string
+}
+
+func (self *LFSObjectMissingError) Error() string {
+	return fmt.Sprintf("LFS object %s is not in the local LFS storage", self.Oid)
+}
+
+// Is the error, or its cause, an LFSObjectMissingError?
+func IsLFSObjectMissing(err error) bool {
+	_, ok := errors.Cause(err).(*LFSObjectMissingError)
+	return ok
+}
+
+// Parse the contents of a blob as a Git LFS pointer, as strictly as Git LFS
+// does. Returns nil if it is not one.
+func ParseLFSPointer(contents []byte) *LFSPointer {
+	if len(contents) < lfsPointerMinSize || len(contents) > lfsPointerMaxSize ||
+		contents[len(contents)-1] != '\n' {
+		return nil
+	}
+	lines := strings.Split(string(contents[:len(contents)-1]), "\n")
+	knownVersion := false
+	for _, version := range lfsPointerVersions {
+		knownVersion = knownVersion || lines[0] == version
+	}
+	if !knownVersion {
+		return nil
+	}
+
+	// The other keys are sorted: "ext-<priority>-<name>" lines for each
+	// extension that cleaned the file, then oid and size
+	pointer := &LFSPointer{SizeBytes: -1}
+	previousKey := ""
+	for _, line := range lines[1:] {
+		space := strings.IndexByte(line, ' ')
+		if space <= 0 {
+			return nil
+		}
+		key, value := line[:space], line[space+1:]
+		if key <= previousKey {
+			return nil
+		}
+		previousKey = key
+		switch {
+		case key == "oid":
+			if !strings.HasPrefix(value, "sha256:") || !_isLowerHex(value[len("sha256:"):], 64) {
+				return nil
+			}
+			pointer.Oid = value[len("sha256:"):]
+		case key == "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 || value[0] == '+' {
+				return nil
+			}
+			pointer.SizeBytes = size
+		case strings.HasPrefix(key, "ext-"):
+		default:
+			return nil
+		}
+	}
+	if pointer.Oid == "" || pointer.SizeBytes < 0 {
+		return nil
+	}
+	return pointer
+}
+
+func _isLowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !_isDigit(s[i]) && !(s[i] >= 'a' && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Where the large file of a pointer is kept in the local LFS storage, which
+// every work tree shares, as Git LFS keeps it in the common git dir
+func (self *Repo) LFSObjectPath(pointer *LFSPointer) (string, error) {
+	commonDir, err := self._commonDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(commonDir, "lfs", "objects", pointer.Oid[0:2], pointer.Oid[2:4], pointer.Oid), nil
+}
+
+// Is the large file of a pointer in the local LFS storage, with the right size?
+func (self *Repo) HasLFSObject(pointer *LFSPointer) (bool, error) {
+	path, err := self.LFSObjectPath(pointer)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrapf(err, "Checking for LFS object %s", pointer.Oid)
+	}
+	return info.Mode().IsRegular() && info.Size() == pointer.SizeBytes, nil
+}
+
+// Return the pointer if the blob is a Git LFS pointer, or nil. Only blobs
+// small enough to be pointers are read.
+func (self *Blob) LFSPointer(repo *Repo) (*LFSPointer, error) {
+	size, err := self.DecompressedSizeBytes(repo)
+	if err != nil {
+		return nil, err
+	}
+	if size < lfsPointerMinSize || size > lfsPointerMaxSize {
+		return nil, nil
+	}
+	contents, err := self.Contents(repo)
+	if err != nil {
+		return nil, err
+	}
+	return ParseLFSPointer(contents), nil
+}
+
+// The size of the file the blob stands for: the size of the large file
+// if it is a Git LFS pointer, or else its own size
+func (self *Blob) LogicalSizeBytes(repo *Repo) (int64, error) {
+	pointer, err := self.LFSPointer(repo)
+	if err != nil {
+		return 0, err
+	}
+	if pointer != nil {
+		return pointer.SizeBytes, nil
+	}
+	size, err := self.DecompressedSizeBytes(repo)
+	return int64(size), err
+}
+
+// Read the contents of the file the blob stands for: the large file from
+// the local LFS storage if it is a Git LFS pointer, or else the blob
+// itself. If the large file is not there, the error is an
+// *LFSObjectMissingError.
+func (self *Blob) LogicalContents(repo *Repo) ([]byte, error) {
+	contents, err := self.Contents(repo)
+	if err != nil {
+		return nil, err
+	}
+	pointer := ParseLFSPointer(contents)
+	if pointer == nil {
+		return contents, nil
+	}
+	has, err := repo.HasLFSObject(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, &LFSObjectMissingError{Oid: pointer.Oid}
+	}
+	path, err := repo.LFSObjectPath(pointer)
+	if err != nil {
+		return nil, err
+	}
+	contents, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Reading LFS object %s", pointer.Oid)
+	}
+	return contents, nil
+}
@@ -0,0 +1,153 @@
+package gitobjects
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+func lfsPointerFor(contents string) string {
+	sum := sha256.Sum256([]byte(contents))
+	return fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n",
+		hex.EncodeToString(sum[:]), len(contents))
+}
+
+func (s *MySuite) TestParseLFSPointer(c *C) {
+	oid := strings.Repeat("ab", 32)
+	pointer := ParseLFSPointer([]byte(lfsPointerFor("large file\n")))
+	c.Assert(pointer, NotNil)
+	c.Check(pointer.SizeBytes, Equals, int64(11))
+
+	valid := "version https://git-lfs.github.com/spec/v1\n" +
+		"ext-0-foo sha256:" + oid + "\n" +
+		"oid sha256:" + oid + "\n" +
+		"size 12345\n"
+	pointer = ParseLFSPointer([]byte(valid))
+	c.Assert(pointer, NotNil)
+	c.Check(*pointer, Equals, LFSPointer{Oid: oid, SizeBytes: 12345})
+
+	for _, contents := range []string{
+		"",
+		"not a pointer\n",
+		strings.TrimSuffix(valid, "\n"),
+		strings.Replace(valid, "spec/v1", "spec/v2", 1),
+		strings.Replace(valid, "sha256:"+oid+"\nsize", "sha256:"+strings.ToUpper(oid)+"\nsize", 1),
+		strings.Replace(valid, "sha256:"+oid+"\nsize", "md5:"+oid+"\nsize", 1),
+		strings.Replace(valid, "size 12345", "size -1", 1),
+		strings.Replace(valid, "size 12345", "size +1", 1),
+		strings.Replace(valid, "size 12345\n", "", 1),
+		strings.Replace(valid, "size 12345\n", "size 12345\nsize 1\n", 1),
+		strings.Replace(valid, "oid sha256:"+oid+"\nsize 12345\n", "size 12345\noid sha256:"+oid+"\n", 1),
+		strings.Replace(valid, "ext-0-foo", "other", 1),
+		valid + strings.Repeat("x", 1024),
+	} {
+		c.Check(ParseLFSPointer([]byte(contents)), IsNil, Commentf("%q", contents))
+	}
+}
+
+func (s *MySuite) TestLFSPointerBlobs(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	present := strings.Repeat("present\n", 100)
+	writeFiles(c, repoDir, map[string]string{
+		"present.bin": lfsPointerFor(present),
+		"missing.bin": lfsPointerFor(strings.Repeat("missing\n", 1000)),
+	})
+	runGitIn(c, repo, repoDir, "add", ".")
+	runGitIn(c, repo, repoDir, "commit", "-q", "-m", "Add LFS pointers")
+
+	// Only one of the large files is in the local LFS storage
+	pointer := ParseLFSPointer([]byte(lfsPointerFor(present)))
+	c.Assert(pointer, NotNil)
+	objectPath, err := repo.LFSObjectPath(pointer)
+	c.Assert(err, IsNil)
+	writeFiles(c, filepath.Dir(objectPath), map[string]string{filepath.Base(objectPath): present})
+
+	blobFor := func(path string) *Blob {
+		sha1, err := repo.ResolveRevision("HEAD:" + path)
+		c.Assert(err, IsNil)
+		blob, err := repo.GetBlob(sha1)
+		c.Assert(err, IsNil)
+		return blob
+	}
+	blob := blobFor("present.bin")
+	blobPointer, err := blob.LFSPointer(repo)
+	c.Assert(err, IsNil)
+	c.Check(*blobPointer, Equals, *pointer)
+	size, err := blob.LogicalSizeBytes(repo)
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, int64(len(present)))
+	contents, err := blob.LogicalContents(repo)
+	c.Assert(err, IsNil)
+	c.Check(string(contents), Equals, present)
+
+	blob = blobFor("missing.bin")
+	size, err = blob.LogicalSizeBytes(repo)
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, int64(8000))
+	_, err = blob.LogicalContents(repo)
+	c.Check(IsLFSObjectMissing(err), Equals, true)
+
+	// Other blobs are themselves
+	blob = blobFor("README")
+	blobPointer, err = blob.LFSPointer(repo)
+	c.Assert(err, IsNil)
+	c.Check(blobPointer, IsNil)
+	contents, err = blob.LogicalContents(repo)
+	c.Assert(err, IsNil)
+	c.Check(string(contents), Equals, "test\n")
+
+	// A corrupt LFS object is as good as missing
+	c.Assert(ioutil.WriteFile(objectPath, []byte("truncated"), 0666), IsNil)
+	has, err := repo.HasLFSObject(pointer)
+	c.Assert(err, IsNil)
+	c.Check(has, Equals, false)
+	c.Assert(ioutil.WriteFile(objectPath, []byte(present), 0666), IsNil)
+
+	report, err := repo.Analyze(context.Background(), 0)
+	c.Assert(err, IsNil)
+	c.Check(report.LFSPointers, Equals, ObjectTypeStats{Count: 2, SizeBytes: 8800})
+	c.Check(report.MissingLFSObjects, Equals, ObjectTypeStats{Count: 1, SizeBytes: 8000})
+	c.Assert(len(report.LargestLFSObjects), Equals, 2)
+	c.Check(report.LargestLFSObjects[0].Sha1, Equals, blobFor("missing.bin").Sha1())
+	c.Check(report.LargestLFSObjects[1].Value, Equals, int64(800))
+	c.Assert(len(report.LargestMissingLFSObjects), Equals, 1)
+	c.Check(report.LargestMissingLFSObjects[0].Value, Equals, int64(8000))
+}
+
+func (s *MySuite) TestWorktreeLFSObjects(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	large := strings.Repeat("large\n", 100)
+	writeFiles(c, repoDir, map[string]string{"large.bin": lfsPointerFor(large)})
+	runGitIn(c, repo, repoDir, "add", ".")
+	runGitIn(c, repo, repoDir, "commit", "-q", "-m", "Add an LFS pointer")
+	pointer := ParseLFSPointer([]byte(lfsPointerFor(large)))
+	c.Assert(pointer, NotNil)
+	objectPath, err := repo.LFSObjectPath(pointer)
+	c.Assert(err, IsNil)
+	writeFiles(c, filepath.Dir(objectPath), map[string]string{filepath.Base(objectPath): large})
+
+	// The LFS storage is shared by every work tree
+	worktreeDir := filepath.Join(c.MkDir(), "worktree")
+	runGitIn(c, repo, repoDir, "worktree", "add", "-q", "-b", "in-worktree", worktreeDir)
+	worktree, err := NewRepo(worktreeDir)
+	c.Assert(err, IsNil)
+	worktreePath, err := worktree.LFSObjectPath(pointer)
+	c.Assert(err, IsNil)
+	c.Check(worktreePath, Equals, objectPath)
+	has, err := worktree.HasLFSObject(pointer)
+	c.Assert(err, IsNil)
+	c.Check(has, Equals, true)
+
+	sha1, err := worktree.ResolveRevision("HEAD:large.bin")
+	c.Assert(err, IsNil)
+	blob, err := worktree.GetBlob(sha1)
+	c.Assert(err, IsNil)
+	contents, err := blob.LogicalContents(worktree)
+	c.Assert(err, IsNil)
+	c.Check(string(contents), Equals, large)
+}
@@ -0,0 +1,70 @@
+package gitobjects
+
+import (
+	"github.com/pkg/errors"
+	"os"
+)
+
+// A file that is replaced the way git replaces it: the new contents are
+// written to "<path>.lock", which is created exclusively so that only one
+// writer can hold it, and then renamed over the file.
+type lockFile struct {
+	path     string
+	lockPath string
+	file     *os.File
+}
+
+// Take the lock for a file. The lock must be released with Commit or Rollback.
+func newLockFile(path string, perm os.FileMode) (*lockFile, error) {
+	lockPath := path + ".lock"
+	file, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if os.IsExist(err) {
+		return nil, errors.Errorf("Unable to create '%s': another git process seems to be running", lockPath)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Creating %s", lockPath)
+	}
+	return &lockFile{
+		path:     path,
+		lockPath: lockPath,
+		file:     file,
+	}, nil
+}
+
+func (self *lockFile) Write(data []byte) (int, error) {
+	n, err := self.file.Write(data)
+	if err != nil {
+		return n, errors.Wrapf(err, "Writing %s", self.lockPath)
+	}
+	return n, nil
+}
+
+// Replace the file with what has been written, and release the lock
+func (self *lockFile) Commit() error {
+	err := self.file.Sync()
+	if err != nil {
+		self.Rollback()
+		return errors.Wrapf(err, "Syncing %s", self.lockPath)
+	}
+	err = self.file.Close()
+	if err != nil {
+		os.Remove(self.lockPath)
+		return errors.Wrapf(err, "Closing %s", self.lockPath)
+	}
+	err = os.Rename(self.lockPath, self.path)
+	if err != nil {
+		os.Remove(self.lockPath)
+		return errors.Wrapf(err, "Renaming %s to %s", self.lockPath, self.path)
+	}
+	return nil
+}
+
+// Release the lock, leaving the file as it was. It is safe to call
+// Rollback after Commit has failed.
+func (self *lockFile) Rollback() error {
+	self.file.Close()
+	err := os.Remove(self.lockPath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "Removing %s", self.lockPath)
+	}
+	return nil
+}
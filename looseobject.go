@@ -0,0 +1,64 @@
+package gitobjects
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Write an object to the object database as a loose object, without running
+// git, and return its sha1. Nothing is written if the object already exists
+// as a loose object.
+func (self *Repo) _writeLooseObject(objectType string, contents []byte) (string, error) {
+	hasher := sha1Hasher(objectType, int64(len(contents)))
+	hasher.Write(contents)
+	sha1 := hex.EncodeToString(hasher.Sum(nil))
+
+	objectDir := filepath.Join(self.gitDir, "objects", sha1[:2])
+	objectFile := filepath.Join(objectDir, sha1[2:])
+	if _, err := os.Stat(objectFile); err == nil {
+		return sha1, nil
+	}
+
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write(sha1HeaderBytes(objectType, int64(len(contents))))
+	writer.Write(contents)
+	err := writer.Close()
+	if err != nil {
+		return "", errors.Wrapf(err, "Compressing object %s", sha1)
+	}
+
+	// Written to a temporary file and renamed, so that no reader sees
+	// a partial object
+	err = os.MkdirAll(objectDir, 0777)
+	if err != nil {
+		return "", errors.Wrapf(err, "Creating %s", objectDir)
+	}
+	tmpFile, err := ioutil.TempFile(objectDir, "tmp_obj_")
+	if err != nil {
+		return "", errors.Wrapf(err, "Creating temporary object file in %s", objectDir)
+	}
+	_, err = tmpFile.Write(compressed.Bytes())
+	if err == nil {
+		err = tmpFile.Close()
+	} else {
+		tmpFile.Close()
+	}
+	if err == nil {
+		// Loose objects are read-only, as git makes them
+		err = os.Chmod(tmpFile.Name(), 0444)
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), objectFile)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", errors.Wrapf(err, "Writing object %s", sha1)
+	}
+	return sha1, nil
+}
@@ -0,0 +1,188 @@
+package gitobjects
+
+import (
+	"container/list"
+	"sync"
+)
+
+// A cache of parsed Objects (Trees, Commits, Tags and Blobs with their sizes),
+// keyed by sha1. A Repo has one ObjectCache, so a single eviction policy
+// applies to every type of object.
+type ObjectCache interface {
+	// Returns the cached Object, if present
+	Get(sha1 string) (Object, bool)
+
+	// Stores an Object, replacing any existing one
+	Set(sha1 string, obj Object)
+
+	// Returns the cached Object if present; otherwise stores and returns obj
+	GetOrSet(sha1 string, obj Object) Object
+
+	// Records the estimated size of a cached Object, once it is known
+	Resize(sha1 string, bytes int64)
+
+	Stats() CacheStats
+}
+
+// Counters describing how well an ObjectCache is doing
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Bytes     int64
+}
+
+// An entry in the LRU list
+type objectCacheItem struct {
+	sha1  string
+	obj   Object
+	bytes int64
+}
+
+// An ObjectCache with optional LRU eviction. If maxEntries or maxBytes is
+// non-zero, the least-recently-used objects are evicted to stay within the
+// budget. Evicting an object only removes it from the cache; any parent Tree
+// or Commit that still points to it keeps it alive, so eviction is always safe.
+type objectCacheConcurrentSafe struct {
+	sync.Mutex
+	// Key = sha1, Value = element in lru, whose Value is an *objectCacheItem
+	objectCache map[string]*list.Element
+
+	// Front = most recently used
+	lru *list.List
+
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// Create an object cache with no limits
+func NewObjectCache() *objectCacheConcurrentSafe {
+	return NewBoundedObjectCache(0, 0)
+}
+
+// Create an object cache with no limits.
+//
+// Deprecated: the tree cache is now the object cache; use NewObjectCache.
+func NewTreeCache() *objectCacheConcurrentSafe {
+	return NewObjectCache()
+}
+
+// Create an object cache which holds at most maxEntries objects and at most
+// maxBytes of (estimated) object data. A limit of 0 means "no limit".
+func NewBoundedObjectCache(maxEntries int, maxBytes int64) *objectCacheConcurrentSafe {
+	return &objectCacheConcurrentSafe{
+		objectCache: make(map[string]*list.Element),
+		lru:         list.New(),
+		maxEntries:  maxEntries,
+		maxBytes:    maxBytes,
+	}
+}
+
+func (self *objectCacheConcurrentSafe) Has(sha1 string) bool {
+	self.Lock()
+	defer self.Unlock()
+	_, has := self.objectCache[sha1]
+	return has
+}
+
+func (self *objectCacheConcurrentSafe) Get(sha1 string) (Object, bool) {
+	self.Lock()
+	defer self.Unlock()
+	elem, has := self.objectCache[sha1]
+	if !has {
+		self.misses++
+		return nil, false
+	}
+	self.hits++
+	self.lru.MoveToFront(elem)
+	return elem.Value.(*objectCacheItem).obj, true
+}
+
+func (self *objectCacheConcurrentSafe) Set(sha1 string, obj Object) {
+	self.Lock()
+	defer self.Unlock()
+	self._set(sha1, obj)
+}
+
+func (self *objectCacheConcurrentSafe) GetOrSet(sha1 string, obj Object) Object {
+	self.Lock()
+	defer self.Unlock()
+	elem, has := self.objectCache[sha1]
+	if has {
+		self.hits++
+		self.lru.MoveToFront(elem)
+		return elem.Value.(*objectCacheItem).obj
+	} else {
+		self.misses++
+		self._set(sha1, obj)
+		return obj
+	}
+}
+
+// Record the estimated size of an object, once it is known. Objects are usually
+// placed in the cache before they are instantiated, so their size is not
+// known until later. Objects that are no longer in the cache are ignored.
+func (self *objectCacheConcurrentSafe) Resize(sha1 string, bytes int64) {
+	self.Lock()
+	defer self.Unlock()
+	elem, has := self.objectCache[sha1]
+	if !has {
+		return
+	}
+	item := elem.Value.(*objectCacheItem)
+	self.bytes += bytes - item.bytes
+	item.bytes = bytes
+	self._evict()
+}
+
+func (self *objectCacheConcurrentSafe) Stats() CacheStats {
+	self.Lock()
+	defer self.Unlock()
+	return CacheStats{
+		Hits:      self.hits,
+		Misses:    self.misses,
+		Evictions: self.evictions,
+		Entries:   self.lru.Len(),
+		Bytes:     self.bytes,
+	}
+}
+
+// The lock must be held
+func (self *objectCacheConcurrentSafe) _set(sha1 string, obj Object) {
+	if elem, has := self.objectCache[sha1]; has {
+		item := elem.Value.(*objectCacheItem)
+		item.obj = obj
+		self.lru.MoveToFront(elem)
+		return
+	}
+	self.objectCache[sha1] = self.lru.PushFront(&objectCacheItem{
+		sha1: sha1,
+		obj:  obj,
+	})
+	self._evict()
+}
+
+// Drop least-recently-used objects until we are within budget. The most
+// recently used object is never evicted, even if it alone is over budget.
+// The lock must be held.
+func (self *objectCacheConcurrentSafe) _evict() {
+	for self.lru.Len() > 1 {
+		overEntries := self.maxEntries > 0 && self.lru.Len() > self.maxEntries
+		overBytes := self.maxBytes > 0 && self.bytes > self.maxBytes
+		if !overEntries && !overBytes {
+			return
+		}
+		elem := self.lru.Back()
+		item := elem.Value.(*objectCacheItem)
+		self.lru.Remove(elem)
+		delete(self.objectCache, item.sha1)
+		self.bytes -= item.bytes
+		self.evictions++
+	}
+}
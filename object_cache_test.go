@@ -0,0 +1,178 @@
+package gitobjects
+
+import (
+	"context"
+	. "gopkg.in/check.v1"
+	"strings"
+	"time"
+)
+
+func (s *MySuite) TestObjectCache(c *C) {
+
+	cache := NewObjectCache()
+
+	c.Check(cache.Has("foo"), Equals, false)
+
+	xTree := &Tree{
+		sha1: "123",
+	}
+	cache.Set("x", xTree)
+	c.Check(cache.Has("x"), Equals, true)
+
+	retrievedTree, has := cache.Get("x")
+	c.Check(retrievedTree, Equals, Object(xTree))
+	c.Check(has, Equals, true)
+
+	retrievedTree, has = cache.Get("y")
+	c.Check(has, Equals, false)
+	c.Check(retrievedTree, IsNil)
+}
+
+func (s *MySuite) TestObjectCacheEvictsByEntries(c *C) {
+
+	cache := NewBoundedObjectCache(2, 0)
+
+	cache.Set("a", &Tree{sha1: "a"})
+	cache.Set("b", &Tree{sha1: "b"})
+
+	// Touch "a" so that "b" is the least recently used
+	_, has := cache.Get("a")
+	c.Check(has, Equals, true)
+
+	cache.Set("c", &Tree{sha1: "c"})
+	c.Check(cache.Has("a"), Equals, true)
+	c.Check(cache.Has("b"), Equals, false)
+	c.Check(cache.Has("c"), Equals, true)
+
+	stats := cache.Stats()
+	c.Check(stats.Entries, Equals, 2)
+	c.Check(stats.Evictions, Equals, uint64(1))
+	c.Check(stats.Hits, Equals, uint64(1))
+}
+
+func (s *MySuite) TestObjectCacheEvictsByBytes(c *C) {
+
+	cache := NewBoundedObjectCache(0, 100)
+
+	cache.Set("a", &Tree{sha1: "a"})
+	cache.Resize("a", 60)
+	cache.Set("b", &Tree{sha1: "b"})
+	c.Check(cache.Stats().Bytes, Equals, int64(60))
+
+	cache.Resize("b", 60)
+	c.Check(cache.Has("a"), Equals, false)
+	c.Check(cache.Has("b"), Equals, true)
+	c.Check(cache.Stats().Bytes, Equals, int64(60))
+
+	// Resizing something that was evicted is harmless
+	cache.Resize("a", 1000)
+	c.Check(cache.Stats().Bytes, Equals, int64(60))
+}
+
+func (s *MySuite) TestObjectCacheStatsCountMisses(c *C) {
+
+	cache := NewObjectCache()
+
+	_, has := cache.Get("x")
+	c.Check(has, Equals, false)
+	tree := &Tree{sha1: "x"}
+	c.Check(cache.GetOrSet("x", tree), Equals, Object(tree))
+	c.Check(cache.GetOrSet("x", &Tree{sha1: "x"}), Equals, Object(tree))
+
+	stats := cache.Stats()
+	c.Check(stats.Misses, Equals, uint64(2))
+	c.Check(stats.Hits, Equals, uint64(1))
+}
+
+func (s *MySuite) TestBoundedObjectCacheWhileStreaming(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	modifyReadmeAndPack(c, repo, repoDir)
+	addNotes(c, repo, repoDir)
+
+	repo.SetObjectCache(NewBoundedObjectCache(1, 0))
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(3)*time.Second)
+	defer cancelFunc()
+	objectChan, errorChan := repo.StreamObjectsOfType(ctx, "commit", 1)
+
+	numFound := 0
+	for obj := range objectChan {
+		commit := obj.(*Commit)
+		c.Check(commit.Tree().instantiated, Equals, true)
+		numFound++
+	}
+	for err := range errorChan {
+		c.Errorf("Received error: %s", err)
+	}
+
+	c.Check(numFound, Equals, 3)
+	stats := repo.CacheStats()
+	c.Check(stats.Entries, Equals, 1)
+	c.Check(stats.Evictions, Equals, uint64(2))
+}
+
+func (s *MySuite) TestObjectCacheHoldsCommitsAndBlobSizes(c *C) {
+	repo, _ := s.setupRepoWithReadme(c)
+
+	output, err := repo.CmdOutput([]string{"rev-parse", "HEAD"})
+	c.Assert(err, IsNil)
+	headSha1 := strings.TrimRight(string(output), "\n")
+
+	commit, err := repo.GetCommit(headSha1)
+	c.Assert(err, IsNil)
+	c.Check(commit.Message(), Equals, "Add README")
+
+	// The second lookup comes from the cache
+	again, err := repo.GetCommit(headSha1)
+	c.Assert(err, IsNil)
+	c.Check(again, Equals, commit)
+
+	// Asking for the wrong type is an error, not a panic
+	_, err = repo.GetTree(headSha1)
+	c.Check(err, ErrorMatches, ".* is a commit, not a tree")
+
+	tree, err := commit.InstantiateTree(repo)
+	c.Assert(err, IsNil)
+	blob := tree.entries[0].blob
+	size, err := blob.DecompressedSizeBytes(repo)
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, 5)
+
+	// Another Blob object for the same sha1 gets the size from the cache
+	cachedBlob, err := repo.GetBlob(blob.Sha1())
+	c.Assert(err, IsNil)
+	c.Check(cachedBlob, Equals, blob)
+	_, known := (&Blob{sha1: blob.Sha1()})._cachedSize()
+	c.Check(known, Equals, false)
+}
+
+func (s *MySuite) TestBoundedObjectCacheCountsBlobs(c *C) {
+	repo, _ := s.setupRepoWithReadme(c)
+	blobBytes := (&Blob{sha1: strings.Repeat("0", 40)})._estimatedSizeBytes()
+	repo.SetObjectCache(NewBoundedObjectCache(0, 2*blobBytes))
+
+	// Blobs take up room in the budget, so the third one evicts the first
+	for _, sha1 := range []string{strings.Repeat("1", 40), strings.Repeat("2", 40), strings.Repeat("3", 40)} {
+		_, err := repo.GetBlob(sha1)
+		c.Assert(err, IsNil)
+	}
+	stats := repo.CacheStats()
+	c.Check(stats.Entries, Equals, 2)
+	c.Check(stats.Bytes, Equals, 2*blobBytes)
+	c.Check(stats.Evictions, Equals, uint64(1))
+
+	// As does a blob which is only cached once its size is known
+	readmeSha1, err := repo.ResolveRevision("HEAD:README")
+	c.Assert(err, IsNil)
+	_, err = (&Blob{sha1: readmeSha1}).DecompressedSizeBytes(repo)
+	c.Assert(err, IsNil)
+	c.Check(repo.CacheStats().Evictions, Equals, uint64(2))
+}
+
+func (s *MySuite) TestNewTreeCache(c *C) {
+	repo, _ := s.setupRepoWithReadme(c)
+	repo.SetObjectCache(NewTreeCache())
+	_, err := repo.GetBlob(strings.Repeat("1", 40))
+	c.Assert(err, IsNil)
+	c.Check(repo.CacheStats().Entries, Equals, 1)
+}
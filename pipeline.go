@@ -0,0 +1,67 @@
+package gitobjects
+
+import (
+	"context"
+	"github.com/crewjam/errset"
+	"sync"
+)
+
+// Supervises the goroutines of a pipeline, in the style of errgroup.Group.
+// Every stage shares one context; the first stage to return an error cancels
+// it, so that every other stage stops too. Unlike errgroup, all errors are
+// kept, not just the first one.
+type pipelineGroup struct {
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	wg         sync.WaitGroup
+
+	errMutex sync.Mutex
+	errs     errset.ErrSet
+}
+
+func newPipelineGroup(ctx context.Context) *pipelineGroup {
+	ctx, cancelFunc := context.WithCancel(ctx)
+	return &pipelineGroup{
+		ctx:        ctx,
+		cancelFunc: cancelFunc,
+	}
+}
+
+// The context which every stage should watch
+func (self *pipelineGroup) Context() context.Context {
+	return self.ctx
+}
+
+// Run a stage in a new goroutine. A stage that stops because the context
+// was canceled should return nil, as the cancellation is not its error.
+func (self *pipelineGroup) Go(stage func() error) {
+	self.wg.Add(1)
+	go func() {
+		defer self.wg.Done()
+		err := stage()
+		if err != nil {
+			self.errMutex.Lock()
+			self.errs = append(self.errs, err)
+			self.errMutex.Unlock()
+			self.cancelFunc()
+		}
+	}()
+}
+
+// Wait for every stage to finish, and return nil, the only error,
+// or an errset.ErrSet of all errors.
+func (self *pipelineGroup) Wait() error {
+	self.wg.Wait()
+	self.cancelFunc()
+
+	self.errMutex.Lock()
+	defer self.errMutex.Unlock()
+	switch len(self.errs) {
+	case 0:
+		return nil
+	case 1:
+		return self.errs[0]
+	default:
+		return self.errs
+	}
+}
@@ -0,0 +1,200 @@
+package gitobjects
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// A snapshot of how far a long-running operation has got. Counters which
+// do not apply to an operation are left at 0.
+type Progress struct {
+	// "stream" for StreamObjectsOfType, "tree-walk" for blob path walks
+	Operation string
+
+	PacksTotal     int64
+	PacksProcessed int64
+
+	// The number of objects in every pack index, plus the loose
+	// objects found so far
+	ObjectsTotal int64
+
+	// Objects examined, and objects which were sent to the caller
+	ObjectsSeen    int64
+	ObjectsMatched int64
+
+	// The decompressed size of the objects examined
+	BytesInflated int64
+
+	Elapsed time.Duration
+
+	// The estimated time remaining, based on ObjectsTotal; 0 when unknown
+	ETA time.Duration
+
+	// True for the last report, after the operation has finished
+	Done bool
+}
+
+// Called periodically with the progress of an operation. It is never
+// called from more than one goroutine at a time.
+type ProgressFunc func(Progress)
+
+// How often progress is reported, unless the options say otherwise
+const defaultProgressInterval = time.Second
+
+// Counts the progress of an operation and reports it on a timer. All methods
+// are safe to call on a nil *progressTracker, which does nothing, so that
+// callers need not check whether progress was requested.
+type progressTracker struct {
+	operation string
+	progress  ProgressFunc
+	interval  time.Duration
+	startTime time.Time
+
+	packsTotal     int64
+	packsProcessed int64
+	objectsTotal   int64
+	objectsSeen    int64
+	objectsMatched int64
+	bytesInflated  int64
+
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+// Returns nil if progress is nil
+func newProgressTracker(operation string, progress ProgressFunc, interval time.Duration) *progressTracker {
+	if progress == nil {
+		return nil
+	}
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	return &progressTracker{
+		operation: operation,
+		progress:  progress,
+		interval:  interval,
+		stopChan:  make(chan struct{}),
+		doneChan:  make(chan struct{}),
+	}
+}
+
+// Start reporting on a timer
+func (self *progressTracker) Start() {
+	if self == nil {
+		return
+	}
+	self.startTime = time.Now()
+	go func() {
+		defer close(self.doneChan)
+		ticker := time.NewTicker(self.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				self.progress(self.Snapshot(false))
+			case <-self.stopChan:
+				self.progress(self.Snapshot(true))
+				return
+			}
+		}
+	}()
+}
+
+// Stop the timer, and make the final report
+func (self *progressTracker) Stop() {
+	if self == nil {
+		return
+	}
+	close(self.stopChan)
+	<-self.doneChan
+}
+
+func (self *progressTracker) Snapshot(done bool) Progress {
+	progress := Progress{
+		Operation:      self.operation,
+		PacksTotal:     atomic.LoadInt64(&self.packsTotal),
+		PacksProcessed: atomic.LoadInt64(&self.packsProcessed),
+		ObjectsTotal:   atomic.LoadInt64(&self.objectsTotal),
+		ObjectsSeen:    atomic.LoadInt64(&self.objectsSeen),
+		ObjectsMatched: atomic.LoadInt64(&self.objectsMatched),
+		BytesInflated:  atomic.LoadInt64(&self.bytesInflated),
+		Elapsed:        time.Since(self.startTime),
+		Done:           done,
+	}
+	if !done && progress.ObjectsSeen > 0 && progress.ObjectsTotal > progress.ObjectsSeen {
+		remaining := progress.ObjectsTotal - progress.ObjectsSeen
+		progress.ETA = time.Duration(int64(progress.Elapsed) / progress.ObjectsSeen * remaining)
+	}
+	return progress
+}
+
+func (self *progressTracker) AddPacks(numPacks int64, numObjects int64) {
+	if self == nil {
+		return
+	}
+	atomic.AddInt64(&self.packsTotal, numPacks)
+	atomic.AddInt64(&self.objectsTotal, numObjects)
+}
+
+func (self *progressTracker) PackProcessed() {
+	if self == nil {
+		return
+	}
+	atomic.AddInt64(&self.packsProcessed, 1)
+}
+
+// A loose object adds to the total, as loose objects are not counted up front
+func (self *progressTracker) LooseObjectFound() {
+	if self == nil {
+		return
+	}
+	atomic.AddInt64(&self.objectsTotal, 1)
+}
+
+func (self *progressTracker) ObjectSeen(sizeBytes int64) {
+	if self == nil {
+		return
+	}
+	atomic.AddInt64(&self.objectsSeen, 1)
+	atomic.AddInt64(&self.bytesInflated, sizeBytes)
+}
+
+func (self *progressTracker) ObjectMatched() {
+	if self == nil {
+		return
+	}
+	atomic.AddInt64(&self.objectsMatched, 1)
+}
+
+// Read the number of objects in a pack index, from its fan-out table.
+// The last of the 256 fan-out entries is the total.
+func _packIndexObjectCount(idxFile string) (int64, error) {
+	file, err := os.Open(idxFile)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Opening pack index %s", idxFile)
+	}
+	defer file.Close()
+
+	var header [8]byte
+	_, err = io.ReadFull(file, header[:])
+	if err != nil {
+		return 0, errors.Wrapf(err, "Reading header of pack index %s", idxFile)
+	}
+
+	// Version 2 (and later) indexes start with "\377tOc" and a version;
+	// version 1 indexes start directly with the fan-out table.
+	fanoutOffset := int64(0)
+	if header[0] == 0xff && header[1] == 't' && header[2] == 'O' && header[3] == 'c' {
+		fanoutOffset = 8
+	}
+	var count [4]byte
+	_, err = file.ReadAt(count[:], fanoutOffset+255*4)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Reading fan-out table of pack index %s", idxFile)
+	}
+	return int64(binary.BigEndian.Uint32(count[:])), nil
+}
@@ -0,0 +1,110 @@
+package gitobjects
+
+import (
+	"context"
+	. "gopkg.in/check.v1"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+func (s *MySuite) TestStreamProgress(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	modifyReadmeAndPack(c, repo, repoDir)
+	addNotes(c, repo, repoDir)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(3)*time.Second)
+	defer cancelFunc()
+
+	var mutex sync.Mutex
+	var reports []Progress
+	options := DefaultStreamOptions()
+	options.ProgressInterval = time.Millisecond
+	options.Progress = func(progress Progress) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		reports = append(reports, progress)
+	}
+	objectChan, errorChan := repo.StreamObjectsOfTypeWithOptions(ctx, "commit", options)
+	numFound := 0
+	for range objectChan {
+		numFound++
+	}
+	c.Assert(<-errorChan, IsNil)
+	c.Check(numFound, Equals, 3)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	c.Assert(len(reports) > 0, Equals, true)
+	final := reports[len(reports)-1]
+	c.Check(final.Operation, Equals, "stream")
+	c.Check(final.Done, Equals, true)
+	c.Check(final.PacksTotal, Equals, int64(1))
+	c.Check(final.PacksProcessed, Equals, int64(1))
+	c.Check(final.ObjectsMatched, Equals, int64(3))
+	c.Check(final.ObjectsSeen, Equals, final.ObjectsTotal)
+	c.Check(final.BytesInflated > 0, Equals, true)
+	c.Check(final.ETA, Equals, time.Duration(0))
+	for _, report := range reports[:len(reports)-1] {
+		c.Check(report.Done, Equals, false)
+	}
+}
+
+func (s *MySuite) TestTreeWalkProgress(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+
+	output, err := repo.CmdOutput([]string{"rev-parse", "HEAD^{tree}"})
+	c.Assert(err, IsNil)
+	tree, err := repo.GetTree(strings.TrimRight(string(output), "\n"))
+	c.Assert(err, IsNil)
+
+	var final Progress
+	iter := tree.BlobPathsUniqueWithProgress(context.Background(), repo, make(map[string]bool),
+		func(progress Progress) {
+			final = progress
+		})
+	for iter.Next() {
+	}
+	c.Assert(iter.Err(), IsNil)
+
+	// README, docs, docs/api, docs/api/index.md, src, src/main.go
+	c.Check(final.Operation, Equals, "tree-walk")
+	c.Check(final.Done, Equals, true)
+	c.Check(final.ObjectsSeen, Equals, int64(6))
+	c.Check(final.ObjectsMatched, Equals, int64(3))
+}
+
+func (s *MySuite) TestPackIndexObjectCount(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	modifyReadmeAndPack(c, repo, repoDir)
+
+	idxFiles, err := filepath.Glob(filepath.Join(repo.GitDir(), "objects", "pack", "pack-*.idx"))
+	c.Assert(err, IsNil)
+	c.Assert(len(idxFiles), Equals, 1)
+
+	// 2 commits, 2 trees, 2 blobs
+	count, err := _packIndexObjectCount(idxFiles[0])
+	c.Assert(err, IsNil)
+	c.Check(count, Equals, int64(6))
+}
+
+func (s *MySuite) TestProgressETA(c *C) {
+	tracker := newProgressTracker("stream", func(Progress) {}, 0)
+	tracker.startTime = time.Now().Add(-10 * time.Second)
+	tracker.AddPacks(1, 100)
+	for i := 0; i < 25; i++ {
+		tracker.ObjectSeen(10)
+	}
+	progress := tracker.Snapshot(false)
+	c.Check(progress.BytesInflated, Equals, int64(250))
+	// 25 objects in 10 seconds, so 75 more take about 30 seconds
+	c.Check(progress.ETA > 29*time.Second && progress.ETA < 31*time.Second, Equals, true)
+
+	// A nil tracker does nothing
+	var nilTracker *progressTracker
+	nilTracker.Start()
+	nilTracker.ObjectSeen(1)
+	nilTracker.Stop()
+}
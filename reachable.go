@@ -0,0 +1,225 @@
+package gitobjects
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"path/filepath"
+)
+
+// An Object found by walking history. Path is where a Tree or Blob was first
+// seen, relative to the root of its commit's tree; it is "" for Commits, Tags,
+// root Trees, and Trees or Blobs given directly as tips.
+type ReachableObject struct {
+	Object Object
+	Path   string
+}
+
+// Stream every Commit, Tree, Blob and Tag reachable from the tips, but not from
+// the exclusions, exactly once, like "git rev-list --objects tips ^exclusions".
+// Tips and exclusions can be anything "git rev-parse" understands, such as
+// branch names, tags or sha1s. Once done reading the ReachableObject channel,
+// read the error channel to see if the walk stopped due to any error. The
+// context can be used to cancel the walk.
+func (self *Repo) StreamReachableObjects(ctx context.Context, tips []string,
+	exclusions []string) (<-chan *ReachableObject, <-chan error) {
+
+	objectChan := make(chan *ReachableObject)
+
+	// Buffered so the single error can be sent before objectChan is closed
+	errorChan := make(chan error, 1)
+
+	go func() {
+		defer close(errorChan)
+		defer close(objectChan)
+		err := self._streamReachableObjects(ctx, tips, exclusions, objectChan)
+		if err != nil {
+			errorChan <- err
+		}
+	}()
+	return objectChan, errorChan
+}
+
+func (self *Repo) _streamReachableObjects(ctx context.Context, tips []string, exclusions []string,
+	objectChan chan<- *ReachableObject) error {
+
+	// Everything reachable from the exclusions is marked as seen first,
+	// so the tips' walk skips it.
+	excluder := &reachabilityWalker{
+		ctx:  ctx,
+		repo: self,
+		seen: make(map[string]bool),
+	}
+	for _, exclusion := range exclusions {
+		sha1, err := self.ResolveRevision(exclusion)
+		if err != nil {
+			return err
+		}
+		err = excluder.Walk(sha1)
+		if err != nil {
+			return err
+		}
+	}
+
+	walker := &reachabilityWalker{
+		ctx:  ctx,
+		repo: self,
+		seen: excluder.seen,
+		emit: func(obj Object, path string) error {
+			select {
+			case objectChan <- &ReachableObject{Object: obj, Path: path}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+	for _, tip := range tips {
+		sha1, err := self.ResolveRevision(tip)
+		if err != nil {
+			return err
+		}
+		err = walker.Walk(sha1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Walks the object graph, visiting each object once. Objects already in
+// seen are skipped, along with everything reachable only through them.
+type reachabilityWalker struct {
+	ctx  context.Context
+	repo *Repo
+
+	// Key = sha1
+	seen map[string]bool
+
+	// Called for each object visited, if not nil
+	emit func(obj Object, path string) error
+}
+
+func (self *reachabilityWalker) _visit(obj Object, path string) error {
+	self.seen[obj.Sha1()] = true
+	if self.emit != nil {
+		return self.emit(obj, path)
+	}
+	return self.ctx.Err()
+}
+
+// Walk everything reachable from an object of any type
+func (self *reachabilityWalker) Walk(sha1 string) error {
+	// Peel tags until something else is found
+	for !self.seen[sha1] {
+		objectType, err := self.repo.ObjectType(sha1)
+		if err != nil {
+			return err
+		}
+		switch objectType {
+		case "tag":
+			tag, err := self.repo.GetTag(sha1)
+			if err != nil {
+				return err
+			}
+			err = self._visit(tag, "")
+			if err != nil {
+				return err
+			}
+			sha1 = tag.ObjectSha1()
+		case "commit":
+			return self.WalkCommits([]string{sha1})
+		case "tree":
+			tree, err := self.repo.GetTree(sha1)
+			if err != nil {
+				return err
+			}
+			return self.WalkTree(tree, "")
+		case "blob":
+			blob, err := self.repo.GetBlob(sha1)
+			if err != nil {
+				return err
+			}
+			return self._visit(blob, "")
+		default:
+			return errors.Errorf("Object %s has unknown type '%s'", sha1, objectType)
+		}
+	}
+	return nil
+}
+
+// Walk the history of some commits, and each commit's tree. This is done
+// with a queue rather than recursion, as histories can be very deep.
+func (self *reachabilityWalker) WalkCommits(commitSha1s []string) error {
+	queue := append([]string{}, commitSha1s...)
+	for len(queue) > 0 {
+		sha1 := queue[0]
+		queue = queue[1:]
+		if self.seen[sha1] {
+			continue
+		}
+		commit, err := self.repo.GetCommit(sha1)
+		if err != nil {
+			return err
+		}
+		err = self._visit(commit, "")
+		if err != nil {
+			return err
+		}
+
+		if !self.seen[commit.TreeSha1()] {
+			tree, err := self.repo.GetTree(commit.TreeSha1())
+			if err != nil {
+				return errors.Wrapf(err, "Reading tree of commit %s", sha1)
+			}
+			err = self.WalkTree(tree, "")
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, parentSha1 := range commit.ParentSha1s() {
+			if !self.seen[parentSha1] {
+				queue = append(queue, parentSha1)
+			}
+		}
+	}
+	return nil
+}
+
+// Walk a tree and everything below it that has not been seen
+func (self *reachabilityWalker) WalkTree(tree *Tree, path string) error {
+	if self.seen[tree.Sha1()] {
+		return nil
+	}
+	err := self._visit(tree, path)
+	if err != nil {
+		return err
+	}
+
+	entries, err := tree.Entries(self.repo)
+	if err != nil {
+		return errors.Wrapf(err, "Instantiating tree %s at '%s'", tree.Sha1(), path)
+	}
+	for _, entry := range entries {
+		if self.seen[entry.Sha1()] {
+			continue
+		}
+		entryPath := filepath.Join(path, entry.Name())
+		switch entry.Type() {
+		case "blob":
+			err = self._visit(entry.blob, entryPath)
+		case "tree":
+			var subtree *Tree
+			subtree, err = entry.Tree(self.repo)
+			if err == nil {
+				err = self.WalkTree(subtree, entryPath)
+			}
+		case "commit":
+			// Submodule commits are in another repository
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package gitobjects
+
+import (
+	"context"
+	. "gopkg.in/check.v1"
+	"strings"
+	"time"
+)
+
+// Collect what StreamReachableObjects sends, as sha1 => path
+// for each type
+func collectReachable(c *C, repo *Repo, tips []string, exclusions []string) map[string]map[string]string {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(3)*time.Second)
+	defer cancelFunc()
+
+	found := make(map[string]map[string]string)
+	objectChan, errorChan := repo.StreamReachableObjects(ctx, tips, exclusions)
+	for reachable := range objectChan {
+		type_ := reachable.Object.Type()
+		if found[type_] == nil {
+			found[type_] = make(map[string]string)
+		}
+		_, duplicate := found[type_][reachable.Object.Sha1()]
+		c.Check(duplicate, Equals, false)
+		found[type_][reachable.Object.Sha1()] = reachable.Path
+	}
+	c.Assert(<-errorChan, IsNil)
+	return found
+}
+
+func (s *MySuite) TestStreamReachableObjects(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	modifyReadmeAndPack(c, repo, repoDir)
+	addNotes(c, repo, repoDir)
+	addNestedFiles(c, repo, repoDir)
+
+	// Garbage, which is never reachable
+	cmd := repo.Command([]string{"hash-object", "-w", "--stdin"})
+	cmd.Dir = repoDir
+	cmd.Stdin = strings.NewReader("unreachable\n")
+	c.Assert(cmd.Run(), IsNil)
+
+	found := collectReachable(c, repo, []string{"HEAD"}, nil)
+	c.Check(len(found["commit"]), Equals, 4)
+	// 4 root trees, docs, docs/api, src
+	c.Check(len(found["tree"]), Equals, 7)
+	// 2 READMEs, NOTES, index.md, main.go
+	c.Check(len(found["blob"]), Equals, 5)
+	c.Check(len(found["tag"]), Equals, 0)
+
+	paths := make(map[string]bool)
+	for _, path := range found["blob"] {
+		paths[path] = true
+	}
+	c.Check(paths, DeepEquals, map[string]bool{
+		"README": true, "NOTES": true, "docs/api/index.md": true, "src/main.go": true,
+	})
+	apiTreeSha1, err := repo.ResolveRevision("HEAD:docs/api")
+	c.Assert(err, IsNil)
+	c.Check(found["tree"][apiTreeSha1], Equals, "docs/api")
+
+	// Only what the last commit added
+	found = collectReachable(c, repo, []string{"HEAD"}, []string{"HEAD~1"})
+	c.Check(len(found["commit"]), Equals, 1)
+	c.Check(len(found["tree"]), Equals, 4)
+	c.Check(len(found["blob"]), Equals, 2)
+}
+
+func (s *MySuite) TestStreamReachableObjectsFromTag(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+
+	cmd := repo.Command([]string{"tag", "-a", "-m", "Release", "v1.0"})
+	cmd.Dir = repoDir
+	c.Assert(cmd.Run(), IsNil)
+
+	found := collectReachable(c, repo, []string{"v1.0"}, nil)
+	c.Check(len(found["tag"]), Equals, 1)
+	c.Check(len(found["commit"]), Equals, 1)
+	c.Check(len(found["tree"]), Equals, 1)
+	c.Check(len(found["blob"]), Equals, 1)
+
+	// A tree as a tip
+	found = collectReachable(c, repo, []string{"HEAD^{tree}"}, nil)
+	c.Check(len(found["commit"]), Equals, 0)
+	c.Check(len(found["tree"]), Equals, 1)
+	c.Check(len(found["blob"]), Equals, 1)
+
+	ctx := context.Background()
+	objectChan, errorChan := repo.StreamReachableObjects(ctx, []string{"no-such-branch"}, nil)
+	for range objectChan {
+	}
+	c.Check(<-errorChan, ErrorMatches, "Resolving revision 'no-such-branch'.*")
+}
@@ -0,0 +1,628 @@
+package gitobjects
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A set of ref updates which are made together or not at all, the way
+// "git update-ref --stdin" makes them. Each ref is locked with a
+// "<ref>.lock" file, as git locks it, and its current value is compared
+// with the expected old value, if one is given, while it is locked. HEAD
+// and the other symbolic refs that updates go through are locked too, so
+// that they cannot move meanwhile. Only when every ref is locked and every check has passed is anything
+// changed, so another git process either sees all of the updates or gets
+// an error. It is not safe for concurrent use.
+type RefTransaction struct {
+	repo    *Repo
+	updates []*refUpdate
+	closed  bool
+
+	// The locks on the symbolic refs the updates go through, held while
+	// committing
+	symrefLocks []*lockFile
+}
+
+// One change queued in a RefTransaction
+type refUpdate struct {
+	refName string
+
+	// zeroSha1 to delete the ref, or "" to only check it
+	newSha1 string
+
+	// zeroSha1 if the ref must not exist, or "" for no check
+	oldSha1 string
+
+	message string
+
+	// The symbolic ref, such as HEAD, that the update was given for
+	symrefName string
+
+	// Set while committing
+	path        string
+	lock        *lockFile
+	currentSha1 string
+	logNames    []string
+
+	// Whether the ref has a loose file, rather than only a packed-refs line
+	loose bool
+}
+
+// The most symbolic refs that are followed to find a ref, as in git
+const maxSymrefDepth = 5
+
+// Start a transaction. It must be finished with Commit or Abort.
+func (self *Repo) NewRefTransaction() *RefTransaction {
+	return &RefTransaction{repo: self}
+}
+
+// Set a ref to newSha1, if it is at oldSha1. An oldSha1 of "" skips the
+// check, and zeroSha1 means that the ref must not exist yet. An update to
+// a symbolic ref, such as HEAD, updates the ref it points to. The message
+// goes in the reflogs.
+func (self *RefTransaction) Update(refName string, newSha1 string, oldSha1 string, message string) error {
+	if !_isLowerHex(newSha1, 40) {
+		return errors.Errorf("Bad new sha1 '%s' for ref '%s'", newSha1, refName)
+	}
+	return self._queue(&refUpdate{refName: refName, newSha1: newSha1, oldSha1: oldSha1, message: message})
+}
+
+// Create a ref which must not exist yet
+func (self *RefTransaction) Create(refName string, newSha1 string, message string) error {
+	return self.Update(refName, newSha1, zeroSha1, message)
+}
+
+// Delete a ref, and its reflog, if it is at oldSha1. An oldSha1 of "" skips
+// the check.
+func (self *RefTransaction) Delete(refName string, oldSha1 string, message string) error {
+	return self._queue(&refUpdate{refName: refName, newSha1: zeroSha1, oldSha1: oldSha1, message: message})
+}
+
+// Check that a ref is at oldSha1, or does not exist if it is zeroSha1,
+// without changing it
+func (self *RefTransaction) Verify(refName string, oldSha1 string) error {
+	if oldSha1 == "" {
+		return errors.Errorf("No old sha1 given to verify ref '%s'", refName)
+	}
+	return self._queue(&refUpdate{refName: refName, oldSha1: oldSha1})
+}
+
+// Lock every ref, check them, and then make every update. If anything
+// fails before the updates are made, nothing is changed. The transaction
+// is finished either way.
+func (self *RefTransaction) Commit() error {
+	if self.closed {
+		return errors.New("Ref transaction is already finished")
+	}
+	self.closed = true
+
+	var packedLock *lockFile
+	defer func() {
+		for _, update := range self.updates {
+			if update.lock != nil {
+				update.lock.Rollback()
+			}
+		}
+		for _, lock := range self.symrefLocks {
+			lock.Rollback()
+		}
+		if packedLock != nil {
+			packedLock.Rollback()
+		}
+	}()
+
+	err := self._resolveSymrefs()
+	if err != nil {
+		return err
+	}
+	err = self._checkObjects()
+	if err != nil {
+		return err
+	}
+	headRef, err := self.repo._resolveSymref("HEAD")
+	if err != nil {
+		return err
+	}
+
+	// Locks are always taken in the same order, as git takes them, so
+	// that two transactions cannot each wait for the other
+	sort.Slice(self.updates, func(i, j int) bool {
+		return self.updates[i].refName < self.updates[j].refName
+	})
+	deleting := make(map[string]bool)
+	for _, update := range self.updates {
+		err = self._lock(update)
+		if err != nil {
+			return err
+		}
+		if update.newSha1 == zeroSha1 {
+			deleting[update.refName] = true
+		}
+	}
+	err = self._lockSymrefs(headRef)
+	if err != nil {
+		return err
+	}
+
+	commonDir, err := self.repo._commonDir()
+	if err != nil {
+		return err
+	}
+	packedPath := filepath.Join(commonDir, "packed-refs")
+	if len(deleting) > 0 {
+		packedLock, err = newLockFile(packedPath, 0666)
+		if err != nil {
+			return err
+		}
+	}
+	packedContents, packedRefs, err := _readPackedRefs(packedPath)
+	if err != nil {
+		return err
+	}
+
+	for _, update := range self.updates {
+		err = self._check(update, packedRefs, deleting)
+		if err != nil {
+			return err
+		}
+	}
+	// What can fail without a change to the repo, such as reading the
+	// config, is done before any change is made
+	committer, err := self._prepareReflogs(headRef)
+	if err != nil {
+		return err
+	}
+
+	// Every check has passed, so make the changes: first the values in
+	// the locks, then packed-refs without the deleted refs, so that they
+	// never show through their deleted loose files, and then the loose refs
+	for _, update := range self.updates {
+		if update.newSha1 != "" && update.newSha1 != zeroSha1 {
+			_, err = update.lock.Write([]byte(update.newSha1 + "\n"))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if packedLock != nil {
+		packedDeleted := false
+		for refName := range deleting {
+			_, ok := packedRefs[refName]
+			packedDeleted = packedDeleted || ok
+		}
+		if packedDeleted {
+			_, err = packedLock.Write(_removePackedRefs(packedContents, deleting))
+			if err != nil {
+				return err
+			}
+			err = packedLock.Commit()
+			packedLock = nil
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return self._finish(committer)
+}
+
+// Drop every queued update
+func (self *RefTransaction) Abort() error {
+	if self.closed {
+		return errors.New("Ref transaction is already finished")
+	}
+	self.closed = true
+	self.updates = nil
+	return nil
+}
+
+func (self *RefTransaction) _queue(update *refUpdate) error {
+	if self.closed {
+		return errors.New("Ref transaction is already finished")
+	}
+	err := _checkRefName(update.refName)
+	if err != nil {
+		return err
+	}
+	if update.oldSha1 != "" && !_isLowerHex(update.oldSha1, 40) {
+		return errors.Errorf("Bad old sha1 '%s' for ref '%s'", update.oldSha1, update.refName)
+	}
+	self.updates = append(self.updates, update)
+	return nil
+}
+
+// Make updates to symbolic refs into updates of the refs they point to,
+// and check that no ref is updated twice
+func (self *RefTransaction) _resolveSymrefs() error {
+	seen := make(map[string]bool)
+	for _, update := range self.updates {
+		refName, err := self.repo._resolveSymref(update.refName)
+		if err != nil {
+			return err
+		}
+		if refName != update.refName {
+			update.symrefName = update.refName
+			update.refName = refName
+		}
+		if seen[update.refName] {
+			return errors.Errorf("Multiple updates for ref '%s' are not allowed", update.refName)
+		}
+		seen[update.refName] = true
+	}
+	return nil
+}
+
+// Lock the symbolic refs that updates go through, and HEAD if an update is
+// to its branch, as git locks them, and check that each still points to the
+// same ref. Otherwise a "git checkout" could move HEAD after it was resolved,
+// and the old branch would be updated, and logged in HEAD's reflog.
+func (self *RefTransaction) _lockSymrefs(headRef string) error {
+	targets := make(map[string]string)
+	for _, update := range self.updates {
+		if update.symrefName != "" {
+			targets[update.symrefName] = update.refName
+		}
+		if update.refName == headRef && update.refName != "HEAD" {
+			targets["HEAD"] = update.refName
+		}
+	}
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path, err := self.repo._refFilePath("", name)
+		if err != nil {
+			return err
+		}
+		lock, err := newLockFile(path, 0666)
+		if err != nil {
+			return errors.Wrapf(err, "Cannot lock ref '%s'", name)
+		}
+		self.symrefLocks = append(self.symrefLocks, lock)
+		target, err := self.repo._resolveSymref(name)
+		if err != nil {
+			return err
+		}
+		if target != targets[name] {
+			return errors.Errorf("Cannot lock ref '%s': it points to '%s', not '%s'", name, target, targets[name])
+		}
+	}
+	return nil
+}
+
+// Check that every new value exists, and that branches only get commits
+func (self *RefTransaction) _checkObjects() error {
+	var batchCheck *catFileBatchCheck
+	for _, update := range self.updates {
+		if update.newSha1 == "" || update.newSha1 == zeroSha1 {
+			continue
+		}
+		if batchCheck == nil {
+			var err error
+			batchCheck, err = newCatFileBatchCheck(self.repo)
+			if err != nil {
+				return err
+			}
+			defer batchCheck.Close()
+		}
+		type_, _, err := batchCheck.Check(update.newSha1)
+		if err != nil {
+			return err
+		}
+		if type_ == "missing" {
+			return errors.Errorf("Trying to write ref '%s' with nonexistent object %s", update.refName, update.newSha1)
+		}
+		if type_ != "commit" && strings.HasPrefix(update.refName, "refs/heads/") {
+			return errors.Errorf("Trying to write non-commit object %s to branch '%s'", update.newSha1, update.refName)
+		}
+	}
+	return nil
+}
+
+func (self *RefTransaction) _lock(update *refUpdate) error {
+	var err error
+	update.path, err = self.repo._refFilePath("", update.refName)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(update.path), 0777)
+	if err != nil {
+		// A file where a directory should be is a conflicting ref
+		return errors.Wrapf(err, "Cannot lock ref '%s'", update.refName)
+	}
+	update.lock, err = newLockFile(update.path, 0666)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot lock ref '%s'", update.refName)
+	}
+	return nil
+}
+
+// Check a locked ref's current value, and that creating it does not
+// conflict with a ref whose name is a directory of its name, or the other
+// way around
+func (self *RefTransaction) _check(update *refUpdate, packedRefs map[string]string, deleting map[string]bool) error {
+	sha1, isSymref, err := _readLooseRef(update.path)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot lock ref '%s'", update.refName)
+	}
+	if isSymref {
+		return errors.Errorf("Cannot lock ref '%s': it became a symbolic ref", update.refName)
+	}
+	update.loose = sha1 != ""
+	if sha1 == "" {
+		sha1 = packedRefs[update.refName]
+	}
+	if sha1 == "" {
+		sha1 = zeroSha1
+	}
+	update.currentSha1 = sha1
+
+	switch {
+	case update.oldSha1 == "" || update.oldSha1 == sha1:
+	case update.oldSha1 == zeroSha1:
+		return errors.Errorf("Cannot lock ref '%s': reference already exists", update.refName)
+	case sha1 == zeroSha1:
+		return errors.Errorf("Cannot lock ref '%s': unable to resolve reference", update.refName)
+	default:
+		return errors.Errorf("Cannot lock ref '%s': is at %s but expected %s", update.refName, sha1, update.oldSha1)
+	}
+	if sha1 != zeroSha1 || update.newSha1 == "" || update.newSha1 == zeroSha1 {
+		return nil
+	}
+
+	// A ref being created
+	conflict := func(existing string) error {
+		return errors.Errorf("Cannot lock ref '%s': '%s' exists; cannot create '%s'",
+			update.refName, existing, update.refName)
+	}
+	parts := strings.Split(update.refName, "/")
+	for i := 1; i < len(parts); i++ {
+		prefix := strings.Join(parts[:i], "/")
+		if _, ok := packedRefs[prefix]; ok && !deleting[prefix] {
+			return conflict(prefix)
+		}
+	}
+	for refName := range packedRefs {
+		if strings.HasPrefix(refName, update.refName+"/") && !deleting[refName] {
+			return conflict(refName)
+		}
+	}
+	for _, other := range self.updates {
+		if strings.HasPrefix(other.refName, update.refName+"/") && other.newSha1 != "" && other.newSha1 != zeroSha1 {
+			return conflict(other.refName)
+		}
+	}
+	// Loose refs below it; an empty directory is left over from deleted
+	// refs, and is removed
+	if info, err := os.Stat(update.path); err == nil && info.IsDir() {
+		if os.Remove(update.path) != nil {
+			return errors.Errorf("Cannot lock ref '%s': there are refs below '%s'", update.refName, update.refName)
+		}
+	}
+	return nil
+}
+
+// Find the reflogs that each update is logged in, and who the entries are
+// from, which is nil if none are. A ref which is HEAD's branch is logged in
+// HEAD's reflog too, as git does.
+func (self *RefTransaction) _prepareReflogs(headRef string) (*Signature, error) {
+	config, err := self.repo.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	logging := false
+	for _, update := range self.updates {
+		if update.newSha1 == "" || update.newSha1 == zeroSha1 {
+			continue
+		}
+		logNames := []string{update.refName}
+		if update.symrefName != "" {
+			logNames = append(logNames, update.symrefName)
+		}
+		if update.refName == headRef && update.refName != "HEAD" && update.symrefName != "HEAD" {
+			logNames = append(logNames, "HEAD")
+		}
+		for _, logName := range logNames {
+			shouldLog, err := self.repo._shouldLogRefUpdate(config, logName)
+			if err != nil {
+				return nil, err
+			}
+			if shouldLog {
+				update.logNames = append(update.logNames, logName)
+				logging = true
+			}
+		}
+	}
+	if !logging {
+		return nil, nil
+	}
+	return self.repo._reflogCommitter()
+}
+
+// Should a change to a ref through this library be logged? It should if
+// the ref already has a reflog, or if core.logAllRefUpdates asks for one:
+// "always" for every ref, or true, the default outside a bare repository,
+// for HEAD and branches, remote-tracking branches and notes.
+func (self *Repo) _shouldLogRefUpdate(config *Config, refName string) (bool, error) {
+	logPath, err := self._refFilePath("logs", refName)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(logPath)
+	if err == nil {
+		return true, nil
+	} else if !os.IsNotExist(err) {
+		return false, errors.Wrapf(err, "Checking for reflog of %s", refName)
+	}
+
+	value, _ := config.Get("core.logAllRefUpdates")
+	if strings.ToLower(value) == "always" {
+		return true, nil
+	}
+	logAll, err := config.GetBool("core.logAllRefUpdates", self.workTree != "")
+	if err != nil {
+		return false, err
+	}
+	return logAll && (refName == "HEAD" ||
+		strings.HasPrefix(refName, "refs/heads/") ||
+		strings.HasPrefix(refName, "refs/remotes/") ||
+		strings.HasPrefix(refName, "refs/notes/")), nil
+}
+
+// Write the reflogs and replace or delete the loose refs
+func (self *RefTransaction) _finish(committer *Signature) error {
+	for _, update := range self.updates {
+		var err error
+		switch update.newSha1 {
+		case "":
+			err = update.lock.Rollback()
+			update.lock = nil
+			if err != nil {
+				return err
+			}
+
+		case zeroSha1:
+			if update.loose {
+				err = os.Remove(update.path)
+				if err != nil && !os.IsNotExist(err) {
+					return errors.Wrapf(err, "Deleting ref '%s'", update.refName)
+				}
+			}
+			err = update.lock.Rollback()
+			update.lock = nil
+			if err != nil {
+				return err
+			}
+			logPath, err := self.repo._refFilePath("logs", update.refName)
+			if err != nil {
+				return err
+			}
+			err = os.Remove(logPath)
+			if err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "Deleting reflog of '%s'", update.refName)
+			}
+			self.repo._removeEmptyRefDirs("", update.refName)
+			self.repo._removeEmptyRefDirs("logs", update.refName)
+
+		default:
+			for _, logName := range update.logNames {
+				err = self.repo.AppendReflog(logName, &ReflogEntry{
+					OldSha1:   update.currentSha1,
+					NewSha1:   update.newSha1,
+					Committer: committer,
+					Message:   update.message,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			err = update.lock.Commit()
+			update.lock = nil
+			if err != nil {
+				return errors.Wrapf(err, "Updating ref '%s'", update.refName)
+			}
+		}
+	}
+	return nil
+}
+
+// Follow symbolic refs, such as HEAD, to the ref they point to, which may
+// not exist
+func (self *Repo) _resolveSymref(refName string) (string, error) {
+	for i := 0; i < maxSymrefDepth; i++ {
+		path, err := self._refFilePath("", refName)
+		if err != nil {
+			return "", err
+		}
+		target, isSymref, err := _readLooseRef(path)
+		if err != nil {
+			return "", errors.Wrapf(err, "Reading ref '%s'", refName)
+		}
+		if !isSymref {
+			return refName, nil
+		}
+		refName = target
+	}
+	return "", errors.Errorf("Too many levels of symbolic refs at '%s'", refName)
+}
+
+// Remove the directories of a deleted ref which are now empty, as git does,
+// keeping the top two levels, such as "refs/heads"
+func (self *Repo) _removeEmptyRefDirs(subdir string, refName string) {
+	path, err := self._refFilePath(subdir, refName)
+	if err != nil {
+		return
+	}
+	for i := strings.Count(refName, "/"); i > 2; i-- {
+		path = filepath.Dir(path)
+		if os.Remove(path) != nil {
+			return
+		}
+	}
+}
+
+// Read a loose ref file: its sha1, or the ref it points to if it is a
+// symbolic ref. Returns "" if there is no file, or if it is a directory.
+func _readLooseRef(path string) (string, bool, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || err == nil && info.IsDir() {
+		return "", false, nil
+	}
+	contents, err := _readOptionalFile(path)
+	if err != nil || contents == nil {
+		return "", false, err
+	}
+	value := strings.TrimRight(string(contents), "\r\n")
+	if strings.HasPrefix(value, "ref:") {
+		return strings.TrimLeft(value[len("ref:"):], " \t"), true, nil
+	}
+	if len(value) < 40 || !_isLowerHex(value[:40], 40) {
+		return "", false, errors.Errorf("Bad ref file %s", path)
+	}
+	return value[:40], false, nil
+}
+
+// Read packed-refs, returning its contents and the sha1 of each ref in it
+func _readPackedRefs(path string) ([]byte, map[string]string, error) {
+	contents, err := _readOptionalFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(string(contents), "\n") {
+		// "<sha1> <ref>", or "^<peeled sha1>" after a tag, or a comment
+		line = strings.TrimSuffix(line, "\r")
+		if len(line) > 41 && line[40] == ' ' && _isLowerHex(line[:40], 40) {
+			refs[line[41:]] = line[:40]
+		}
+	}
+	return contents, refs, nil
+}
+
+// Remove refs, with their peeled lines, from the contents of packed-refs,
+// keeping every other line as it is
+func _removePackedRefs(contents []byte, refNames map[string]bool) []byte {
+	var output bytes.Buffer
+	removing := false
+	for _, line := range bytes.SplitAfter(contents, []byte("\n")) {
+		text := strings.TrimRight(string(line), "\r\n")
+		if strings.HasPrefix(text, "^") {
+			if !removing {
+				output.Write(line)
+			}
+			continue
+		}
+		removing = len(text) > 41 && text[40] == ' ' && refNames[text[41:]]
+		if !removing {
+			output.Write(line)
+		}
+	}
+	return output.Bytes()
+}
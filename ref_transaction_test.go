@@ -0,0 +1,378 @@
+package gitobjects
+
+import (
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Set up a repo with two commits on master, and return the sha1s of the
+// first and second
+func (s *MySuite) setupRepoForRefs(c *C) (*Repo, string, string, string) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	runGitIn(c, repo, repoDir, "commit", "--allow-empty", "-m", "Second")
+	first := runGitIn(c, repo, repoDir, "rev-parse", "HEAD~1")
+	second := runGitIn(c, repo, repoDir, "rev-parse", "HEAD")
+	return repo, repoDir, first, second
+}
+
+// Return the paths of the ".lock" files in the git dir
+func findLockFiles(c *C, repo *Repo) []string {
+	locks := make([]string, 0)
+	err := filepath.Walk(repo.GitDir(), func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".lock") {
+			locks = append(locks, path)
+		}
+		return err
+	})
+	c.Assert(err, IsNil)
+	return locks
+}
+
+func (s *MySuite) TestRefTransaction(c *C) {
+	repo, repoDir, first, second := s.setupRepoForRefs(c)
+	defer setEnv(c, "GIT_COMMITTER_DATE", "1112911993 -0700")()
+
+	transaction := repo.NewRefTransaction()
+	c.Assert(transaction.Create("refs/heads/topic/one", first, "branch: Created from HEAD~1"), IsNil)
+	c.Assert(transaction.Update("HEAD", first, second, "reset: moving to HEAD~1"), IsNil)
+	c.Assert(transaction.Create("refs/tags/v1", second, "tagged"), IsNil)
+	c.Assert(transaction.Verify("refs/heads/no-such-branch", zeroSha1), IsNil)
+	c.Assert(transaction.Commit(), IsNil)
+
+	c.Check(runGitIn(c, repo, repoDir, "show-ref"), Equals, strings.Join([]string{
+		first + " refs/heads/master",
+		first + " refs/heads/topic/one",
+		second + " refs/tags/v1",
+	}, "\n"))
+	c.Check(runGitIn(c, repo, repoDir, "symbolic-ref", "HEAD"), Equals, "refs/heads/master")
+
+	// The branch and HEAD are logged; the tag is not, as git would not
+	for _, refName := range []string{"HEAD", "refs/heads/master"} {
+		c.Check(runGitIn(c, repo, repoDir, "reflog", "show", "-1", "--format=%H %gs", refName), Equals,
+			first+" reset: moving to HEAD~1", Commentf(refName))
+	}
+	c.Check(runGitIn(c, repo, repoDir, "reflog", "show", "--format=%H %gs", "refs/heads/topic/one"), Equals,
+		first+" branch: Created from HEAD~1")
+	refNames, err := repo.ReflogRefs()
+	c.Assert(err, IsNil)
+	c.Check(refNames, DeepEquals, []string{"HEAD", "refs/heads/master", "refs/heads/topic/one"})
+
+	c.Check(transaction.Commit(), ErrorMatches, "Ref transaction is already finished")
+	c.Check(transaction.Delete("refs/tags/v1", "", ""), ErrorMatches, "Ref transaction is already finished")
+
+	// Deleting a branch removes its reflog and its empty directory
+	transaction = repo.NewRefTransaction()
+	c.Assert(transaction.Delete("refs/heads/topic/one", first, "deleted"), IsNil)
+	c.Assert(transaction.Commit(), IsNil)
+	c.Check(runGitIn(c, repo, repoDir, "for-each-ref", "--format=%(refname)", "refs/heads/"), Equals, "refs/heads/master")
+	for _, dir := range []string{"refs/heads/topic", "logs/refs/heads/topic"} {
+		_, err = os.Stat(filepath.Join(repo.GitDir(), dir))
+		c.Check(os.IsNotExist(err), Equals, true, Commentf(dir))
+	}
+
+	// A directory of refs can become a ref once its refs are deleted
+	transaction = repo.NewRefTransaction()
+	c.Assert(transaction.Create("refs/heads/topic", second, ""), IsNil)
+	c.Assert(transaction.Commit(), IsNil)
+	c.Check(runGitIn(c, repo, repoDir, "rev-parse", "topic"), Equals, second)
+}
+
+func (s *MySuite) TestRefTransactionFailures(c *C) {
+	repo, repoDir, first, second := s.setupRepoForRefs(c)
+	tree := runGitIn(c, repo, repoDir, "rev-parse", "HEAD^{tree}")
+	runGitIn(c, repo, repoDir, "branch", "existing", first)
+	runGitIn(c, repo, repoDir, "branch", "doomed", first)
+	runGitIn(c, repo, repoDir, "branch", "packed/dir", first)
+	runGitIn(c, repo, repoDir, "pack-refs", "--all")
+	showRef := runGitIn(c, repo, repoDir, "show-ref")
+
+	for _, test := range []struct {
+		queue func(transaction *RefTransaction)
+		err   string
+	}{
+		{func(transaction *RefTransaction) {
+			transaction.Update("refs/heads/master", first, first, "")
+		}, "Cannot lock ref 'refs/heads/master': is at " + second + " but expected " + first},
+		{func(transaction *RefTransaction) {
+			transaction.Create("refs/heads/existing", second, "")
+		}, "Cannot lock ref 'refs/heads/existing': reference already exists"},
+		{func(transaction *RefTransaction) {
+			transaction.Delete("refs/heads/missing", first, "")
+		}, "Cannot lock ref 'refs/heads/missing': unable to resolve reference"},
+		{func(transaction *RefTransaction) {
+			transaction.Verify("refs/heads/existing", second)
+		}, "Cannot lock ref 'refs/heads/existing': is at .*"},
+		{func(transaction *RefTransaction) {
+			transaction.Create("refs/heads/existing/below", second, "")
+		}, "Cannot lock ref 'refs/heads/existing/below': 'refs/heads/existing' exists; cannot create 'refs/heads/existing/below'"},
+		{func(transaction *RefTransaction) {
+			transaction.Create("refs/heads/packed", second, "")
+		}, "Cannot lock ref 'refs/heads/packed': 'refs/heads/packed/dir' exists; cannot create 'refs/heads/packed'"},
+		{func(transaction *RefTransaction) {
+			transaction.Create("refs/heads/new/a", second, "")
+			transaction.Create("refs/heads/new", second, "")
+		}, "Cannot lock ref 'refs/heads/new': 'refs/heads/new/a' exists; cannot create 'refs/heads/new'"},
+		{func(transaction *RefTransaction) {
+			transaction.Update("HEAD", first, "", "")
+			transaction.Update("refs/heads/master", first, "", "")
+		}, "Multiple updates for ref 'refs/heads/master' are not allowed"},
+		{func(transaction *RefTransaction) {
+			transaction.Create("refs/heads/tree", tree, "")
+		}, "Trying to write non-commit object " + tree + " to branch 'refs/heads/tree'"},
+		{func(transaction *RefTransaction) {
+			transaction.Create("refs/tags/missing", strings.Repeat("1", 40), "")
+		}, "Trying to write ref 'refs/tags/missing' with nonexistent object 1+"},
+	} {
+		// A good update goes with each bad one, and is not made either
+		transaction := repo.NewRefTransaction()
+		c.Assert(transaction.Update("refs/heads/a-good-one", second, "", ""), IsNil)
+		c.Assert(transaction.Delete("refs/heads/doomed", first, ""), IsNil)
+		test.queue(transaction)
+		c.Check(transaction.Commit(), ErrorMatches, test.err)
+		c.Check(runGitIn(c, repo, repoDir, "show-ref"), Equals, showRef)
+		c.Check(findLockFiles(c, repo), HasLen, 0)
+	}
+
+	// A ref locked by another git process is left alone
+	lockPath := filepath.Join(repo.GitDir(), "refs", "heads", "master.lock")
+	c.Assert(ioutil.WriteFile(lockPath, nil, 0666), IsNil)
+	transaction := repo.NewRefTransaction()
+	c.Assert(transaction.Update("refs/heads/master", first, "", ""), IsNil)
+	c.Check(transaction.Commit(), ErrorMatches, "Cannot lock ref 'refs/heads/master': Unable to create .*master.lock.*")
+	c.Check(findLockFiles(c, repo), DeepEquals, []string{lockPath})
+
+	// Bad arguments are refused when they are queued
+	transaction = repo.NewRefTransaction()
+	c.Check(transaction.Update("refs/heads/bad..name", first, "", ""), ErrorMatches, "Invalid ref name .*")
+	c.Check(transaction.Update("refs/heads/x", "HEAD", "", ""), ErrorMatches, "Bad new sha1 .*")
+	c.Check(transaction.Delete("refs/heads/x", "abc", ""), ErrorMatches, "Bad old sha1 .*")
+	c.Check(transaction.Verify("refs/heads/x", ""), ErrorMatches, "No old sha1 .*")
+	c.Assert(transaction.Abort(), IsNil)
+	c.Check(transaction.Commit(), ErrorMatches, "Ref transaction is already finished")
+}
+
+func (s *MySuite) TestRefTransactionPackedRefs(c *C) {
+	repo, repoDir, first, second := s.setupRepoForRefs(c)
+	runGitIn(c, repo, repoDir, "branch", "packed-only", first)
+	runGitIn(c, repo, repoDir, "branch", "packed-and-loose", first)
+	runGitIn(c, repo, repoDir, "tag", "-a", "-m", "Annotated", "annotated", first)
+	runGitIn(c, repo, repoDir, "tag", "-a", "-m", "Kept", "kept", first)
+	runGitIn(c, repo, repoDir, "pack-refs", "--all")
+	runGitIn(c, repo, repoDir, "update-ref", "refs/heads/packed-and-loose", second)
+
+	packedPath := filepath.Join(repo.GitDir(), "packed-refs")
+	before, err := ioutil.ReadFile(packedPath)
+	c.Assert(err, IsNil)
+
+	transaction := repo.NewRefTransaction()
+	c.Assert(transaction.Update("refs/heads/packed-only", second, first, "moved"), IsNil)
+	c.Assert(transaction.Delete("refs/heads/packed-and-loose", second, ""), IsNil)
+	c.Assert(transaction.Delete("refs/tags/annotated", "", ""), IsNil)
+	c.Assert(transaction.Commit(), IsNil)
+
+	c.Check(runGitIn(c, repo, repoDir, "for-each-ref", "--format=%(objectname) %(refname)", "refs/heads/"), Equals,
+		strings.Join([]string{second + " refs/heads/master", second + " refs/heads/packed-only"}, "\n"))
+	c.Check(runGitIn(c, repo, repoDir, "for-each-ref", "--format=%(refname)", "refs/tags/"), Equals, "refs/tags/kept")
+
+	// Only the lines of the deleted refs are gone from packed-refs
+	after, err := ioutil.ReadFile(packedPath)
+	c.Assert(err, IsNil)
+	expected := make([]string, 0)
+	lines := strings.SplitAfter(string(before), "\n")
+	for i := 0; i < len(lines); i++ {
+		if strings.HasSuffix(lines[i], " refs/heads/packed-and-loose\n") {
+			continue
+		}
+		if strings.HasSuffix(lines[i], " refs/tags/annotated\n") {
+			// And its peeled line
+			i++
+			continue
+		}
+		expected = append(expected, lines[i])
+	}
+	c.Check(string(after), Equals, strings.Join(expected, ""))
+	c.Check(strings.Count(string(after), "\n^"), Equals, 1)
+}
+
+// Set up the refs of a transaction which deletes a packed branch, creates a
+// ref which is not logged, and updates master, which is
+func (s *MySuite) setupRepoForLoggedRefs(c *C) (*Repo, string, func(*RefTransaction)) {
+	repo, repoDir, first, second := s.setupRepoForRefs(c)
+	runGitIn(c, repo, repoDir, "branch", "packed", first)
+	runGitIn(c, repo, repoDir, "pack-refs", "--all")
+	return repo, repoDir, func(transaction *RefTransaction) {
+		c.Assert(transaction.Delete("refs/heads/packed", first, ""), IsNil)
+		c.Assert(transaction.Create("refs/a/x", first, ""), IsNil)
+		c.Assert(transaction.Update("refs/heads/master", first, second, "reset: moving to HEAD~1"), IsNil)
+	}
+}
+
+func (s *MySuite) TestRefTransactionNoIdentity(c *C) {
+	repo, repoDir, queue := s.setupRepoForLoggedRefs(c)
+	first := runGitIn(c, repo, repoDir, "rev-parse", "HEAD~1")
+
+	// No identity anywhere, so git itself could not commit
+	defer unsetEnv(c, "GIT_COMMITTER_NAME")()
+	defer unsetEnv(c, "GIT_COMMITTER_EMAIL")()
+	defer setEnv(c, "GIT_CONFIG_NOSYSTEM", "1")()
+	defer setEnv(c, "GIT_CONFIG_GLOBAL", "")()
+	_, err := repo.CommitterSignature()
+	c.Assert(err, ErrorMatches, "Committer identity unknown: .*")
+
+	// The refs are still updated, as git updates them
+	transaction := repo.NewRefTransaction()
+	queue(transaction)
+	c.Assert(transaction.Commit(), IsNil)
+	c.Check(runGitIn(c, repo, repoDir, "show-ref"), Equals, strings.Join([]string{
+		first + " refs/a/x",
+		first + " refs/heads/master",
+	}, "\n"))
+	entries, err := repo.Reflog("refs/heads/master")
+	c.Assert(err, IsNil)
+	entry := entries[len(entries)-1]
+	c.Check(entry.Message, Equals, "reset: moving to HEAD~1")
+	c.Check(entry.Committer.Name, Not(Equals), "")
+	c.Check(entry.Committer.Email, Matches, ".+@.+")
+}
+
+func (s *MySuite) TestRefTransactionFailsBeforeChanges(c *C) {
+	repo, repoDir, queue := s.setupRepoForLoggedRefs(c)
+	showRef := runGitIn(c, repo, repoDir, "show-ref")
+	packedPath := filepath.Join(repo.GitDir(), "packed-refs")
+	packedRefs, err := ioutil.ReadFile(packedPath)
+	c.Assert(err, IsNil)
+	reflogs, err := repo.ReflogRefs()
+	c.Assert(err, IsNil)
+
+	// Which reflogs to write, and who writes them, is only known after every
+	// check has passed; if they cannot be known, nothing is changed
+	logsPath := filepath.Join(repo.GitDir(), "logs", "refs", "a")
+	for _, test := range []struct {
+		setup func() func()
+		err   string
+	}{
+		{func() func() {
+			return setEnv(c, "GIT_COMMITTER_DATE", "yesterday")
+		}, "Unsupported GIT_COMMITTER_DATE 'yesterday'"},
+		{func() func() {
+			// A file where the directory of refs/a/x's reflog would be
+			c.Assert(ioutil.WriteFile(logsPath, nil, 0666), IsNil)
+			return func() { c.Assert(os.Remove(logsPath), IsNil) }
+		}, "Checking for reflog of refs/a/x.*"},
+	} {
+		restore := test.setup()
+		transaction := repo.NewRefTransaction()
+		queue(transaction)
+		c.Check(transaction.Commit(), ErrorMatches, test.err)
+		restore()
+
+		c.Check(runGitIn(c, repo, repoDir, "show-ref"), Equals, showRef)
+		contents, err := ioutil.ReadFile(packedPath)
+		c.Assert(err, IsNil)
+		c.Check(string(contents), Equals, string(packedRefs))
+		refNames, err := repo.ReflogRefs()
+		c.Assert(err, IsNil)
+		c.Check(refNames, DeepEquals, reflogs)
+		c.Check(findLockFiles(c, repo), HasLen, 0)
+	}
+}
+
+func (s *MySuite) TestRefTransactionLocksSymrefs(c *C) {
+	repo, repoDir, first, second := s.setupRepoForRefs(c)
+	runGitIn(c, repo, repoDir, "branch", "other", first)
+	showRef := runGitIn(c, repo, repoDir, "show-ref")
+
+	// While another git process holds HEAD.lock, HEAD's branch is not
+	// updated, whether through HEAD or not; other branches are
+	headLock := filepath.Join(repo.GitDir(), "HEAD.lock")
+	c.Assert(ioutil.WriteFile(headLock, nil, 0666), IsNil)
+	for _, refName := range []string{"HEAD", "refs/heads/master"} {
+		transaction := repo.NewRefTransaction()
+		c.Assert(transaction.Update(refName, first, second, ""), IsNil)
+		c.Check(transaction.Commit(), ErrorMatches, "Cannot lock ref 'HEAD': Unable to create .*HEAD.lock.*")
+		c.Check(runGitIn(c, repo, repoDir, "show-ref"), Equals, showRef)
+	}
+	transaction := repo.NewRefTransaction()
+	c.Assert(transaction.Update("refs/heads/other", second, first, ""), IsNil)
+	c.Assert(transaction.Commit(), IsNil)
+	c.Check(findLockFiles(c, repo), DeepEquals, []string{headLock})
+	c.Assert(os.Remove(headLock), IsNil)
+
+	// HEAD is checked under its lock, so an update through it fails if
+	// HEAD has moved since it was resolved
+	transaction = repo.NewRefTransaction()
+	c.Assert(transaction.Update("HEAD", first, second, ""), IsNil)
+	c.Assert(transaction._resolveSymrefs(), IsNil)
+	runGitIn(c, repo, repoDir, "symbolic-ref", "HEAD", "refs/heads/other")
+	c.Check(transaction.Commit(), ErrorMatches,
+		"Cannot lock ref 'HEAD': it points to 'refs/heads/other', not 'refs/heads/master'")
+	c.Check(runGitIn(c, repo, repoDir, "rev-parse", "master", "other"), Equals, second+"\n"+second)
+	c.Check(findLockFiles(c, repo), HasLen, 0)
+}
+
+func (s *MySuite) TestShouldLogRefUpdate(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	head := runGitIn(c, repo, repoDir, "rev-parse", "HEAD")
+
+	// Whether an update is logged depends on core.logAllRefUpdates
+	c.Assert(repo.AppendReflog("refs/tags/has-log", &ReflogEntry{OldSha1: zeroSha1, NewSha1: head}), IsNil)
+	for _, test := range []struct {
+		value string
+
+		// For HEAD, which has a reflog, a branch, a remote-tracking
+		// branch, notes, a tag, another ref, and a tag with a reflog
+		expected []bool
+	}{
+		{"", []bool{true, true, true, true, false, false, true}},
+		{"false", []bool{true, false, false, false, false, false, true}},
+		{"always", []bool{true, true, true, true, true, true, true}},
+	} {
+		value, expected := test.value, test.expected
+		if value != "" {
+			runGitIn(c, repo, repoDir, "config", "core.logAllRefUpdates", value)
+		}
+		config, err := repo.Config()
+		c.Assert(err, IsNil)
+		for i, refName := range []string{"HEAD", "refs/heads/x", "refs/remotes/origin/x", "refs/notes/x",
+			"refs/tags/x", "refs/other/x", "refs/tags/has-log"} {
+			shouldLog, err := repo._shouldLogRefUpdate(config, refName)
+			c.Assert(err, IsNil)
+			c.Check(shouldLog, Equals, expected[i], Commentf("%s %s", value, refName))
+		}
+	}
+}
+
+func (s *MySuite) TestRefTransactionConcurrent(c *C) {
+	repo, repoDir, first, second := s.setupRepoForRefs(c)
+
+	// Of many transactions which expect the same old value, one wins
+	const count = 10
+	var wg sync.WaitGroup
+	errs := make([]error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			transaction := repo.NewRefTransaction()
+			errs[i] = transaction.Update("refs/heads/master", first, second, "")
+			if errs[i] == nil {
+				errs[i] = transaction.Commit()
+			}
+		}(i)
+	}
+	wg.Wait()
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			c.Check(err, ErrorMatches, "Cannot lock ref 'refs/heads/master': .*")
+		}
+	}
+	c.Check(succeeded, Equals, 1)
+	c.Check(runGitIn(c, repo, repoDir, "rev-parse", "master"), Equals, first)
+}
@@ -0,0 +1,259 @@
+package gitobjects
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// One entry of a reflog: a change to a ref from one commit to another
+type ReflogEntry struct {
+	// zeroSha1 when the ref was created
+	OldSha1 string
+
+	// zeroSha1 when the ref was deleted, as HEAD's reflog can show
+	NewSha1 string
+
+	// Who made the change, and when
+	Committer *Signature
+
+	// Such as "commit: Add README" or "reset: moving to HEAD~1"; may be empty
+	Message string
+}
+
+// Read the reflog of a ref, such as "HEAD" or "refs/heads/main", oldest
+// entry first; "main@{0}" is the last entry. Lines that git would skip as
+// malformed are skipped. A ref without a reflog has no entries.
+func (self *Repo) Reflog(refName string) ([]*ReflogEntry, error) {
+	logPath, err := self._refFilePath("logs", refName)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*ReflogEntry, 0)
+	file, err := os.Open(logPath)
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Reading reflog of %s", refName)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if entry := _parseReflogEntry(strings.TrimSuffix(line, "\n")); entry != nil {
+				entries = append(entries, entry)
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrapf(err, "Reading reflog of %s", refName)
+		}
+	}
+	return entries, nil
+}
+
+// Return the names of the refs which have reflogs, sorted
+func (self *Repo) ReflogRefs() ([]string, error) {
+	commonDir, err := self._commonDir()
+	if err != nil {
+		return nil, err
+	}
+	logsDirs := []string{filepath.Join(self.gitDir, "logs")}
+	if filepath.Clean(commonDir) != filepath.Clean(self.gitDir) {
+		logsDirs = append(logsDirs, filepath.Join(commonDir, "logs"))
+	}
+
+	refNames := make([]string, 0)
+	for _, logsDir := range logsDirs {
+		err := filepath.Walk(logsDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == logsDir {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			relPath, err := filepath.Rel(logsDir, path)
+			if err != nil {
+				return err
+			}
+			refName := filepath.ToSlash(relPath)
+			if _checkRefName(refName) != nil {
+				return nil
+			}
+			// Each directory only counts for the refs that are kept there
+			baseDir, err := self._refBaseDir(refName)
+			if err != nil {
+				return err
+			}
+			if filepath.Join(baseDir, "logs") == logsDir {
+				refNames = append(refNames, refName)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Reading reflogs in %s", logsDir)
+		}
+	}
+	sort.Strings(refNames)
+	return refNames, nil
+}
+
+// Append an entry to the reflog of a ref, creating the reflog if needed. A
+// nil Committer is filled in from CommitterSignature. The message is
+// cleaned up as git does, with each run of whitespace made into one space.
+func (self *Repo) AppendReflog(refName string, entry *ReflogEntry) error {
+	logPath, err := self._refFilePath("logs", refName)
+	if err != nil {
+		return err
+	}
+	if !_isLowerHex(entry.OldSha1, 40) || !_isLowerHex(entry.NewSha1, 40) {
+		return errors.Errorf("Bad sha1 in reflog entry for %s: %s %s", refName, entry.OldSha1, entry.NewSha1)
+	}
+	committer := entry.Committer
+	if committer == nil {
+		committer, err = self.CommitterSignature()
+		if err != nil {
+			return err
+		}
+	}
+
+	line := entry.OldSha1 + " " + entry.NewSha1 + " " + committer.String()
+	if message := _cleanReflogMessage(entry.Message); message != "" {
+		line += "\t" + message
+	}
+	line += "\n"
+
+	err = os.MkdirAll(filepath.Dir(logPath), 0777)
+	if err != nil {
+		return errors.Wrapf(err, "Creating reflog of %s", refName)
+	}
+	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return errors.Wrapf(err, "Opening reflog of %s", refName)
+	}
+	// One write, so that concurrent appends do not interleave
+	_, err = file.Write([]byte(line))
+	if err != nil {
+		file.Close()
+		return errors.Wrapf(err, "Writing reflog of %s", refName)
+	}
+	err = file.Close()
+	if err != nil {
+		return errors.Wrapf(err, "Writing reflog of %s", refName)
+	}
+	return nil
+}
+
+// The identity git would record for a change made now: $GIT_COMMITTER_NAME
+// and $GIT_COMMITTER_EMAIL, or else user.name and user.email, at
+// $GIT_COMMITTER_DATE, given as "<seconds> <zone>", or else the current time
+func (self *Repo) CommitterSignature() (*Signature, error) {
+	return self._committerSignature(false)
+}
+
+// The identity for an entry in a reflog. Unlike CommitterSignature, it does
+// not fail when no identity is set, as git does not refuse to update a ref
+// over it: it falls back to the login's name and "<login>@<hostname>".
+func (self *Repo) _reflogCommitter() (*Signature, error) {
+	return self._committerSignature(true)
+}
+
+func (self *Repo) _committerSignature(fallback bool) (*Signature, error) {
+	config, err := self.Config()
+	if err != nil {
+		return nil, err
+	}
+	identity := make([]string, 2)
+	for i, names := range [][]string{{"GIT_COMMITTER_NAME", "user.name"}, {"GIT_COMMITTER_EMAIL", "user.email"}} {
+		value, ok := os.LookupEnv(names[0])
+		if !ok {
+			value, ok = config.Get(names[1])
+		}
+		if fallback {
+			if !ok || value == "" {
+				value = _defaultIdentity()[i]
+			}
+			// Characters which would break the line are dropped, as git
+			// drops them
+			value = strings.Map(func(r rune) rune {
+				if strings.ContainsRune("<>\n", r) {
+					return -1
+				}
+				return r
+			}, value)
+		} else if !ok || strings.ContainsAny(value, "<>\n") {
+			return nil, errors.Errorf("Committer identity unknown: set %s or %s", names[0], names[1])
+		}
+		identity[i] = value
+	}
+
+	date := os.Getenv("GIT_COMMITTER_DATE")
+	if date == "" {
+		return &Signature{Name: identity[0], Email: identity[1], When: time.Now()}, nil
+	}
+	signature, err := ParseSignature("<> " + strings.TrimPrefix(date, "@"))
+	if err != nil {
+		return nil, errors.Errorf("Unsupported GIT_COMMITTER_DATE '%s'", date)
+	}
+	signature.Name, signature.Email = identity[0], identity[1]
+	return signature, nil
+}
+
+// The name and email of the user running this process: the name from the
+// password database, or else the login, and "<login>@<hostname>"
+func _defaultIdentity() []string {
+	login, name := "unknown", ""
+	if current, err := user.Current(); err == nil {
+		login = current.Username
+		// The rest of the GECOS field, after a comma, is not the name
+		name = strings.SplitN(current.Name, ",", 2)[0]
+	}
+	if name == "" {
+		name = login
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "(none)"
+	}
+	return []string{name, login + "@" + hostname}
+}
+
+// Parse "<old> <new> <committer>\t<message>", or nil if it is malformed
+func _parseReflogEntry(line string) *ReflogEntry {
+	if len(line) < 82 || line[40] != ' ' || line[81] != ' ' ||
+		!_isLowerHex(line[:40], 40) || !_isLowerHex(line[41:81], 40) {
+		return nil
+	}
+	entry := &ReflogEntry{OldSha1: line[:40], NewSha1: line[41:81]}
+	committer := line[82:]
+	if tab := strings.IndexByte(committer, '\t'); tab >= 0 {
+		committer, entry.Message = committer[:tab], committer[tab+1:]
+	}
+	signature, err := ParseSignature(committer)
+	if err != nil {
+		return nil
+	}
+	entry.Committer = signature
+	return entry
+}
+
+// Make each run of ASCII whitespace, including newlines, into one space,
+// and trim the ends, as git does to reflog messages
+func _cleanReflogMessage(message string) string {
+	return strings.Join(strings.FieldsFunc(message, func(r rune) bool {
+		return strings.ContainsRune(" \t\n\v\f\r", r)
+	}), " ")
+}
@@ -0,0 +1,112 @@
+package gitobjects
+
+import (
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func (s *MySuite) TestReflog(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	runGitIn(c, repo, repoDir, "commit", "--allow-empty", "-m", "Second\n\nWith a body")
+	runGitIn(c, repo, repoDir, "checkout", "-q", "-b", "topic")
+	runGitIn(c, repo, repoDir, "reset", "-q", "--hard", "HEAD~1")
+
+	refNames, err := repo.ReflogRefs()
+	c.Assert(err, IsNil)
+	c.Check(refNames, DeepEquals, []string{"HEAD", "refs/heads/master", "refs/heads/topic"})
+
+	// Each entry formats back to its line
+	for _, refName := range refNames {
+		entries, err := repo.Reflog(refName)
+		c.Assert(err, IsNil)
+		contents, err := ioutil.ReadFile(filepath.Join(repo.GitDir(), "logs", refName))
+		c.Assert(err, IsNil)
+		lines := make([]string, len(entries))
+		for i, entry := range entries {
+			lines[i] = entry.OldSha1 + " " + entry.NewSha1 + " " + entry.Committer.String() + "\t" + entry.Message + "\n"
+		}
+		c.Check(strings.Join(lines, ""), Equals, string(contents), Commentf(refName))
+	}
+
+	entries, err := repo.Reflog("HEAD")
+	c.Assert(err, IsNil)
+	expected := runGitIn(c, repo, repoDir, "reflog", "show", "--format=%H %gn <%ge> %gs", "HEAD")
+	actual := make([]string, 0)
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		actual = append(actual, entry.NewSha1+" "+entry.Committer.Name+" <"+entry.Committer.Email+"> "+entry.Message)
+	}
+	c.Check(strings.Join(actual, "\n"), Equals, expected)
+	c.Check(entries[0].OldSha1, Equals, zeroSha1)
+	c.Check(entries[1].Message, Equals, "commit: Second")
+
+	// A ref without a reflog, and bad names
+	entries, err = repo.Reflog("refs/heads/no-such-branch")
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+	_, err = repo.Reflog("refs/heads/../../config")
+	c.Check(err, ErrorMatches, "Invalid ref name .*")
+
+	// Malformed lines are skipped
+	logPath := filepath.Join(repo.GitDir(), "logs", "refs", "heads", "topic")
+	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0)
+	c.Assert(err, IsNil)
+	_, err = file.WriteString("not a reflog line\n" + zeroSha1 + " " + zeroSha1 + " No Email 1 +0000\tx\n")
+	c.Assert(err, IsNil)
+	c.Assert(file.Close(), IsNil)
+	entries, err = repo.Reflog("refs/heads/topic")
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 2)
+}
+
+func (s *MySuite) TestAppendReflog(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	head, err := repo.ResolveRevision("HEAD")
+	c.Assert(err, IsNil)
+	defer setEnv(c, "GIT_COMMITTER_NAME", "Ref Updater")()
+	defer setEnv(c, "GIT_COMMITTER_EMAIL", "updater@example.com")()
+	defer setEnv(c, "GIT_COMMITTER_DATE", "1112911993 -0700")()
+
+	signature, err := repo.CommitterSignature()
+	c.Assert(err, IsNil)
+	c.Check(signature.String(), Equals, "Ref Updater <updater@example.com> 1112911993 -0700")
+
+	err = repo.AppendReflog("HEAD", &ReflogEntry{OldSha1: head, NewSha1: head, Message: "  moving:\n to\t here  "})
+	c.Assert(err, IsNil)
+	c.Check(runGitIn(c, repo, repoDir, "reflog", "show", "-1", "--format=%gs|%gn|%ge|%gd", "--date=raw", "HEAD"), Equals,
+		"moving: to here|Ref Updater|updater@example.com|HEAD@{1112911993 -0700}")
+
+	err = repo.AppendReflog("HEAD", &ReflogEntry{OldSha1: head, NewSha1: "bad"})
+	c.Check(err, ErrorMatches, "Bad sha1 in reflog entry .*")
+
+	// Any ref can be given a reflog
+	c.Assert(repo.AppendReflog("refs/tags/has-log", &ReflogEntry{OldSha1: zeroSha1, NewSha1: head}), IsNil)
+	refNames, err := repo.ReflogRefs()
+	c.Assert(err, IsNil)
+	c.Check(refNames, DeepEquals, []string{"HEAD", "refs/heads/master", "refs/tags/has-log"})
+}
+
+func (s *MySuite) TestWorktreeReflogs(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	worktreeDir := filepath.Join(c.MkDir(), "worktree")
+	runGitIn(c, repo, repoDir, "worktree", "add", "-q", "-b", "in-worktree", worktreeDir)
+	worktree, err := NewRepo(worktreeDir)
+	c.Assert(err, IsNil)
+
+	refNames, err := worktree.ReflogRefs()
+	c.Assert(err, IsNil)
+	c.Check(refNames, DeepEquals, []string{"HEAD", "refs/heads/in-worktree", "refs/heads/master"})
+
+	// HEAD is the worktree's own; branches are shared
+	entries, err := worktree.Reflog("HEAD")
+	c.Assert(err, IsNil)
+	expected := runGitIn(c, worktree, worktreeDir, "reflog", "show", "--format=%H", "HEAD")
+	c.Check(entries, HasLen, len(strings.Split(expected, "\n")))
+	c.Check(entries[0].OldSha1, Equals, zeroSha1)
+	entries, err = repo.Reflog("refs/heads/in-worktree")
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 1)
+}
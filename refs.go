@@ -0,0 +1,71 @@
+package gitobjects
+
+import (
+	"github.com/pkg/errors"
+	"path/filepath"
+	"strings"
+)
+
+// Check a ref name the way "git check-ref-format" does. Besides names under
+// "refs/", only one-level names in capitals, such as HEAD and ORIG_HEAD, are
+// allowed.
+func _checkRefName(refName string) error {
+	if !strings.HasPrefix(refName, "refs/") && !_isPseudoRefName(refName) {
+		return errors.Errorf("Invalid ref name '%s'", refName)
+	}
+	if refName == "@" || strings.HasSuffix(refName, ".") || strings.Contains(refName, "@{") {
+		return errors.Errorf("Invalid ref name '%s'", refName)
+	}
+	for _, component := range strings.Split(refName, "/") {
+		if component == "" || component[0] == '.' || strings.HasSuffix(component, ".lock") ||
+			strings.Contains(component, "..") {
+			return errors.Errorf("Invalid ref name '%s'", refName)
+		}
+		for i := 0; i < len(component); i++ {
+			c := component[i]
+			if c < ' ' || c == 0x7f || strings.IndexByte(" ~^:?*[\\", c) >= 0 {
+				return errors.Errorf("Invalid ref name '%s'", refName)
+			}
+		}
+	}
+	return nil
+}
+
+// HEAD, FETCH_HEAD, ORIG_HEAD and the like
+func _isPseudoRefName(refName string) bool {
+	if refName == "" {
+		return false
+	}
+	for i := 0; i < len(refName); i++ {
+		if !(_isUpper(refName[i]) || refName[i] == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// The directory that a ref and its reflog are kept under: the repository's
+// own git directory for HEAD and the refs that each worktree has its own
+// copy of, or else the directory shared by all worktrees
+func (self *Repo) _refBaseDir(refName string) (string, error) {
+	if !strings.HasPrefix(refName, "refs/") ||
+		strings.HasPrefix(refName, "refs/bisect/") ||
+		strings.HasPrefix(refName, "refs/worktree/") ||
+		strings.HasPrefix(refName, "refs/rewritten/") {
+		return self.gitDir, nil
+	}
+	return self._commonDir()
+}
+
+// The loose file of a ref, under a directory such as "" or "logs"
+func (self *Repo) _refFilePath(subdir string, refName string) (string, error) {
+	err := _checkRefName(refName)
+	if err != nil {
+		return "", err
+	}
+	baseDir, err := self._refBaseDir(refName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(baseDir, subdir, filepath.FromSlash(refName)), nil
+}
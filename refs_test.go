@@ -0,0 +1,22 @@
+package gitobjects
+
+import (
+	. "gopkg.in/check.v1"
+	"os/exec"
+)
+
+func (s *MySuite) TestCheckRefName(c *C) {
+	for _, refName := range []string{
+		"HEAD", "ORIG_HEAD", "refs/heads/main", "refs/heads/feature/x-1", "refs/tags/v1.0",
+		"refs/heads/a.b", "refs/heads/@", "refs/heads/with@sign",
+		"head", "main", "refs/heads/", "refs//heads/x", "refs/heads/.hidden", "refs/heads/x.lock",
+		"refs/heads/a..b", "refs/heads/x.", "refs/heads/a b", "refs/heads/a~1", "refs/heads/a^",
+		"refs/heads/a:b", "refs/heads/a?", "refs/heads/a*", "refs/heads/a[", "refs/heads/a\\b",
+		"refs/heads/a@{1}", "refs/heads/tab\t", "@",
+	} {
+		// Names outside refs/ are only checked by git with --allow-onelevel
+		err := exec.Command("git", "check-ref-format", "--allow-onelevel", refName).Run()
+		expected := err == nil && (refName == "HEAD" || refName == "ORIG_HEAD" || len(refName) > 5 && refName[:5] == "refs/")
+		c.Check(_checkRefName(refName) == nil, Equals, expected, Commentf("%q", refName))
+	}
+}
@@ -10,8 +10,15 @@ import (
 type Repo struct {
 	gitDir string
 
-	// Key = sha1, Value = *Tree
-	treeCache *treeCacheConcurrentSafe
+	// The top of the work tree; "" for a bare repository
+	workTree string
+
+	// Parsed Trees, Commits, Tags and Blobs, keyed by sha1
+	objectCache ObjectCache
+
+	// If true, subtrees are instantiated on first access instead
+	// of when their parent Tree is instantiated
+	lazyTrees bool
 }
 
 func NewRepo(directory string) (*Repo, error) {
@@ -47,9 +54,19 @@ func NewRepo(directory string) (*Repo, error) {
 		gitDir = filepath.Join(absDirectory, gitDir)
 	}
 
+	// A bare repository, or a directory inside the git dir, has no work tree
+	var workTree string
+	cmd = exec.Command("git", "rev-parse", "--show-toplevel")
+	cmd.Dir = directory
+	output, err = cmd.Output()
+	if err == nil {
+		workTree = strings.TrimRight(string(output), "\n")
+	}
+
 	return &Repo{
-		gitDir:    gitDir,
-		treeCache: NewTreeCache(),
+		gitDir:      gitDir,
+		workTree:    workTree,
+		objectCache: NewObjectCache(),
 	}, nil
 }
 
@@ -57,6 +74,134 @@ func (self *Repo) GitDir() string {
 	return self.gitDir
 }
 
+// The top of the work tree, or "" if the repository is bare
+func (self *Repo) WorkTree() string {
+	return self.workTree
+}
+
+// Replace the object cache, for example with one from NewBoundedObjectCache,
+// to limit how much memory is used while walking many commits.
+func (self *Repo) SetObjectCache(cache ObjectCache) {
+	self.objectCache = cache
+}
+
+// In lazy mode, instantiating a Tree reads only its own entries. Subtrees are
+// read on first access, via Entry.Tree(), Tree.Entries(), or a tree walk, so
+// sparse access to a huge tree only reads the parts that are needed.
+func (self *Repo) SetLazyTrees(lazy bool) {
+	self.lazyTrees = lazy
+}
+
+func (self *Repo) CacheStats() CacheStats {
+	return self.objectCache.Stats()
+}
+
+// Return the instantiated Commit for a sha1, from the cache if possible
+func (self *Repo) GetCommit(sha1 string) (*Commit, error) {
+	if obj, has := self.objectCache.Get(sha1); has {
+		commit, ok := obj.(*Commit)
+		if !ok {
+			return nil, errors.Errorf("Object %s is a %s, not a commit", sha1, obj.Type())
+		}
+		return commit, nil
+	}
+
+	commit := &Commit{
+		sha1: sha1,
+	}
+	err := commit.Instantiate(self)
+	if err != nil {
+		return nil, err
+	}
+	obj := self.objectCache.GetOrSet(sha1, commit)
+	if obj == commit {
+		self.objectCache.Resize(sha1, commit._estimatedSizeBytes())
+	}
+	return obj.(*Commit), nil
+}
+
+// Return the instantiated Tag for a sha1, from the cache if possible
+func (self *Repo) GetTag(sha1 string) (*Tag, error) {
+	if obj, has := self.objectCache.Get(sha1); has {
+		tag, ok := obj.(*Tag)
+		if !ok {
+			return nil, errors.Errorf("Object %s is a %s, not a tag", sha1, obj.Type())
+		}
+		return tag, nil
+	}
+
+	tag := &Tag{
+		sha1: sha1,
+	}
+	err := tag.Instantiate(self)
+	if err != nil {
+		return nil, err
+	}
+	obj := self.objectCache.GetOrSet(sha1, tag)
+	if obj == tag {
+		self.objectCache.Resize(sha1, tag._estimatedSizeBytes())
+	}
+	return obj.(*Tag), nil
+}
+
+// Return the instantiated Tree for a sha1, from the cache if possible
+func (self *Repo) GetTree(sha1 string) (*Tree, error) {
+	tree, err := self._cachedTree(sha1)
+	if err != nil {
+		return nil, err
+	}
+	err = tree.Instantiate(self)
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// Return the Blob for a sha1. Blobs are cached so that their
+// sizes only need to be looked up once.
+func (self *Repo) GetBlob(sha1 string) (*Blob, error) {
+	blob := &Blob{sha1: sha1}
+	obj := self.objectCache.GetOrSet(sha1, blob)
+	if obj == blob {
+		self.objectCache.Resize(sha1, blob._estimatedSizeBytes())
+	}
+	cached, ok := obj.(*Blob)
+	if !ok {
+		return nil, errors.Errorf("Object %s is a %s, not a blob", sha1, obj.Type())
+	}
+	return cached, nil
+}
+
+// Return the sha1 of the object named by a revision, such as "HEAD",
+// "main~2" or "v1.0". Tags are not peeled.
+func (self *Repo) ResolveRevision(revision string) (string, error) {
+	output, err := self.CmdOutput([]string{"rev-parse", "--verify", "--quiet", "--end-of-options", revision})
+	if err != nil {
+		return "", errors.Wrapf(err, "Resolving revision '%s'", revision)
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
+
+// Return the type of an object: "commit", "tree", "blob" or "tag"
+func (self *Repo) ObjectType(sha1 string) (string, error) {
+	output, err := self.CmdOutput([]string{"cat-file", "-t", sha1})
+	if err != nil {
+		return "", errors.Wrapf(err, "Getting object type for %s", sha1)
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
+
+// Return the cached Tree for a sha1, creating an uninstantiated
+// one if it is not in the cache.
+func (self *Repo) _cachedTree(sha1 string) (*Tree, error) {
+	obj := self.objectCache.GetOrSet(sha1, &Tree{sha1: sha1})
+	tree, ok := obj.(*Tree)
+	if !ok {
+		return nil, errors.Errorf("Object %s is a %s, not a tree", sha1, obj.Type())
+	}
+	return tree, nil
+}
+
 func (self *Repo) Command(cmdv []string) *exec.Cmd {
 	if len(cmdv) == 0 {
 		panic("Empty cmdv")
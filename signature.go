@@ -0,0 +1,76 @@
+package gitobjects
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The identity and time recorded in an author, committer or tagger line
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// Parse a signature such as "A U Thor <author@example.com> 1112911993 -0700".
+// A leading "author ", "committer " or "tagger " keyword is allowed.
+func ParseSignature(line string) (*Signature, error) {
+	for _, keyword := range []string{"author ", "committer ", "tagger "} {
+		if strings.HasPrefix(line, keyword) {
+			line = line[len(keyword):]
+			break
+		}
+	}
+
+	emailStart := strings.Index(line, "<")
+	emailEnd := strings.LastIndex(line, ">")
+	if emailStart < 0 || emailEnd < emailStart {
+		return nil, errors.Errorf("No <email> in signature: %s", line)
+	}
+	signature := &Signature{
+		Name:  strings.TrimSpace(line[:emailStart]),
+		Email: line[emailStart+1 : emailEnd],
+	}
+
+	fields := strings.Fields(line[emailEnd+1:])
+	if len(fields) != 2 {
+		return nil, errors.Errorf("No timestamp and timezone in signature: %s", line)
+	}
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Parsing timestamp in signature: %s", line)
+	}
+	zone := fields[1]
+	if len(zone) != 5 || (zone[0] != '+' && zone[0] != '-') {
+		return nil, errors.Errorf("Bad timezone '%s' in signature: %s", zone, line)
+	}
+	hours, err := strconv.Atoi(zone[1:3])
+	if err != nil {
+		return nil, errors.Wrapf(err, "Parsing timezone in signature: %s", line)
+	}
+	minutes, err := strconv.Atoi(zone[3:5])
+	if err != nil {
+		return nil, errors.Wrapf(err, "Parsing timezone in signature: %s", line)
+	}
+	offset := hours*3600 + minutes*60
+	if zone[0] == '-' {
+		offset = -offset
+	}
+	signature.When = time.Unix(seconds, 0).In(time.FixedZone(zone, offset))
+	return signature, nil
+}
+
+// Format the signature the way git stores it
+func (self *Signature) String() string {
+	_, offset := self.When.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("%s <%s> %d %c%02d%02d", self.Name, self.Email, self.When.Unix(),
+		sign, offset/3600, (offset%3600)/60)
+}
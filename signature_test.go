@@ -0,0 +1,25 @@
+package gitobjects
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestParseSignature(c *C) {
+	signature, err := ParseSignature("committer A U Thor <author@example.com> 1112911993 -0730")
+	c.Assert(err, IsNil)
+	c.Check(signature.Name, Equals, "A U Thor")
+	c.Check(signature.Email, Equals, "author@example.com")
+	c.Check(signature.When.Unix(), Equals, int64(1112911993))
+	_, offset := signature.When.Zone()
+	c.Check(offset, Equals, -(7*3600 + 30*60))
+
+	// It round-trips
+	c.Check(signature.String(), Equals, "A U Thor <author@example.com> 1112911993 -0730")
+
+	_, err = ParseSignature("A U Thor 1112911993 -0700")
+	c.Check(err, ErrorMatches, "No <email> .*")
+	_, err = ParseSignature("A U Thor <author@example.com>")
+	c.Check(err, ErrorMatches, "No timestamp .*")
+	_, err = ParseSignature("A U Thor <author@example.com> 1112911993 PST")
+	c.Check(err, ErrorMatches, "Bad timezone .*")
+}
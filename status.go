@@ -0,0 +1,494 @@
+package gitobjects
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// One side of a FileStatus, using the letters of "git status --porcelain"
+type StatusCode byte
+
+const (
+	StatusUnmodified  StatusCode = ' '
+	StatusModified    StatusCode = 'M'
+	StatusTypeChanged StatusCode = 'T'
+	StatusAdded       StatusCode = 'A'
+	StatusDeleted     StatusCode = 'D'
+	StatusUnmerged    StatusCode = 'U'
+	StatusUntracked   StatusCode = '?'
+	StatusIgnored     StatusCode = '!'
+)
+
+// The status of one path. Staged compares HEAD with the index, and Unstaged
+// compares the index with the work tree, as the X and Y columns of "git status
+// --porcelain" do; conflicts, untracked and ignored paths use the same
+// combinations as git. Untracked and ignored directories end in a slash.
+type FileStatus struct {
+	Path     string
+	Staged   StatusCode
+	Unstaged StatusCode
+}
+
+// The line "git status --porcelain" would print
+func (self *FileStatus) String() string {
+	return string([]byte{byte(self.Staged), byte(self.Unstaged)}) + " " + self.Path
+}
+
+func (self *FileStatus) IsConflicted() bool {
+	return self.Staged == StatusUnmerged || self.Unstaged == StatusUnmerged ||
+		(self.Staged == StatusAdded && self.Unstaged == StatusAdded) ||
+		(self.Staged == StatusDeleted && self.Unstaged == StatusDeleted)
+}
+
+// Does the index differ from HEAD?
+func (self *FileStatus) IsStaged() bool {
+	return !self.IsConflicted() && !self.IsUntracked() && !self.IsIgnored() &&
+		self.Staged != StatusUnmodified
+}
+
+// Does the work tree differ from the index?
+func (self *FileStatus) IsModified() bool {
+	return !self.IsConflicted() && !self.IsUntracked() && !self.IsIgnored() &&
+		self.Unstaged != StatusUnmodified
+}
+
+func (self *FileStatus) IsUntracked() bool {
+	return self.Staged == StatusUntracked
+}
+
+func (self *FileStatus) IsIgnored() bool {
+	return self.Staged == StatusIgnored
+}
+
+// Compare HEAD's tree, the index and the work tree, like "git status
+// --porcelain --ignored". Changed and conflicted paths come first, then
+// untracked paths, then ignored ones, each sorted by path. Files whose stat
+// data matches their index entry are not read. Renames are not detected, and
+// submodules are not looked into.
+func (self *Repo) Status(ctx context.Context) ([]*FileStatus, error) {
+	if self.workTree == "" {
+		return nil, errors.Errorf("Repository %s has no work tree", self.gitDir)
+	}
+	index, err := self.Index()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := self._expandSparseDirs(index.Entries)
+	if err != nil {
+		return nil, err
+	}
+
+	headEntries := make([]*IndexEntry, 0)
+	headSha1, err := self.ResolveRevision("HEAD")
+	if err == nil {
+		commit, err := self.GetCommit(headSha1)
+		if err != nil {
+			return nil, err
+		}
+		tree, err := self.GetTree(commit.TreeSha1())
+		if err != nil {
+			return nil, err
+		}
+		headIndex, err := NewIndexFromTree(self, tree)
+		if err != nil {
+			return nil, err
+		}
+		headEntries = headIndex.Entries
+	}
+
+	// Stat data is only trusted for files last changed before the index
+	// was written, as git does
+	var indexModTime int64
+	info, err := os.Stat(filepath.Join(self.gitDir, "index"))
+	if err == nil {
+		indexModTime = info.ModTime().UnixNano()
+	}
+
+	ignoreMatcher, err := self.NewIgnoreMatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	walker := &statusWalker{
+		ctx:           ctx,
+		repo:          self,
+		indexModTime:  indexModTime,
+		ignoreMatcher: ignoreMatcher,
+		tracked:       make(map[string]*IndexEntry),
+		trackedDirs:   make(map[string]bool),
+	}
+	changed, err := walker.CompareIndex(headEntries, entries)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		walker.tracked[entry.Path] = entry
+		for dir := entry.Path; strings.Contains(dir, "/"); {
+			dir = dir[:strings.LastIndexByte(dir, '/')]
+			walker.trackedDirs[dir] = true
+		}
+	}
+	err = walker.WalkDir("")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(walker.untracked, func(i, j int) bool {
+		return walker.untracked[i].Path < walker.untracked[j].Path
+	})
+	sort.Slice(walker.ignored, func(i, j int) bool {
+		return walker.ignored[i].Path < walker.ignored[j].Path
+	})
+	result := append(changed, walker.untracked...)
+	return append(result, walker.ignored...), nil
+}
+
+// Replace each sparse directory with the entries of its tree, which are
+// all skip-worktree
+func (self *Repo) _expandSparseDirs(entries []*IndexEntry) ([]*IndexEntry, error) {
+	expanded := make([]*IndexEntry, 0, len(entries))
+	sparse := false
+	for _, entry := range entries {
+		if !entry.IsSparseDir() {
+			expanded = append(expanded, entry)
+			continue
+		}
+		sparse = true
+		tree, err := self.GetTree(entry.Sha1)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Expanding sparse directory '%s'", entry.Path)
+		}
+		treeIndex, err := NewIndexFromTree(self, tree)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Expanding sparse directory '%s'", entry.Path)
+		}
+		for _, treeEntry := range treeIndex.Entries {
+			treeEntry.Path = entry.Path + treeEntry.Path
+			treeEntry.SkipWorktree = true
+			expanded = append(expanded, treeEntry)
+		}
+	}
+	if sparse {
+		sort.SliceStable(expanded, func(i, j int) bool {
+			return expanded[i].Path < expanded[j].Path
+		})
+	}
+	return expanded, nil
+}
+
+// Finds the status of each path
+type statusWalker struct {
+	ctx           context.Context
+	repo          *Repo
+	indexModTime  int64
+	ignoreMatcher *IgnoreMatcher
+
+	// Every path in the index, and every directory above one
+	tracked     map[string]*IndexEntry
+	trackedDirs map[string]bool
+
+	untracked []*FileStatus
+	ignored   []*FileStatus
+}
+
+// The file type bits of a mode
+const indexModeTypeMask = 0170000
+
+// Compare HEAD with the index, and the index with the work tree, for each
+// path in either of them
+func (self *statusWalker) CompareIndex(headEntries []*IndexEntry, entries []*IndexEntry) ([]*FileStatus, error) {
+	headByPath := make(map[string]*IndexEntry)
+	for _, entry := range headEntries {
+		headByPath[entry.Path] = entry
+	}
+
+	// The stages of each conflicted path
+	conflicts := make(map[string][]int)
+	for _, entry := range entries {
+		if entry.Stage > 0 {
+			conflicts[entry.Path] = append(conflicts[entry.Path], entry.Stage)
+		}
+	}
+
+	changed := make([]*FileStatus, 0)
+	inIndex := make(map[string]bool)
+	for _, entry := range entries {
+		if self.ctx.Err() != nil {
+			return nil, self.ctx.Err()
+		}
+		if inIndex[entry.Path] {
+			continue
+		}
+		inIndex[entry.Path] = true
+		if stages, has := conflicts[entry.Path]; has {
+			changed = append(changed, _conflictStatus(entry.Path, stages))
+			continue
+		}
+
+		status := &FileStatus{
+			Path:     entry.Path,
+			Staged:   StatusUnmodified,
+			Unstaged: StatusUnmodified,
+		}
+		headEntry, inHead := headByPath[entry.Path]
+		switch {
+		case entry.IntentToAdd:
+			// Not staged yet, and only in the work tree
+		case !inHead:
+			status.Staged = StatusAdded
+		case headEntry.Mode&indexModeTypeMask != entry.Mode&indexModeTypeMask:
+			status.Staged = StatusTypeChanged
+		case headEntry.Sha1 != entry.Sha1 || headEntry.Mode != entry.Mode:
+			status.Staged = StatusModified
+		}
+
+		var err error
+		status.Unstaged, err = self._compareWorkTree(entry)
+		if err != nil {
+			return nil, err
+		}
+		if status.Staged != StatusUnmodified || status.Unstaged != StatusUnmodified {
+			changed = append(changed, status)
+		}
+	}
+
+	for _, headEntry := range headEntries {
+		if !inIndex[headEntry.Path] {
+			changed = append(changed, &FileStatus{
+				Path:     headEntry.Path,
+				Staged:   StatusDeleted,
+				Unstaged: StatusUnmodified,
+			})
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		return changed[i].Path < changed[j].Path
+	})
+	return changed, nil
+}
+
+// The codes git uses for each combination of stages: 1 is the common
+// ancestor, 2 is ours and 3 is theirs
+func _conflictStatus(path string, stages []int) *FileStatus {
+	var has [4]bool
+	for _, stage := range stages {
+		has[stage] = true
+	}
+	status := &FileStatus{Path: path}
+	switch {
+	case has[1] && !has[2] && !has[3]:
+		status.Staged, status.Unstaged = StatusDeleted, StatusDeleted
+	case !has[1] && has[2] && !has[3]:
+		status.Staged, status.Unstaged = StatusAdded, StatusUnmerged
+	case has[1] && has[2] && !has[3]:
+		status.Staged, status.Unstaged = StatusUnmerged, StatusDeleted
+	case !has[1] && !has[2] && has[3]:
+		status.Staged, status.Unstaged = StatusUnmerged, StatusAdded
+	case has[1] && !has[2] && has[3]:
+		status.Staged, status.Unstaged = StatusDeleted, StatusUnmerged
+	case !has[1] && has[2] && has[3]:
+		status.Staged, status.Unstaged = StatusAdded, StatusAdded
+	default:
+		status.Staged, status.Unstaged = StatusUnmerged, StatusUnmerged
+	}
+	return status
+}
+
+// Compare an index entry with the file in the work tree, only reading the
+// file if its stat data does not match
+func (self *statusWalker) _compareWorkTree(entry *IndexEntry) (StatusCode, error) {
+	// Submodules, and paths outside a sparse checkout, are not looked at
+	if entry.SkipWorktree || entry.Mode&indexModeTypeMask == 0160000 {
+		return StatusUnmodified, nil
+	}
+	fullPath := filepath.Join(self.repo.workTree, filepath.FromSlash(entry.Path))
+	info, err := os.Lstat(fullPath)
+	if os.IsNotExist(err) || _isNotDir(err) || (err == nil && info.IsDir()) {
+		return StatusDeleted, nil
+	} else if err != nil {
+		return 0, errors.Wrapf(err, "Checking '%s'", entry.Path)
+	}
+	if entry.IntentToAdd {
+		return StatusAdded, nil
+	}
+
+	isSymlink := info.Mode()&os.ModeSymlink != 0
+	if isSymlink != (entry.Mode&indexModeTypeMask == 0120000) {
+		return StatusTypeChanged, nil
+	}
+	// Only the owner's execute bit counts, as in Index.Add
+	if !isSymlink && (info.Mode()&0100 != 0) != (entry.Mode == 0100755) {
+		return StatusModified, nil
+	}
+
+	current := &IndexEntry{
+		MTime: info.ModTime(),
+		Size:  uint32(info.Size()),
+	}
+	_fillIndexEntrySysStat(current, info)
+	if current.MTime.Equal(entry.MTime) && current.CTime.Equal(entry.CTime) && current.Size == entry.Size &&
+		current.Ino == entry.Ino && current.Dev == entry.Dev && current.UID == entry.UID &&
+		current.GID == entry.GID && entry.MTime.UnixNano() < self.indexModTime {
+		return StatusUnmodified, nil
+	}
+
+	var contents []byte
+	if isSymlink {
+		target, err := os.Readlink(fullPath)
+		if err != nil {
+			return 0, errors.Wrapf(err, "Reading link '%s'", entry.Path)
+		}
+		contents = []byte(target)
+	} else {
+		contents, err = ioutil.ReadFile(fullPath)
+		if err != nil {
+			return 0, errors.Wrapf(err, "Reading '%s'", entry.Path)
+		}
+	}
+	hasher := sha1Hasher("blob", int64(len(contents)))
+	hasher.Write(contents)
+	if hex.EncodeToString(hasher.Sum(nil)) != entry.Sha1 {
+		return StatusModified, nil
+	}
+	return StatusUnmodified, nil
+}
+
+// A file where a directory was expected, as in "a/b" when "a" is a file
+func _isNotDir(err error) bool {
+	return errors.Is(err, syscall.ENOTDIR)
+}
+
+// Find the untracked and ignored paths under a directory which has
+// tracked files in it; "" is the top of the work tree
+func (self *statusWalker) WalkDir(dir string) error {
+	if self.ctx.Err() != nil {
+		return self.ctx.Err()
+	}
+	infos, err := ioutil.ReadDir(filepath.Join(self.repo.workTree, filepath.FromSlash(dir)))
+	if err != nil {
+		return errors.Wrapf(err, "Reading directory '%s'", dir)
+	}
+	for _, info := range infos {
+		if info.Name() == ".git" {
+			continue
+		}
+		path := info.Name()
+		if dir != "" {
+			path = dir + "/" + info.Name()
+		}
+
+		if !info.IsDir() {
+			if _, has := self.tracked[path]; has {
+				continue
+			}
+			ignored, err := self.ignoreMatcher.IsIgnored(path, false)
+			if err != nil {
+				return err
+			}
+			self._add(path, ignored)
+			continue
+		}
+
+		if entry, has := self.tracked[path]; has && entry.Mode&indexModeTypeMask == 0160000 {
+			continue
+		}
+		if self.trackedDirs[path] {
+			err = self.WalkDir(path)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// An untracked directory is shown as one path, with any ignored
+		// paths in it, unless everything in it is ignored
+		ignored, err := self.ignoreMatcher.IsIgnored(path, true)
+		if err != nil {
+			return err
+		}
+		if ignored {
+			self._add(path+"/", true)
+			continue
+		}
+		hasUntracked, ignoredPaths, err := self._collectUntrackedDir(path)
+		if err != nil {
+			return err
+		}
+		if hasUntracked {
+			self._add(path+"/", false)
+			for _, ignoredPath := range ignoredPaths {
+				self._add(ignoredPath, true)
+			}
+		} else if len(ignoredPaths) > 0 {
+			self._add(path+"/", true)
+		}
+	}
+	return nil
+}
+
+func (self *statusWalker) _add(path string, ignored bool) {
+	if ignored {
+		self.ignored = append(self.ignored, &FileStatus{Path: path, Staged: StatusIgnored, Unstaged: StatusIgnored})
+	} else {
+		self.untracked = append(self.untracked, &FileStatus{Path: path, Staged: StatusUntracked, Unstaged: StatusUntracked})
+	}
+}
+
+// Look inside an untracked directory, which is not ignored itself, to see
+// whether it has any untracked files, and which paths in it are ignored.
+// A nested repository counts as untracked, and is not looked into.
+func (self *statusWalker) _collectUntrackedDir(dir string) (bool, []string, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(self.repo.workTree, filepath.FromSlash(dir)))
+	if err != nil {
+		return false, nil, errors.Wrapf(err, "Reading directory '%s'", dir)
+	}
+	hasUntracked := false
+	ignoredPaths := make([]string, 0)
+	for _, info := range infos {
+		if info.Name() == ".git" {
+			return true, []string{}, nil
+		}
+	}
+	for _, info := range infos {
+		path := dir + "/" + info.Name()
+		if !info.IsDir() {
+			ignored, err := self.ignoreMatcher.IsIgnored(path, false)
+			if err != nil {
+				return false, nil, err
+			}
+			if ignored {
+				ignoredPaths = append(ignoredPaths, path)
+			} else {
+				hasUntracked = true
+			}
+			continue
+		}
+
+		ignored, err := self.ignoreMatcher.IsIgnored(path, true)
+		if err != nil {
+			return false, nil, err
+		}
+		if ignored {
+			ignoredPaths = append(ignoredPaths, path+"/")
+			continue
+		}
+		subdirHasUntracked, subdirIgnoredPaths, err := self._collectUntrackedDir(path)
+		if err != nil {
+			return false, nil, err
+		}
+		if subdirHasUntracked {
+			hasUntracked = true
+			ignoredPaths = append(ignoredPaths, subdirIgnoredPaths...)
+		} else if len(subdirIgnoredPaths) > 0 {
+			ignoredPaths = append(ignoredPaths, path+"/")
+		}
+	}
+	return hasUntracked, ignoredPaths, nil
+}
@@ -0,0 +1,120 @@
+package gitobjects
+
+import (
+	"context"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Check that Status agrees with "git status --porcelain --ignored"
+func checkStatusMatchesGit(c *C, repo *Repo, repoDir string) []*FileStatus {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	defer cancelFunc()
+	statuses, err := repo.Status(ctx)
+	c.Assert(err, IsNil)
+
+	// Status does not refresh the index, so this is run after it
+	expected := runGitIn(c, repo, repoDir, "-c", "status.renames=false", "status", "--porcelain", "--ignored")
+	lines := make([]string, len(statuses))
+	for i, status := range statuses {
+		lines[i] = status.String()
+	}
+	c.Check(strings.Join(lines, "\n"), Equals, expected)
+	return statuses
+}
+
+func writeFiles(c *C, repoDir string, files map[string]string) {
+	for path, contents := range files {
+		fullPath := filepath.Join(repoDir, filepath.FromSlash(path))
+		c.Assert(os.MkdirAll(filepath.Dir(fullPath), 0777), IsNil)
+		c.Assert(ioutil.WriteFile(fullPath, []byte(contents), 0666), IsNil)
+	}
+}
+
+func (s *MySuite) TestStatus(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+	c.Check(len(checkStatusMatchesGit(c, repo, repoDir)), Equals, 0)
+
+	writeFiles(c, repoDir, map[string]string{
+		".gitignore":         "*.log\n!keep.log\nbuild/\n",
+		"README":             "staged\n",
+		"NEW":                "new\n",
+		"src/main.go":        "modified\n",
+		"src/keep.log":       "kept\n",
+		"src/debug.log":      "ignored\n",
+		"untracked/file":     "untracked\n",
+		"untracked/a.log":    "ignored\n",
+		"all-ignored/a.log":  "ignored\n",
+		"build/output":       "ignored\n",
+		"docs/api/extra.txt": "untracked\n",
+	})
+	c.Assert(os.MkdirAll(filepath.Join(repoDir, "empty"), 0777), IsNil)
+	runGitIn(c, repo, repoDir, "add", "README", "NEW")
+	writeFiles(c, repoDir, map[string]string{"README": "staged and modified\n"})
+	c.Assert(os.Chmod(filepath.Join(repoDir, "docs", "api", "index.md"), 0755), IsNil)
+	runGitIn(c, repo, repoDir, "rm", "-q", "--cached", "docs/api/index.md")
+
+	statuses := checkStatusMatchesGit(c, repo, repoDir)
+	byPath := make(map[string]*FileStatus)
+	for _, status := range statuses {
+		byPath[status.Path] = status
+	}
+	c.Check(byPath["README"].IsStaged(), Equals, true)
+	c.Check(byPath["README"].IsModified(), Equals, true)
+	c.Check(byPath["NEW"].Staged, Equals, StatusAdded)
+	c.Check(byPath["src/main.go"].IsStaged(), Equals, false)
+	c.Check(byPath["src/main.go"].IsModified(), Equals, true)
+	c.Check(byPath["untracked/"].IsUntracked(), Equals, true)
+	c.Check(byPath["build/"].IsIgnored(), Equals, true)
+
+	// A file deleted from the work tree, and one replaced by a symlink
+	c.Assert(os.Remove(filepath.Join(repoDir, "NEW")), IsNil)
+	c.Assert(os.Remove(filepath.Join(repoDir, "src", "main.go")), IsNil)
+	c.Assert(os.Symlink("../README", filepath.Join(repoDir, "src", "main.go")), IsNil)
+	statuses = checkStatusMatchesGit(c, repo, repoDir)
+	c.Check(statuses[0].String(), Equals, "AD NEW")
+}
+
+func (s *MySuite) TestStatusExecutableBit(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+
+	// Executable by its group, but not by its owner, is not a change
+	readme := filepath.Join(repoDir, "README")
+	c.Assert(os.Chmod(readme, 0654), IsNil)
+	c.Check(checkStatusMatchesGit(c, repo, repoDir), HasLen, 0)
+
+	c.Assert(os.Chmod(readme, 0744), IsNil)
+	statuses := checkStatusMatchesGit(c, repo, repoDir)
+	c.Assert(statuses, HasLen, 1)
+	c.Check(statuses[0].String(), Equals, " M README")
+}
+
+func (s *MySuite) TestStatusConflicts(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	runGitIn(c, repo, repoDir, "checkout", "-q", "-b", "other")
+	writeFiles(c, repoDir, map[string]string{"README": "other\n", "BOTH": "other\n"})
+	runGitIn(c, repo, repoDir, "add", "README", "BOTH")
+	runGitIn(c, repo, repoDir, "commit", "-q", "-m", "Other")
+	runGitIn(c, repo, repoDir, "checkout", "-q", "-")
+	writeFiles(c, repoDir, map[string]string{"BOTH": "mine\n"})
+	runGitIn(c, repo, repoDir, "rm", "-q", "README")
+	runGitIn(c, repo, repoDir, "add", "BOTH")
+	runGitIn(c, repo, repoDir, "commit", "-q", "-m", "Mine")
+	cmd := repo.Command([]string{"merge", "other"})
+	cmd.Dir = repoDir
+	c.Assert(cmd.Run(), NotNil)
+
+	statuses := checkStatusMatchesGit(c, repo, repoDir)
+	c.Assert(len(statuses), Equals, 2)
+	c.Check(statuses[0].String(), Equals, "AA BOTH")
+	c.Check(statuses[1].String(), Equals, "DU README")
+	for _, status := range statuses {
+		c.Check(status.IsConflicted(), Equals, true)
+		c.Check(status.IsStaged(), Equals, false)
+	}
+}
@@ -0,0 +1,99 @@
+package gitobjects
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// An annotated tag
+type Tag struct {
+	sha1       string
+	objectSha1 string
+	objectType string
+	name       string
+	taggerLine string
+	msg        string
+}
+
+func (self *Tag) Type() string {
+	return "tag"
+}
+
+func (self *Tag) Sha1() string {
+	return self.sha1
+}
+
+func (self *Tag) Instantiate(repo *Repo) error {
+	if self.sha1 == "" {
+		panic("Instantiate called on Tag that has no sha1")
+	}
+	output, err := repo.CmdOutput([]string{"cat-file", "tag", self.sha1})
+	if err != nil {
+		return errors.Wrapf(err, "Calling cat-file tag on %s", self.sha1)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	inHeader := true
+	readFirstMessageLine := false
+	for scanner.Scan() {
+		if inHeader {
+			fields := strings.SplitN(scanner.Text(), " ", 2)
+			switch fields[0] {
+			case "object":
+				self.objectSha1 = fields[1]
+			case "type":
+				self.objectType = fields[1]
+			case "tag":
+				self.name = fields[1]
+			case "tagger":
+				self.taggerLine = scanner.Text()
+			case "":
+				inHeader = false
+			}
+		} else {
+			// Same reconstruction as for Commit messages
+			if readFirstMessageLine {
+				self.msg += "\n" + scanner.Text()
+			} else {
+				readFirstMessageLine = true
+				self.msg = scanner.Text()
+			}
+		}
+	}
+
+	// Scanner error?
+	err = scanner.Err()
+	if err != nil {
+		return errors.Wrapf(err, "Scanning cat-file tag %s output", self.sha1)
+	}
+
+	return nil
+}
+
+// The name of the tag, as recorded in the tag object
+func (self *Tag) Name() string {
+	return self.name
+}
+
+func (self *Tag) Message() string {
+	return self.msg
+}
+
+// The sha1 of the object which is tagged
+func (self *Tag) ObjectSha1() string {
+	return self.objectSha1
+}
+
+// The type of the object which is tagged
+func (self *Tag) ObjectType() string {
+	return self.objectType
+}
+
+// A rough estimate of how much memory this Tag uses, for the
+// benefit of the object cache's byte budget.
+func (self *Tag) _estimatedSizeBytes() int64 {
+	const tagOverhead = 128
+	return int64(tagOverhead + len(self.sha1) + len(self.objectSha1) + len(self.objectType) +
+		len(self.name) + len(self.taggerLine) + len(self.msg))
+}
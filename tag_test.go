@@ -0,0 +1,30 @@
+package gitobjects
+
+import (
+	. "gopkg.in/check.v1"
+	"strings"
+)
+
+func (s *MySuite) TestTagInstantiate(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+
+	cmd := repo.Command([]string{"tag", "-a", "-m", "First release\n\nWith notes", "v1.0"})
+	cmd.Dir = repoDir
+	err := cmd.Run()
+	c.Assert(err, IsNil)
+
+	output, err := repo.CmdOutput([]string{"rev-parse", "v1.0"})
+	c.Assert(err, IsNil)
+	tagSha1 := strings.TrimRight(string(output), "\n")
+	output, err = repo.CmdOutput([]string{"rev-parse", "HEAD"})
+	c.Assert(err, IsNil)
+	headSha1 := strings.TrimRight(string(output), "\n")
+
+	tag, err := repo.GetTag(tagSha1)
+	c.Assert(err, IsNil)
+	c.Check(tag.Type(), Equals, "tag")
+	c.Check(tag.Name(), Equals, "v1.0")
+	c.Check(tag.ObjectType(), Equals, "commit")
+	c.Check(tag.ObjectSha1(), Equals, headSha1)
+	c.Check(tag.Message(), Equals, "First release\n\nWith notes")
+}
@@ -1,8 +1,10 @@
 package gitobjects
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
 	"github.com/pkg/errors"
 	"path/filepath"
 	"strings"
@@ -16,6 +18,22 @@ type Tree struct {
 	instantiated bool
 }
 
+// The error returned when a path does not exist in a Tree
+type PathNotFoundError struct {
+	Path     string
+	TreeSha1 string
+}
+
+func (self *PathNotFoundError) Error() string {
+	return fmt.Sprintf("Path '%s' not found in tree %s", self.Path, self.TreeSha1)
+}
+
+// Is the error, or its cause, a PathNotFoundError?
+func IsPathNotFound(err error) bool {
+	_, ok := errors.Cause(err).(*PathNotFoundError)
+	return ok
+}
+
 type BlobPath struct {
 	Blob *Blob
 	Path string
@@ -30,6 +48,12 @@ func (self *Tree) Sha1() string {
 }
 
 func (self *Tree) Instantiate(repo *Repo) error {
+	return self._instantiate(repo, !repo.lazyTrees)
+}
+
+// Read the entries of the tree. If recursive, subtrees are instantiated too;
+// otherwise they are left for Entry.Tree() to instantiate on first access.
+func (self *Tree) _instantiate(repo *Repo, recursive bool) error {
 	self.Lock()
 	defer self.Unlock()
 
@@ -39,40 +63,41 @@ func (self *Tree) Instantiate(repo *Repo) error {
 	if self.sha1 == "" {
 		panic("Instantiate called on Tree that has no sha1")
 	}
-	output, err := repo.CmdOutput([]string{"cat-file", "-p", self.sha1})
+	// The raw form is used, as "cat-file -p" quotes unusual names
+	contents, err := repo.CmdOutput([]string{"cat-file", "tree", self.sha1})
 	if err != nil {
-		return errors.Wrapf(err, "Calling cat-file commit on %s", self.sha1)
+		return errors.Wrapf(err, "Calling cat-file tree on %s", self.sha1)
 	}
-	scanner := bufio.NewScanner(bytes.NewReader(output))
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line[52] != '\t' {
-			return errors.Errorf("No tab at pos=52 in line for cat-file of tree %s: %s",
-				self.sha1, line)
+	// Only kept if the whole tree can be read
+	entries := make([]*Entry, 0)
+	for len(contents) > 0 {
+		var mode, name, entrySha1 string
+		mode, name, entrySha1, contents, err = _nextRawTreeEntry(contents)
+		if err != nil {
+			return errors.Wrapf(err, "Parsing tree %s", self.sha1)
 		}
-		// permissions, entryType, sha1
-		fields := strings.Split(line[:52], " ")
-		name := line[53:]
-		permissions := fields[0]
-		type_ := fields[1]
-		entrySha1 := fields[2]
+		if name == "" {
+			return errors.Errorf("Entry with an empty name in tree %s", self.sha1)
+		}
+		type_ := _treeEntryType(mode)
 		entry := &Entry{
-			sha1:        entrySha1,
-			permissions: permissions,
+			sha1: entrySha1,
+			// As "cat-file -p" shows it
+			permissions: fmt.Sprintf("%06s", mode),
 			name:        name,
 		}
 
 		switch type_ {
 		case "tree":
 			// XXX - add switch to use or not use cache?
-			entryTree, has := repo.treeCache.Get(entrySha1)
-			if !has {
-				entryTree = &Tree{
-					sha1: entrySha1,
-				}
-				repo.treeCache.Set(entrySha1, entryTree)
-				err := entryTree.Instantiate(repo)
+			entryTree, err := repo._cachedTree(entrySha1)
+			if err != nil {
+				return err
+			}
+			// In lazy mode, subtrees are instantiated on first access
+			if recursive {
+				err = entryTree._instantiate(repo, recursive)
 				if err != nil {
 					return errors.Wrapf(err, "Instanting tree %s", entrySha1)
 				}
@@ -82,60 +107,179 @@ func (self *Tree) Instantiate(repo *Repo) error {
 			entry.blob = &Blob{
 				sha1: entrySha1,
 			}
-		default:
-			panic("cannot reach")
+		case "commit":
+			entry.gitlink = true
 		}
-		self.entries = append(self.entries, entry)
+		entries = append(entries, entry)
 	}
 
-	// Scanner error?
-	err = scanner.Err()
-	if err != nil {
-		return errors.Wrapf(err, "Scanning cat-file tree %s output", self.sha1)
-	}
+	self.entries = entries
 	self.instantiated = true
+	repo.objectCache.Resize(self.sha1, self._estimatedSizeBytes())
 	return nil
 }
 
-func (self *Tree) StreamBlobPathsUnique(repo *Repo, sha1sSeen map[string]bool) (<-chan *BlobPath, <-chan error) {
+// Split the first "<mode> <name>\0<20-byte sha1>" entry off a raw tree
+func _nextRawTreeEntry(contents []byte) (string, string, string, []byte, error) {
+	space := bytes.IndexByte(contents, ' ')
+	nul := bytes.IndexByte(contents, 0)
+	if space < 0 || nul < space || nul+21 > len(contents) {
+		return "", "", "", nil, errors.New("Truncated or malformed tree entry")
+	}
+	mode := string(contents[:space])
+	name := string(contents[space+1 : nul])
+	sha1 := hex.EncodeToString(contents[nul+1 : nul+21])
+	return mode, name, sha1, contents[nul+21:], nil
+}
+
+// The type of object a tree entry points to, judging by its mode
+func _treeEntryType(mode string) string {
+	switch strings.TrimLeft(mode, "0") {
+	case "40000":
+		return "tree"
+	case "160000":
+		return "commit"
+	default:
+		return "blob"
+	}
+}
+
+// A rough estimate of how much memory this Tree uses, for the
+// benefit of the tree cache's byte budget. The lock must be held.
+func (self *Tree) _estimatedSizeBytes() int64 {
+	const treeOverhead = 64
+	const entryOverhead = 96
+	size := int64(treeOverhead + len(self.sha1))
+	for _, entry := range self.entries {
+		size += int64(entryOverhead + len(entry.sha1) + len(entry.permissions) + len(entry.name))
+	}
+	return size
+}
+
+// Has this Tree read its entries from disk yet?
+func (self *Tree) IsInstantiated() bool {
 	self.RLock()
 	defer self.RUnlock()
+	return self.instantiated
+}
 
+// Return the entries of this Tree, instantiating it if needed
+func (self *Tree) Entries(repo *Repo) ([]*Entry, error) {
+	err := self.Instantiate(repo)
+	if err != nil {
+		return nil, err
+	}
+	self.RLock()
+	defer self.RUnlock()
+	return self.entries, nil
+}
+
+// Find the Entry at a slash-separated path, such as "docs/api/index.md",
+// relative to this Tree. Only the trees along the path are instantiated,
+// so this is cheap in lazy mode. If nothing exists at the path, the error
+// is a *PathNotFoundError.
+func (self *Tree) Lookup(repo *Repo, path string) (*Entry, error) {
+	components := strings.Split(strings.Trim(path, "/"), "/")
+	if len(components) == 1 && components[0] == "" {
+		return nil, errors.Errorf("Empty path given to Lookup in tree %s", self.sha1)
+	}
+
+	tree := self
+	var entry *Entry
+	for i, component := range components {
+		// A file where a directory should be, such as "README/foo"
+		if i > 0 {
+			if entry.Type() != "tree" {
+				return nil, &PathNotFoundError{Path: path, TreeSha1: self.sha1}
+			}
+			var err error
+			tree, err = entry.Tree(repo)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entries, err := tree.Entries(repo)
+		if err != nil {
+			return nil, err
+		}
+		entry = nil
+		for _, candidate := range entries {
+			if candidate.name == component {
+				entry = candidate
+				break
+			}
+		}
+		if entry == nil {
+			return nil, &PathNotFoundError{Path: path, TreeSha1: self.sha1}
+		}
+	}
+	return entry, nil
+}
+
+func (self *Tree) StreamBlobPathsUnique(repo *Repo, sha1sSeen map[string]bool) (<-chan *BlobPath, <-chan error) {
+	return self._startBlobPathsUnique(context.Background(), repo, sha1sSeen, nil)
+}
+
+// Start the walk in a goroutine, which stops early if the context is canceled
+func (self *Tree) _startBlobPathsUnique(ctx context.Context, repo *Repo, sha1sSeen map[string]bool,
+	tracker *progressTracker) (<-chan *BlobPath, <-chan error) {
 	blobPathChan := make(chan *BlobPath)
-	errorChan := make(chan error)
 
-	go self._streamBlobPathsUnique("", repo, sha1sSeen, blobPathChan, errorChan)
+	// Buffered so the single error can be sent before blobPathChan is closed
+	errorChan := make(chan error, 1)
+
+	go func() {
+		defer close(errorChan)
+		defer close(blobPathChan)
+		tracker.Start()
+		err := self._streamBlobPathsUnique(ctx, "", repo, sha1sSeen, blobPathChan, tracker)
+		tracker.Stop()
+		if err != nil {
+			errorChan <- err
+		}
+	}()
 	return blobPathChan, errorChan
 }
 
-func (self *Tree) _streamBlobPathsUnique(parentPath string, repo *Repo, sha1sSeen map[string]bool,
-	blobPathChan chan<- *BlobPath, errorChan chan<- error) {
-	// Only the top-most function in the call-stack can close these channels
-	if parentPath == "" {
-		defer close(blobPathChan)
-		defer close(errorChan)
+func (self *Tree) _streamBlobPathsUnique(ctx context.Context, parentPath string, repo *Repo,
+	sha1sSeen map[string]bool, blobPathChan chan<- *BlobPath, tracker *progressTracker) error {
+
+	// Lazy trees are instantiated as they are reached
+	entries, err := self.Entries(repo)
+	if err != nil {
+		return errors.Wrapf(err, "Instantiating tree %s at '%s'", self.sha1, parentPath)
 	}
 
-	for _, entry := range self.entries {
+	for _, entry := range entries {
 		// Already seen it?
 		if _, ok := sha1sSeen[entry.Sha1()]; ok {
 			continue
 		}
 		sha1sSeen[entry.Sha1()] = true
+		tracker.ObjectSeen(0)
 		if entry.Type() == "blob" {
 			blobPath := &BlobPath{
 				Blob: entry.blob,
 				Path: filepath.Join(parentPath, entry.name),
 			}
-			blobPathChan <- blobPath
+			select {
+			case blobPathChan <- blobPath:
+				tracker.ObjectMatched()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		} else if entry.Type() == "tree" {
 			nextPath := filepath.Join(parentPath, entry.name)
-			if !entry.tree.instantiated {
-				panic("not reached")
+			err = entry.tree._streamBlobPathsUnique(ctx, nextPath, repo, sha1sSeen, blobPathChan, tracker)
+			if err != nil {
+				return err
 			}
-			entry.tree._streamBlobPathsUnique(nextPath, repo, sha1sSeen, blobPathChan, errorChan)
+		} else if entry.Type() == "commit" {
+			// Submodule contents are in another repository
+			continue
 		} else {
 			panic("cannot reach")
 		}
 	}
+	return nil
 }
@@ -3,6 +3,10 @@ package gitobjects
 import (
 	"context"
 	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -97,3 +101,111 @@ func (s *MySuite) TestStreamBlobPathsUnique(c *C) {
 	c.Assert(len(blobPaths), Equals, 1)
 	c.Check(blobPaths[0].Path, Equals, "README")
 }
+
+func addNestedFiles(c *C, repo *Repo, repoDir string) {
+	// Create files in a few levels of subdirectories
+	for _, path := range []string{"src/main.go", "docs/api/index.md"} {
+		fullPath := filepath.Join(repoDir, path)
+		err := os.MkdirAll(filepath.Dir(fullPath), 0777)
+		c.Assert(err, IsNil)
+		err = ioutil.WriteFile(fullPath, []byte(path+"\n"), 0666)
+		c.Assert(err, IsNil)
+	}
+
+	// Commit them
+	cmd := repo.Command([]string{"add", "src", "docs"})
+	cmd.Dir = repoDir
+	err := cmd.Run()
+	c.Assert(err, IsNil)
+	cmd = repo.Command([]string{"commit", "-m", "Add src and docs"})
+	cmd.Dir = repoDir
+	err = cmd.Run()
+	c.Assert(err, IsNil)
+}
+
+func (s *MySuite) TestLazyTrees(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+	repo.SetLazyTrees(true)
+
+	output, err := repo.CmdOutput([]string{"rev-parse", "HEAD^{tree}"})
+	c.Assert(err, IsNil)
+	rootTree, err := repo.GetTree(strings.TrimRight(string(output), "\n"))
+	c.Assert(err, IsNil)
+
+	entries, err := rootTree.Entries(repo)
+	c.Assert(err, IsNil)
+	c.Assert(len(entries), Equals, 3)
+
+	// Only the root has been read
+	docsEntry := entries[1]
+	c.Assert(docsEntry.Name(), Equals, "docs")
+	c.Check(docsEntry.tree.IsInstantiated(), Equals, false)
+	c.Check(entries[2].tree.IsInstantiated(), Equals, false)
+
+	// Reading docs/ does not read docs/api/ or src/
+	docsTree, err := docsEntry.Tree(repo)
+	c.Assert(err, IsNil)
+	c.Check(docsTree.IsInstantiated(), Equals, true)
+	c.Check(docsTree.entries[0].tree.IsInstantiated(), Equals, false)
+	c.Check(entries[2].tree.IsInstantiated(), Equals, false)
+
+	// A full walk instantiates everything it needs
+	blobPathChan, errorChan := rootTree.StreamBlobPathsUnique(repo, make(map[string]bool))
+	paths := make([]string, 0, 3)
+	for blobPath := range blobPathChan {
+		paths = append(paths, blobPath.Path)
+	}
+	c.Assert(<-errorChan, IsNil)
+	c.Check(paths, DeepEquals, []string{"README", "docs/api/index.md", "src/main.go"})
+	c.Check(entries[2].tree.IsInstantiated(), Equals, true)
+}
+
+func (s *MySuite) TestTreeLookup(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNestedFiles(c, repo, repoDir)
+
+	output, err := repo.CmdOutput([]string{"rev-parse", "HEAD^{tree}"})
+	c.Assert(err, IsNil)
+	rootTree, err := repo.GetTree(strings.TrimRight(string(output), "\n"))
+	c.Assert(err, IsNil)
+
+	entry, err := rootTree.Lookup(repo, "/src/main.go")
+	c.Assert(err, IsNil)
+	c.Check(entry.Name(), Equals, "main.go")
+
+	_, err = rootTree.Lookup(repo, "src/other.go")
+	c.Assert(err, NotNil)
+	c.Check(err, ErrorMatches, "Path 'src/other.go' not found in tree .*")
+	notFound, ok := err.(*PathNotFoundError)
+	c.Assert(ok, Equals, true)
+	c.Check(notFound.TreeSha1, Equals, rootTree.Sha1())
+
+	_, err = rootTree.Lookup(repo, "")
+	c.Check(err, NotNil)
+	c.Check(IsPathNotFound(err), Equals, false)
+}
+
+func (s *MySuite) TestTreeInstantiateMalformed(c *C) {
+	repo, _ := s.setupRepoWithReadme(c)
+
+	// Unusual names are kept as they are, not quoted
+	readmeSha1, err := repo.ResolveRevision("HEAD:README")
+	c.Assert(err, IsNil)
+	entry := rawTreeEntry(c, "100644", "odd\tname", readmeSha1)
+	tree := &Tree{
+		sha1: hashObjectLiterally(c, repo, "tree", entry),
+	}
+	c.Assert(tree.Instantiate(repo), IsNil)
+	c.Assert(len(tree.entries), Equals, 1)
+	c.Check(tree.entries[0].Name(), Equals, "odd\tname")
+	c.Check(tree.entries[0].Permissions(), Equals, "100644")
+
+	// A truncated entry is an error, not a panic
+	tree = &Tree{
+		sha1: hashObjectLiterally(c, repo, "tree", entry[:len(entry)-5]),
+	}
+	err = tree.Instantiate(repo)
+	c.Check(err, ErrorMatches, "Parsing tree .*: Truncated or malformed tree entry")
+	c.Check(tree.IsInstantiated(), Equals, false)
+}
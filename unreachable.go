@@ -0,0 +1,218 @@
+package gitobjects
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+)
+
+// An object in the object database which no ref, reflog entry or
+// index entry can reach
+type UnreachableObject struct {
+	Sha1      string
+	Type      string
+	SizeBytes int64
+
+	// True if no other unreachable object refers to this one. These are
+	// the objects "git fsck" calls dangling; the rest are only reachable
+	// through dangling objects.
+	Dangling bool
+}
+
+// The sha1 that git uses for "no object", as in a reflog entry for a new ref
+const zeroSha1 = "0000000000000000000000000000000000000000"
+
+// Find every object that cannot be reached from a ref, HEAD, a reflog entry or
+// the index; these are what "git gc" would eventually prune. The result is
+// sorted by type and then sha1.
+func (self *Repo) Unreachable(ctx context.Context) ([]*UnreachableObject, error) {
+	roots, err := self._reachabilityRoots()
+	if err != nil {
+		return nil, err
+	}
+
+	batchCheck, err := newCatFileBatchCheck(self)
+	if err != nil {
+		return nil, err
+	}
+	defer batchCheck.Close()
+
+	// Walk from every root which still exists; reflogs can name
+	// objects which were pruned long ago
+	walker := &reachabilityWalker{
+		ctx:  ctx,
+		repo: self,
+		seen: make(map[string]bool),
+	}
+	for _, root := range roots {
+		type_, _, err := batchCheck.Check(root)
+		if err != nil {
+			return nil, err
+		}
+		if type_ == "missing" {
+			continue
+		}
+		err = walker.Walk(root)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Walking objects reachable from %s", root)
+		}
+	}
+
+	// Everything else in the object database is unreachable
+	options := DefaultStreamOptions()
+	options.InstantiateObjects = false
+	options.Filter = func(obj Object) bool {
+		return !walker.seen[obj.Sha1()]
+	}
+	unreachable := make(map[string]*UnreachableObject)
+	objectChan, errorChan := self.StreamObjectsOfTypes(ctx, nil, options)
+	for obj := range objectChan {
+		unreachable[obj.Sha1()] = &UnreachableObject{
+			Sha1:     obj.Sha1(),
+			Type:     obj.Type(),
+			Dangling: true,
+		}
+	}
+	err = <-errorChan
+	if err != nil {
+		return nil, err
+	}
+
+	// Anything referred to by another unreachable object is not dangling
+	for _, object := range unreachable {
+		_, object.SizeBytes, err = batchCheck.Check(object.Sha1)
+		if err != nil {
+			return nil, err
+		}
+		referenced, err := self._referencedSha1s(object.Sha1, object.Type)
+		if err != nil {
+			return nil, err
+		}
+		for _, sha1 := range referenced {
+			if other, has := unreachable[sha1]; has {
+				other.Dangling = false
+			}
+		}
+	}
+
+	result := make([]*UnreachableObject, 0, len(unreachable))
+	for _, object := range unreachable {
+		result = append(result, object)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Type != result[j].Type {
+			return result[i].Type < result[j].Type
+		}
+		return result[i].Sha1 < result[j].Sha1
+	})
+	return result, nil
+}
+
+// The objects which an object points to directly
+func (self *Repo) _referencedSha1s(sha1 string, objectType string) ([]string, error) {
+	switch objectType {
+	case "commit":
+		commit, err := self.GetCommit(sha1)
+		if err != nil {
+			return nil, err
+		}
+		return append([]string{commit.TreeSha1()}, commit.ParentSha1s()...), nil
+	case "tree":
+		// Don't instantiate subtrees; some of them may be missing
+		tree := &Tree{
+			sha1: sha1,
+		}
+		err := tree._instantiate(self, false)
+		if err != nil {
+			return nil, err
+		}
+		referenced := make([]string, 0, len(tree.entries))
+		for _, entry := range tree.entries {
+			referenced = append(referenced, entry.sha1)
+		}
+		return referenced, nil
+	case "tag":
+		tag, err := self.GetTag(sha1)
+		if err != nil {
+			return nil, err
+		}
+		return []string{tag.ObjectSha1()}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// The sha1s of every ref, HEAD, every reflog entry and every index entry
+func (self *Repo) _reachabilityRoots() ([]string, error) {
+	roots := make([]string, 0)
+
+	output, err := self.CmdOutput([]string{"for-each-ref", "--format=%(objectname)"})
+	if err != nil {
+		return nil, errors.Wrap(err, "Listing refs")
+	}
+	roots = append(roots, strings.Fields(string(output))...)
+
+	// HEAD may be detached; it does not exist at all in a new repo
+	head, err := self.ResolveRevision("HEAD")
+	if err == nil {
+		roots = append(roots, head)
+	}
+
+	reflogRoots, err := self._reflogSha1s()
+	if err != nil {
+		return nil, err
+	}
+	roots = append(roots, reflogRoots...)
+
+	// Like "git fsck", the cache tree counts too
+	index, err := self.Index()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range index.Entries {
+		// Submodules are in another repository
+		if entry.Mode != 0160000 {
+			roots = append(roots, entry.Sha1)
+		}
+	}
+	if index.CacheTree != nil {
+		roots = append(roots, _cacheTreeSha1s(index.CacheTree)...)
+	}
+	return roots, nil
+}
+
+// The sha1s of the valid nodes of a cache tree
+func _cacheTreeSha1s(node *IndexCacheTree) []string {
+	sha1s := make([]string, 0)
+	if node.IsValid() {
+		sha1s = append(sha1s, node.Sha1)
+	}
+	for _, subtree := range node.Subtrees {
+		sha1s = append(sha1s, _cacheTreeSha1s(subtree)...)
+	}
+	return sha1s
+}
+
+// The old and new sha1s of every entry in every reflog
+func (self *Repo) _reflogSha1s() ([]string, error) {
+	refNames, err := self.ReflogRefs()
+	if err != nil {
+		return nil, err
+	}
+	sha1s := make([]string, 0)
+	for _, refName := range refNames {
+		entries, err := self.Reflog(refName)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			for _, sha1 := range []string{entry.OldSha1, entry.NewSha1} {
+				if sha1 != zeroSha1 {
+					sha1s = append(sha1s, sha1)
+				}
+			}
+		}
+	}
+	return sha1s, nil
+}
@@ -0,0 +1,74 @@
+package gitobjects
+
+import (
+	"context"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func (s *MySuite) TestUnreachable(c *C) {
+	repo, repoDir := s.setupRepoWithReadme(c)
+	addNotes(c, repo, repoDir)
+
+	runGit := func(argv ...string) string {
+		cmd := repo.Command(argv)
+		cmd.Dir = repoDir
+		output, err := cmd.Output()
+		c.Assert(err, IsNil, Commentf("git %v", argv))
+		return strings.TrimRight(string(output), "\n")
+	}
+
+	// Throw away the NOTES commit, and forget it in the reflogs
+	notesCommit := runGit("rev-parse", "HEAD")
+	notesTree := runGit("rev-parse", "HEAD^{tree}")
+	notesBlob := runGit("rev-parse", "HEAD:NOTES")
+	runGit("reset", "--hard", "HEAD~1")
+
+	// A blob that nothing has ever referred to
+	err := ioutil.WriteFile(filepath.Join(repoDir, "README"), []byte("dangling\n"), 0666)
+	c.Assert(err, IsNil)
+	danglingBlob := runGit("hash-object", "-w", "README")
+
+	// A blob which is only in the index is reachable
+	err = ioutil.WriteFile(filepath.Join(repoDir, "STAGED"), []byte("staged\n"), 0666)
+	c.Assert(err, IsNil)
+	runGit("add", "STAGED")
+	stagedBlob := runGit("rev-parse", ":STAGED")
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	defer cancelFunc()
+
+	// The reflogs still know about the NOTES commit
+	unreachable, err := repo.Unreachable(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(len(unreachable), Equals, 1)
+	c.Check(unreachable[0].Sha1, Equals, danglingBlob)
+	c.Check(unreachable[0].Type, Equals, "blob")
+	c.Check(unreachable[0].SizeBytes, Equals, int64(9))
+	c.Check(unreachable[0].Dangling, Equals, true)
+
+	runGit("reflog", "expire", "--expire=now", "--all")
+	unreachable, err = repo.Unreachable(ctx)
+	c.Assert(err, IsNil)
+
+	found := make(map[string]*UnreachableObject)
+	for _, object := range unreachable {
+		found[object.Sha1] = object
+		c.Check(object.Sha1, Not(Equals), stagedBlob)
+	}
+	c.Assert(len(found), Equals, 4)
+	c.Check(found[notesCommit].Type, Equals, "commit")
+	c.Check(found[notesCommit].Dangling, Equals, true)
+	c.Check(found[notesTree].Type, Equals, "tree")
+	c.Check(found[notesTree].Dangling, Equals, false)
+	c.Check(found[notesBlob].Dangling, Equals, false)
+	c.Check(found[danglingBlob].Dangling, Equals, true)
+
+	// Sorted by type, then sha1
+	c.Check(unreachable[0].Type, Equals, "blob")
+	c.Check(unreachable[2].Type, Equals, "commit")
+	c.Check(unreachable[3].Type, Equals, "tree")
+}
@@ -0,0 +1,326 @@
+package gitobjects
+
+// Flags for wildmatch
+const (
+	// '*' and '?' do not match '/', but "**" between slashes matches
+	// any number of directories
+	wildmatchPathname = 1 << iota
+
+	// Letters match regardless of case
+	wildmatchCaseFold
+)
+
+// The results of _dowild. Besides a match or not, a failure can tell the
+// callers above it that no later position in the text can match either.
+const (
+	wildmatchMatch = iota
+	wildmatchNoMatch
+	wildmatchAbortAll
+	wildmatchAbortToStarStar
+)
+
+// Match text against a shell glob, exactly as git's wildmatch() does for
+// .gitignore and .gitattributes patterns. Besides '*', '?' and '[...]', with
+// '!' or '^' to negate a class and "[:alpha:]" style classes, a backslash
+// makes the next character literal.
+func wildmatch(pattern string, text string, flags int) bool {
+	return _dowild([]byte(pattern), []byte(text), flags) == wildmatchMatch
+}
+
+// Characters which make the rest of a pattern more than a literal
+func _isGlobSpecial(c byte) bool {
+	return c == '*' || c == '?' || c == '[' || c == '\\'
+}
+
+func _toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func _toUpper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+func _isLower(c byte) bool { return c >= 'a' && c <= 'z' }
+func _isUpper(c byte) bool { return c >= 'A' && c <= 'Z' }
+func _isDigit(c byte) bool { return c >= '0' && c <= '9' }
+func _isAlpha(c byte) bool { return _isLower(c) || _isUpper(c) }
+
+// A port of git's dowild(). Reading past the end of either string gives
+// 0, in place of C's NUL terminator.
+func _dowild(p []byte, text []byte, flags int) int {
+	at := func(s []byte, i int) byte {
+		if i < len(s) {
+			return s[i]
+		}
+		return 0
+	}
+	caseFold := flags&wildmatchCaseFold != 0
+	pathname := flags&wildmatchPathname != 0
+
+	pi, ti := 0, 0
+	for ; pi < len(p); pi, ti = pi+1, ti+1 {
+		pCh := p[pi]
+		tCh := at(text, ti)
+		if tCh == 0 && pCh != '*' {
+			return wildmatchAbortAll
+		}
+		if caseFold {
+			tCh = _toLower(tCh)
+			pCh = _toLower(pCh)
+		}
+		switch pCh {
+		case '\\':
+			// Literal match with the next character
+			pi++
+			pCh = at(p, pi)
+			if tCh != pCh {
+				return wildmatchNoMatch
+			}
+			continue
+		case '?':
+			// Anything but '/'
+			if pathname && tCh == '/' {
+				return wildmatchNoMatch
+			}
+			continue
+		case '*':
+			var matchSlash bool
+			pi++
+			if at(p, pi) == '*' {
+				prevP := pi - 2
+				for pi++; at(p, pi) == '*'; pi++ {
+				}
+				if !pathname {
+					matchSlash = true
+				} else if (prevP < 0 || p[prevP] == '/') &&
+					(at(p, pi) == 0 || at(p, pi) == '/' || (at(p, pi) == '\\' && at(p, pi+1) == '/')) {
+					// "foo/**/bar" matches "foo/bar" too, so try
+					// matching nothing with "**/" first
+					if at(p, pi) == '/' && _dowild(p[pi+1:], text[ti:], flags) == wildmatchMatch {
+						return wildmatchMatch
+					}
+					matchSlash = true
+				} else {
+					// "**" not between slashes is just "*"
+					matchSlash = false
+				}
+			} else {
+				matchSlash = !pathname
+			}
+
+			if pi >= len(p) {
+				// A trailing "**" matches everything; a trailing "*"
+				// only matches if there are no more slashes
+				if !matchSlash {
+					for _, c := range text[ti:] {
+						if c == '/' {
+							return wildmatchNoMatch
+						}
+					}
+				}
+				return wildmatchMatch
+			} else if !matchSlash && p[pi] == '/' {
+				// One '*' followed by a slash matches up to the next slash,
+				// which the loop consumes
+				slash := -1
+				for i := ti; i < len(text); i++ {
+					if text[i] == '/' {
+						slash = i
+						break
+					}
+				}
+				if slash < 0 {
+					return wildmatchNoMatch
+				}
+				ti = slash
+				continue
+			}
+
+			for {
+				if tCh == 0 {
+					break
+				}
+				// When a literal follows the '*', skip ahead to where
+				// it next appears in the text
+				if !_isGlobSpecial(p[pi]) {
+					pCh = p[pi]
+					if caseFold {
+						pCh = _toLower(pCh)
+					}
+					for {
+						tCh = at(text, ti)
+						if tCh == 0 || (!matchSlash && tCh == '/') {
+							break
+						}
+						if caseFold {
+							tCh = _toLower(tCh)
+						}
+						if tCh == pCh {
+							break
+						}
+						ti++
+					}
+					if tCh != pCh {
+						return wildmatchNoMatch
+					}
+				}
+				matched := _dowild(p[pi:], text[ti:], flags)
+				if matched != wildmatchNoMatch {
+					if !matchSlash || matched != wildmatchAbortToStarStar {
+						return matched
+					}
+				} else if !matchSlash && tCh == '/' {
+					return wildmatchAbortToStarStar
+				}
+				ti++
+				tCh = at(text, ti)
+				if caseFold {
+					tCh = _toLower(tCh)
+				}
+			}
+			return wildmatchAbortAll
+		case '[':
+			pi++
+			pCh = at(p, pi)
+			if pCh == '^' {
+				pCh = '!'
+			}
+			negated := pCh == '!'
+			if negated {
+				pi++
+				pCh = at(p, pi)
+			}
+			var prevCh byte
+			matched := false
+			for {
+				if pCh == 0 {
+					return wildmatchAbortAll
+				}
+				if pCh == '\\' {
+					pi++
+					pCh = at(p, pi)
+					if pCh == 0 {
+						return wildmatchAbortAll
+					}
+					if tCh == pCh {
+						matched = true
+					}
+				} else if pCh == '-' && prevCh != 0 && at(p, pi+1) != 0 && at(p, pi+1) != ']' {
+					pi++
+					pCh = at(p, pi)
+					if pCh == '\\' {
+						pi++
+						pCh = at(p, pi)
+						if pCh == 0 {
+							return wildmatchAbortAll
+						}
+					}
+					if tCh <= pCh && tCh >= prevCh {
+						matched = true
+					} else if caseFold && _isLower(tCh) {
+						upper := _toUpper(tCh)
+						if upper <= pCh && upper >= prevCh {
+							matched = true
+						}
+					}
+					// So that prevCh becomes 0
+					pCh = 0
+				} else if pCh == '[' && at(p, pi+1) == ':' {
+					pi += 2
+					start := pi
+					for pCh = at(p, pi); pCh != 0 && pCh != ']'; pCh = at(p, pi) {
+						pi++
+					}
+					if pCh == 0 {
+						return wildmatchAbortAll
+					}
+					length := pi - start - 1
+					if length < 0 || p[pi-1] != ':' {
+						// No ":]", so this is a plain '['
+						pi = start - 2
+						pCh = '['
+						if tCh == pCh {
+							matched = true
+						}
+						prevCh = pCh
+						pi++
+						pCh = at(p, pi)
+						if pCh == ']' {
+							break
+						}
+						continue
+					}
+					if _matchCharClass(string(p[start:start+length]), tCh, caseFold, &matched) {
+						return wildmatchAbortAll
+					}
+					pCh = 0
+				} else if tCh == pCh {
+					matched = true
+				}
+				prevCh = pCh
+				pi++
+				pCh = at(p, pi)
+				if pCh == ']' {
+					break
+				}
+			}
+			if matched == negated || (pathname && tCh == '/') {
+				return wildmatchNoMatch
+			}
+			continue
+		default:
+			if tCh != pCh {
+				return wildmatchNoMatch
+			}
+		}
+	}
+
+	if ti < len(text) {
+		return wildmatchNoMatch
+	}
+	return wildmatchMatch
+}
+
+// Check a character against a "[:class:]". Returns true if the class
+// is not one that git knows.
+func _matchCharClass(class string, c byte, caseFold bool, matched *bool) bool {
+	var in bool
+	switch class {
+	case "alnum":
+		in = _isAlpha(c) || _isDigit(c)
+	case "alpha":
+		in = _isAlpha(c)
+	case "blank":
+		in = c == ' ' || c == '\t'
+	case "cntrl":
+		in = c < 0x20 || c == 0x7f
+	case "digit":
+		in = _isDigit(c)
+	case "graph":
+		in = c > 0x20 && c < 0x7f
+	case "lower":
+		in = _isLower(c)
+	case "print":
+		in = c >= 0x20 && c < 0x7f
+	case "punct":
+		in = c > 0x20 && c < 0x7f && !_isAlpha(c) && !_isDigit(c)
+	case "space":
+		in = c == ' ' || (c >= '\t' && c <= '\r')
+	case "upper":
+		in = _isUpper(c) || (caseFold && _isLower(c))
+	case "xdigit":
+		in = _isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+	default:
+		return true
+	}
+	if in {
+		*matched = true
+	}
+	return false
+}
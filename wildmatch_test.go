@@ -0,0 +1,72 @@
+package gitobjects
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestWildmatch(c *C) {
+	for _, test := range []struct {
+		pattern  string
+		text     string
+		flags    int
+		expected bool
+	}{
+		{"foo", "foo", 0, true},
+		{"foo", "bar", 0, false},
+		{"", "", 0, true},
+		{"???", "foo", 0, true},
+		{"??", "foo", 0, false},
+		{"*", "foo", 0, true},
+		{"f*", "foo", 0, true},
+		{"*f", "foo", 0, false},
+		{"*ob*a*r*", "foobar", 0, true},
+		{"*ab", "aaaaaaabababab", 0, true},
+		{`foo\*`, "foo*", 0, true},
+		{`foo\*bar`, "foobar", 0, false},
+		{`f\\oo`, `f\oo`, 0, true},
+		{"*[al]?", "ball", 0, true},
+		{"[ten]", "ten", 0, false},
+		{"**[!te]", "ten", 0, true},
+		{"**[!ten]", "ten", 0, false},
+		{"t[a-g]n", "ten", 0, true},
+		{"t[!a-g]n", "ten", 0, false},
+		{"t[^a-g]n", "ton", 0, true},
+		{"a[]]b", "a]b", 0, true},
+		{"a[]-]b", "a-b", 0, true},
+		{"[!]-]", "]", 0, false},
+		{"[!]-]", "a", 0, true},
+		{`\`, "", 0, false},
+		{"[[:alpha:]][[:digit:]]", "a1", 0, true},
+		{"[[:upper:]]", "a", 0, false},
+		{"[[:bogus:]]", "a", 0, false},
+		{"[[:alpha:]", "[", 0, false},
+
+		// '*' and '?' only cross slashes without wildmatchPathname
+		{"foo*", "foo/bar", 0, true},
+		{"foo*", "foo/bar", wildmatchPathname, false},
+		{"a?c", "a/c", 0, true},
+		{"a?c", "a/c", wildmatchPathname, false},
+		{"a[/]c", "a/c", wildmatchPathname, false},
+		{"foo/*/bar", "foo/a/bar", wildmatchPathname, true},
+		{"foo/*/bar", "foo/a/b/bar", wildmatchPathname, false},
+
+		// "**" between slashes matches any number of directories
+		{"foo/**/bar", "foo/bar", wildmatchPathname, true},
+		{"foo/**/bar", "foo/a/b/bar", wildmatchPathname, true},
+		{"**/foo", "foo", wildmatchPathname, true},
+		{"**/foo", "a/b/foo", wildmatchPathname, true},
+		{"**/foo", "foo", 0, false},
+		{"foo/**", "foo/a/b", wildmatchPathname, true},
+		{"foo/**", "foo", wildmatchPathname, false},
+		{"a**b", "a/b", wildmatchPathname, false},
+		{"a**b", "axxb", wildmatchPathname, true},
+
+		{"FOO", "foo", 0, false},
+		{"FOO", "foo", wildmatchCaseFold, true},
+		{"[A-C]", "b", wildmatchCaseFold, true},
+		{"[[:upper:]]", "a", wildmatchCaseFold, true},
+	} {
+		c.Check(wildmatch(test.pattern, test.text, test.flags), Equals, test.expected,
+			Commentf("pattern %q text %q flags %d", test.pattern, test.text, test.flags))
+	}
+}